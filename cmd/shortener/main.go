@@ -2,29 +2,144 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"github.com/vook88/go-url-shortener/internal/authn"
 	"github.com/vook88/go-url-shortener/internal/config"
 	logger2 "github.com/vook88/go-url-shortener/internal/logger"
+	"github.com/vook88/go-url-shortener/internal/metrics"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/ratelimit"
 	"github.com/vook88/go-url-shortener/internal/server"
+	"github.com/vook88/go-url-shortener/internal/service"
 	"github.com/vook88/go-url-shortener/internal/storage"
 )
 
 func main() {
-	cfg := config.NewConfig()
-	if err := run(cfg); err != nil {
-		panic(err)
+	cfg, err := config.NewConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		if err = cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err = run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
-func run(cfg *config.Config) error {
-	ctx := context.Background()
-	newStorage, err := storage.New(ctx, cfg)
+// run запускает сервер и останавливает его по SIGINT или SIGTERM: сначала перестаёт
+// принимать соединения и дожидается текущих запросов, затем останавливает фоновые
+// воркеры, которые дописывают накопленные данные, и закрывает хранилище.
+func run(cfg *config.Config) (err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger := logger2.New(0)
+
+	var serverOpts []server.ServerOption
+	if cfg.EnableHTTPS {
+		tlsConfig, err := server.NewTLSConfig(cfg)
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, server.WithTLS(tlsConfig))
+		if cfg.HTTPRedirectAddress != "" {
+			serverOpts = append(serverOpts, server.WithHTTPRedirect(cfg.HTTPRedirectAddress))
+		}
+	}
+
+	auth, err := authn.New(cfg)
 	if err != nil {
 		return err
 	}
+	if len(cfg.AllJWTKeys()) == 0 {
+		logger.Warn().Msg("JWT keys are not configured, using a random key: auth tokens will not survive a restart")
+	}
+	if cfg.IPHashSecret == "" {
+		logger.Warn().Msg("IP hash secret is not configured, using a random key: unique visitors will be counted anew after a restart")
+	}
 
-	logger := logger2.New(0)
-	h := server.NewHandler(ctx, cfg.BaseURL, newStorage, logger)
-	s := server.New(cfg.ServerAddress, h)
-	return s.Run()
+	newStorage, err := storage.New(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	m := metrics.New()
+	newStorage = metrics.InstrumentStorage(newStorage, storage.Backend(newStorage), m)
+	defer func() {
+		if closeErr := newStorage.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("cannot close storage: %w", closeErr))
+		}
+	}()
+
+	quotas := make(map[int]models.UserQuota, len(cfg.UserQuotas))
+	for _, quota := range cfg.UserQuotas {
+		quotas[quota.UserID] = models.UserQuota{MaxLinks: quota.MaxLinks, MaxBatchSize: quota.MaxBatchSize}
+	}
+	if err = service.SetUserQuotas(ctx, newStorage, quotas, logger); err != nil {
+		return err
+	}
+
+	// Воркеры живут дольше ctx: их останавливают только после того, как завершатся запросы
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var compaction sync.WaitGroup
+	compaction.Add(1)
+	go func() {
+		defer compaction.Done()
+		service.CompactStorage(workersCtx, newStorage, logger, cfg.SnapshotInterval)
+	}()
+
+	h := server.NewHandler(workersCtx, cfg.BaseURL, newStorage, logger,
+		server.WithTrashRetention(cfg.TrashRetention),
+		server.WithAuthenticator(auth),
+		server.WithRateLimits(ratelimit.NewMemoryLimiter(), server.RateLimits{
+			Create:   ratelimit.PerMinute(cfg.RateLimitCreate),
+			Batch:    ratelimit.PerMinute(cfg.RateLimitBatch),
+			Redirect: ratelimit.PerMinute(cfg.RateLimitRedirect),
+		}),
+		server.WithQuota(service.Quota{MaxLinks: cfg.MaxUserLinks, MaxBatchSize: cfg.MaxBatchSize}),
+		server.WithMetrics(m),
+		server.WithIPHashKey([]byte(cfg.IPHashSecret)),
+	)
+	s := server.New(cfg.ServerAddress, h, serverOpts...)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- s.Run()
+	}()
+
+	select {
+	case err = <-serverErr:
+	case <-ctx.Done():
+		logger.Info().Msg("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if shutdownErr := s.Shutdown(shutdownCtx); shutdownErr != nil {
+			err = fmt.Errorf("cannot shut down server gracefully: %w", shutdownErr)
+		}
+		if runErr := <-serverErr; runErr != nil {
+			err = errors.Join(err, runErr)
+		}
+	}
+
+	stopWorkers()
+	h.Wait()
+	compaction.Wait()
+	return err
 }
@@ -3,26 +3,38 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/vook88/go-url-shortener/internal/authn"
 	"github.com/vook88/go-url-shortener/internal/config"
+	"github.com/vook88/go-url-shortener/internal/database"
 	"github.com/vook88/go-url-shortener/internal/logger"
+	"github.com/vook88/go-url-shortener/internal/metrics"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/ratelimit"
 	"github.com/vook88/go-url-shortener/internal/server"
+	"github.com/vook88/go-url-shortener/internal/service"
 	storage2 "github.com/vook88/go-url-shortener/internal/storage"
 )
 
+// testAuth подписывает токены в тестах тем же ключом, что и обработчик из setupHandler.
+var testAuth = authn.NewEphemeral(time.Hour)
+
 func setupHandler() *server.Handler {
 	ctx := context.Background()
 	c := config.Config{}
-	mockStorage, _ := storage2.New(ctx, &c)
 	log := logger.New(0)
-	return server.NewHandler(ctx, "https://example.com", mockStorage, log)
+	mockStorage, _ := storage2.New(ctx, &c, log)
+	return server.NewHandler(ctx, "https://example.com", mockStorage, log, server.WithAuthenticator(testAuth))
 }
 
 func TestGenerateShortUrl(t *testing.T) {
@@ -115,6 +127,23 @@ func TestGetShortURL(t *testing.T) {
 	}
 }
 
+func TestGetExpiredURL(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New(0)
+	mockStorage, _ := storage2.New(ctx, &config.Config{}, log)
+	h := server.NewHandler(ctx, "https://example.com", mockStorage, log, server.WithAuthenticator(testAuth))
+
+	expiresAt := time.Now().Add(-time.Minute)
+	err := mockStorage.AddURL(ctx, 1, database.InsertURL{ShortURL: "expired", OriginalURL: "https://longurl.com/expired", ExpiresAt: &expiresAt})
+	assert.NoError(t, err)
+
+	request, _ := http.NewRequest(http.MethodGet, "/expired", nil)
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusGone, response.Code, "Истёкшая ссылка должна отвечать 410")
+	assert.Empty(t, response.Header().Get("Location"), "Истёкшая ссылка не должна перенаправлять")
+}
+
 func TestGetUserURLs(t *testing.T) {
 
 	h := setupHandler()
@@ -128,7 +157,7 @@ func TestGetUserURLs(t *testing.T) {
 	})
 
 	t.Run("With Cookie / Empty response", func(t *testing.T) {
-		encodedValue, err2 := authn.BuildJWTString(2)
+		encodedValue, err2 := testAuth.BuildJWTString(2)
 		if err2 != nil {
 			t.Errorf("Expected no error, got %v", err2)
 			return
@@ -170,3 +199,863 @@ func TestGetUserURLs(t *testing.T) {
 		assert.Equal(t, http.StatusOK, response1.Code, "Код ответа не совпадает с ожидаемым")
 	})
 }
+
+func TestShortenURLAlias(t *testing.T) {
+	h := setupHandler()
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "alias", body: `{"url": "https://longurl.com/sale", "alias": "spring-sale"}`, expectedCode: http.StatusCreated},
+		{name: "taken alias", body: `{"url": "https://longurl.com/other", "alias": "spring-sale"}`, expectedCode: http.StatusConflict},
+		{name: "reserved alias", body: `{"url": "https://longurl.com/ping", "alias": "ping"}`, expectedCode: http.StatusBadRequest},
+		{name: "invalid characters", body: `{"url": "https://longurl.com/bad", "alias": "spring sale!"}`, expectedCode: http.StatusBadRequest},
+		{name: "too short", body: `{"url": "https://longurl.com/short", "alias": "ab"}`, expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(tc.body))
+			response := httptest.NewRecorder()
+			h.ServeHTTP(response, request)
+
+			assert.Equal(t, tc.expectedCode, response.Code, "Код ответа не совпадает с ожидаемым")
+		})
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "/spring-sale", nil)
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusTemporaryRedirect, response.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, "https://longurl.com/sale", response.Header().Get("Location"))
+}
+
+func TestGetURLStats(t *testing.T) {
+	h := setupHandler()
+
+	request, _ := http.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(`{"url": "https://longurl.com/stats", "alias": "stats-link"}`))
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	cookies := response.Result().Cookies()
+	response.Result().Body.Close()
+
+	for i := 0; i < 3; i++ {
+		request, _ = http.NewRequest(http.MethodGet, "/stats-link", nil)
+		request.Header.Set("Referer", "https://news.example.com")
+		h.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	t.Run("owner", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			request, _ := http.NewRequest(http.MethodGet, "/api/user/urls/stats-link/stats", nil)
+			for _, c := range cookies {
+				request.AddCookie(c)
+			}
+			response := httptest.NewRecorder()
+			h.ServeHTTP(response, request)
+
+			var stats models.LinkStats
+			if response.Code != http.StatusOK || json.NewDecoder(response.Body).Decode(&stats) != nil {
+				return false
+			}
+			return stats.TotalClicks == 3 && len(stats.TopReferrers) == 1
+		}, 3*time.Second, 100*time.Millisecond)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		encodedValue, _ := testAuth.BuildJWTString(1000)
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/urls/stats-link/stats", nil)
+		request.AddCookie(&http.Cookie{Name: server.CookieAuthName, Value: encodedValue})
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusForbidden, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("unknown link", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/urls/unknown-link/stats", nil)
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusNotFound, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+}
+
+func TestUpdateUserURL(t *testing.T) {
+	h := setupHandler()
+
+	do := func(method, target, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/tpyo", "alias": "fix-me"}`, nil)
+	cookies := response.Result().Cookies()
+	response.Result().Body.Close()
+	do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/taken"}`, cookies)
+
+	response = do(http.MethodGet, "/api/user/urls/fix-me/history", "", cookies)
+	assert.Equal(t, http.StatusNoContent, response.Code, "Код ответа не совпадает с ожидаемым")
+
+	response = do(http.MethodPatch, "/api/user/urls/fix-me", `{"url": "https://longurl.com/typo"}`, cookies)
+	assert.Equal(t, http.StatusNoContent, response.Code, "Код ответа не совпадает с ожидаемым")
+
+	response = do(http.MethodGet, "/fix-me", "", nil)
+	assert.Equal(t, http.StatusTemporaryRedirect, response.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, "https://longurl.com/typo", response.Header().Get("Location"), "Ссылка должна вести на новый адрес")
+
+	response = do(http.MethodGet, "/api/user/urls/fix-me/history", "", cookies)
+	assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+	var history []models.URLChange
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&history))
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "https://example.com/fix-me", history[0].ShortURL)
+		assert.Equal(t, "https://longurl.com/tpyo", history[0].PreviousURL, "В истории должен остаться прежний адрес")
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		response := do(http.MethodPatch, "/api/user/urls/fix-me", `{"url": "https://longurl.com/taken"}`, cookies)
+		assert.Equal(t, http.StatusConflict, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("empty url", func(t *testing.T) {
+		response := do(http.MethodPatch, "/api/user/urls/fix-me", `{"url": ""}`, cookies)
+		assert.Equal(t, http.StatusBadRequest, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		for _, target := range []string{"javascript:alert(1)", "/relative/path", "ftp://longurl.com/file", "https://"} {
+			response := do(http.MethodPatch, "/api/user/urls/fix-me", `{"url": "`+target+`"}`, cookies)
+			assert.Equal(t, http.StatusBadRequest, response.Code, "Адрес %q не должен приниматься", target)
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		encodedValue, _ := testAuth.BuildJWTString(1000)
+		other := []*http.Cookie{{Name: server.CookieAuthName, Value: encodedValue}}
+
+		response := do(http.MethodPatch, "/api/user/urls/fix-me", `{"url": "https://evil.com"}`, other)
+		assert.Equal(t, http.StatusForbidden, response.Code, "Чужую ссылку менять нельзя")
+		response = do(http.MethodGet, "/api/user/urls/fix-me/history", "", other)
+		assert.Equal(t, http.StatusForbidden, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("unknown link", func(t *testing.T) {
+		response := do(http.MethodPatch, "/api/user/urls/unknown-link", `{"url": "https://longurl.com/x"}`, cookies)
+		assert.Equal(t, http.StatusNotFound, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+}
+
+// keyLookupCounter считает поиски API-ключей в хранилище.
+type keyLookupCounter struct {
+	storage2.URLStorage
+	lookups atomic.Int32
+}
+
+func (s *keyLookupCounter) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	s.lookups.Add(1)
+	return s.URLStorage.GetAPIKeyByHash(ctx, hash)
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New(0)
+	memoryStorage, _ := storage2.New(ctx, &config.Config{}, log)
+	mockStorage := &keyLookupCounter{URLStorage: memoryStorage}
+	h := server.NewHandler(ctx, "https://example.com", mockStorage, log,
+		server.WithAuthenticator(testAuth),
+		server.WithRateLimits(ratelimit.NewMemoryLimiter(), server.RateLimits{Create: ratelimit.PerMinute(2)}),
+	)
+
+	shorten := func(longURL string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(longURL))
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	// Анонимные запросы считаются по адресу клиента
+	response := shorten("https://longurl.com/limit1", nil)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, "2", response.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", response.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", response.Header().Get("RateLimit-Policy"))
+	cookies := response.Result().Cookies()
+	response.Result().Body.Close()
+
+	response = shorten("https://longurl.com/limit2", nil)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+
+	response = shorten("https://longurl.com/limit3", nil)
+	assert.Equal(t, http.StatusTooManyRequests, response.Code, "Лимит анонимного клиента исчерпан")
+	assert.Equal(t, "30", response.Header().Get("Retry-After"))
+	assert.Equal(t, "0", response.Header().Get("RateLimit-Remaining"))
+
+	// У пользователя с токеном своя корзина
+	response = shorten("https://longurl.com/limit3", cookies)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+
+	shortenWithKey := func(longURL, key string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(longURL))
+		request.Header.Set("Authorization", "Bearer "+key)
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+	// Выдуманные API-ключи не дают новой корзины и считаются по адресу клиента
+	for _, key := range []string{"sk_random1", "sk_random2"} {
+		response = shortenWithKey("https://longurl.com/limit4", key)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code, "Недействительный ключ %q должен считаться по адресу", key)
+	}
+
+	// У действующего API-ключа своя корзина
+	request, _ := http.NewRequest(http.MethodPost, "/api/user/keys", bytes.NewBufferString(`{"name": "limits"}`))
+	for _, c := range cookies {
+		request.AddCookie(c)
+	}
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	var created models.ResponseAPIKey
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&created))
+	mockStorage.lookups.Store(0)
+	response = shortenWithKey("https://longurl.com/limit5", created.Key)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, int32(1), mockStorage.lookups.Load(), "Ключ должен проверяться один раз на запрос")
+
+	request, _ = http.NewRequest(http.MethodGet, "/unknown", nil)
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	assert.Empty(t, response.Header().Get("RateLimit-Limit"), "Нулевой лимит отключает ограничение")
+}
+
+func TestUserQuota(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New(0)
+	mockStorage, _ := storage2.New(ctx, &config.Config{}, log)
+	h := server.NewHandler(ctx, "https://example.com", mockStorage, log,
+		server.WithAuthenticator(testAuth),
+		server.WithQuota(service.Quota{MaxLinks: 2, MaxBatchSize: 3}),
+	)
+
+	do := func(method, target, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/quota1"}`, nil)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	cookies := response.Result().Cookies()
+	response.Result().Body.Close()
+	response = do(http.MethodPost, "/", "https://longurl.com/quota2", cookies)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+
+	response = do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/quota3"}`, cookies)
+	assert.Equal(t, http.StatusForbidden, response.Code, "Квота на ссылки исчерпана")
+	assert.Equal(t, "application/problem+json", response.Header().Get("Content-Type"))
+	var problem models.Problem
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&problem))
+	assert.Equal(t, service.QuotaLinks, problem.Quota)
+	assert.Equal(t, 2, problem.Limit)
+	assert.Equal(t, 2, problem.Used)
+
+	response = do(http.MethodGet, "/api/user/quota", "", cookies)
+	assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+	var quota models.Quota
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&quota))
+	assert.Equal(t, models.Quota{MaxLinks: 2, UsedLinks: 2, MaxBatchSize: 3}, quota)
+
+	// Индивидуальная квота пользователя заменяет квоту по умолчанию
+	userID, err := testAuth.GetUserID(cookies[0].Value)
+	assert.NoError(t, err)
+	maxLinks := 10
+	assert.NoError(t, mockStorage.SetUserQuota(ctx, userID, models.UserQuota{MaxLinks: &maxLinks}))
+
+	batch := `[
+		{"correlation_id": "1", "original_url": "https://longurl.com/batch1"},
+		{"correlation_id": "2", "original_url": "https://longurl.com/batch2"},
+		{"correlation_id": "3", "original_url": "https://longurl.com/batch3"},
+		{"correlation_id": "4", "original_url": "https://longurl.com/batch4"}
+	]`
+	response = do(http.MethodPost, "/api/shorten/batch", batch, cookies)
+	assert.Equal(t, http.StatusForbidden, response.Code, "Пакет больше разрешённого")
+	problem = models.Problem{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&problem))
+	assert.Equal(t, service.QuotaBatchSize, problem.Quota)
+	assert.Equal(t, 4, problem.Requested)
+
+	response = do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/quota3"}`, cookies)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New(0)
+	mockStorage, _ := storage2.New(ctx, &config.Config{}, log)
+	m := metrics.New()
+	h := server.NewHandler(ctx, "https://example.com", metrics.InstrumentStorage(mockStorage, storage2.Backend(mockStorage), m), log,
+		server.WithAuthenticator(testAuth),
+		server.WithMetrics(m),
+	)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/metrics", "alias": "measured"}`)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	response = do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/other", "alias": "measured"}`)
+	assert.Equal(t, http.StatusConflict, response.Code, "Код ответа не совпадает с ожидаемым")
+	do(http.MethodGet, "/measured", "")
+	do(http.MethodGet, "/missing", "")
+
+	response = do(http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+	body := response.Body.String()
+	for _, line := range []string{
+		`shortener_http_requests_total{method="GET",route="/{id}",status="307"} 1`,
+		`shortener_http_requests_total{method="POST",route="/api/shorten",status="409"} 1`,
+		`shortener_redirects_total{result="hit"} 1`,
+		`shortener_redirects_total{result="miss"} 1`,
+		`shortener_duplicate_conflicts_total{kind="alias"} 1`,
+		`shortener_storage_operation_duration_seconds_count{backend="memory",operation="AddURL"} 2`,
+	} {
+		assert.Contains(t, body, line, "Метрика не найдена")
+	}
+}
+
+func TestDeleteUserURLs(t *testing.T) {
+	h := setupHandler()
+
+	request, _ := http.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(`{"url": "https://longurl.com/delete", "alias": "delete-me"}`))
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	ownerCookies := response.Result().Cookies()
+	response.Result().Body.Close()
+
+	encodedValue, _ := testAuth.BuildJWTString(1000)
+	otherCookies := []*http.Cookie{{Name: server.CookieAuthName, Value: encodedValue}}
+
+	deleteURLs := func(cookies []*http.Cookie) string {
+		request, _ := http.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewBufferString(`["delete-me"]`))
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusAccepted, response.Code, "Код ответа не совпадает с ожидаемым")
+		var job models.ResponseDeleteJob
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&job), "Ответ не содержит идентификатор задания")
+		return job.JobID
+	}
+	getJob := func(cookies []*http.Cookie, jobID string) (int, models.DeleteJob) {
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/jobs/"+jobID, nil)
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		var job models.DeleteJob
+		if response.Code == http.StatusOK {
+			_ = json.NewDecoder(response.Body).Decode(&job)
+		}
+		return response.Code, job
+	}
+	redirectCode := func() int {
+		request, _ := http.NewRequest(http.MethodGet, "/delete-me", nil)
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	t.Run("other user", func(t *testing.T) {
+		jobID := deleteURLs(otherCookies)
+		assert.Eventually(t, func() bool {
+			code, job := getJob(otherCookies, jobID)
+			return code == http.StatusOK && job.Status == models.DeleteStatusFailed && job.Failed == 1
+		}, 3*time.Second, 100*time.Millisecond)
+		assert.Equal(t, http.StatusTemporaryRedirect, redirectCode(), "Чужая ссылка не должна удаляться")
+
+		code, _ := getJob(ownerCookies, jobID)
+		assert.Equal(t, http.StatusForbidden, code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		jobID := deleteURLs(ownerCookies)
+		assert.Eventually(t, func() bool {
+			code, job := getJob(ownerCookies, jobID)
+			return code == http.StatusOK && job.Status == models.DeleteStatusSucceeded && job.Succeeded == 1
+		}, 3*time.Second, 100*time.Millisecond)
+		assert.Equal(t, http.StatusGone, redirectCode(), "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/urls/trash", nil)
+		for _, c := range ownerCookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		var trash []models.DeletedURL
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&trash), "Не удалось разобрать корзину")
+		assert.Len(t, trash, 1, "В корзине должна быть одна ссылка")
+
+		request, _ = http.NewRequest(http.MethodPost, "/api/user/urls/restore", bytes.NewBufferString(`["delete-me", "unknown"]`))
+		for _, c := range ownerCookies {
+			request.AddCookie(c)
+		}
+		response = httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		var result models.ResponseRestoreShortURL
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&result), "Не удалось разобрать ответ")
+		assert.Equal(t, []string{"delete-me"}, result.Restored)
+		assert.Equal(t, []string{"unknown"}, result.NotRestored)
+		assert.Equal(t, http.StatusTemporaryRedirect, redirectCode(), "Восстановленная ссылка должна работать")
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		code, _ := getJob(ownerCookies, "unknown")
+		assert.Equal(t, http.StatusNotFound, code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewBufferString(`["delete-me"]`))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+}
+
+func TestDeleteJobAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New(0)
+	storage, _ := storage2.New(ctx, &config.Config{}, log)
+
+	// Задачи, оставшиеся в очереди от прошлого запуска: одна ждёт повтора, другая исчерпала попытки
+	now := time.Now().UTC()
+	err := storage.EnqueueDeletes(ctx, []models.DeleteTask{
+		{JobID: "restored-job", RequestedBy: 7, CreatedAt: now, UserID: 7, ShortURL: "later", NextAttemptAt: now.Add(time.Hour)},
+		{JobID: "restored-job", RequestedBy: 7, CreatedAt: now, UserID: 7, ShortURL: "dead", NextAttemptAt: now, Attempts: 8, LastError: "boom", DeadAt: &now},
+	})
+	assert.NoError(t, err, "Не удалось поставить задачи в очередь")
+
+	h := server.NewHandler(ctx, "https://example.com", storage, log, server.WithAuthenticator(testAuth))
+	getJob := func(userID int) (int, models.DeleteJob) {
+		encodedValue, _ := testAuth.BuildJWTString(userID)
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/jobs/restored-job", nil)
+		request.AddCookie(&http.Cookie{Name: server.CookieAuthName, Value: encodedValue})
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		var job models.DeleteJob
+		if response.Code == http.StatusOK {
+			_ = json.NewDecoder(response.Body).Decode(&job)
+		}
+		return response.Code, job
+	}
+
+	assert.Eventually(t, func() bool {
+		code, _ := getJob(7)
+		return code == http.StatusOK
+	}, 3*time.Second, 10*time.Millisecond, "Задание должно восстановиться из очереди")
+	_, job := getJob(7)
+	assert.Equal(t, models.DeleteStatusPending, job.Status, "Статус задания не совпадает с ожидаемым")
+	assert.Equal(t, 1, job.Pending, "Ссылка в очереди должна ждать удаления")
+	assert.Equal(t, 1, job.Failed, "Недоставленная задача должна считаться неудачной")
+	assert.Contains(t, job.URLs, models.DeleteJobURL{ShortURL: "dead", Status: models.DeleteStatusFailed, Error: "boom"})
+
+	code, _ := getJob(8)
+	assert.Equal(t, http.StatusForbidden, code, "Чужое задание не должно быть видно")
+}
+
+func TestAPIKeys(t *testing.T) {
+	h := setupHandler()
+
+	do := func(method, target, body string, auth func(*http.Request)) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+		if auth != nil {
+			auth(request)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/keys"}`, nil)
+	cookies := response.Result().Cookies()
+	response.Result().Body.Close()
+	withCookies := func(r *http.Request) {
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+	}
+
+	response = do(http.MethodPost, "/api/user/keys", `{"name": "backend"}`, withCookies)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	var created models.ResponseAPIKey
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&created))
+	assert.NotEmpty(t, created.Key, "Ключ должен возвращаться при создании")
+
+	t.Run("list", func(t *testing.T) {
+		response := do(http.MethodGet, "/api/user/keys", "", withCookies)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.NotContains(t, response.Body.String(), created.Key, "Список не должен раскрывать ключ")
+		assert.Contains(t, response.Body.String(), created.Prefix)
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/by-key"}`, bearer(created.Key))
+		assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Empty(t, response.Result().Cookies(), "Для API-ключа не должен создаваться новый пользователь")
+
+		response = do(http.MethodGet, "/api/user/urls", "", bearer(created.Key))
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Contains(t, response.Body.String(), "https://longurl.com/keys")
+		assert.Contains(t, response.Body.String(), "https://longurl.com/by-key")
+
+		response = do(http.MethodPost, "/api/user/keys", `{}`, bearer(created.Key))
+		assert.Equal(t, http.StatusForbidden, response.Code, "API-ключом нельзя выпускать ключи")
+	})
+
+	t.Run("bearer jwt", func(t *testing.T) {
+		response := do(http.MethodGet, "/api/user/urls", "", bearer(cookies[0].Value))
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("invalid bearer", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/bad"}`, bearer("sk_unknown"))
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		target := "/api/user/keys/" + strconv.FormatInt(created.ID, 10)
+		response := do(http.MethodDelete, target, "", withCookies)
+		assert.Equal(t, http.StatusNoContent, response.Code, "Код ответа не совпадает с ожидаемым")
+
+		response = do(http.MethodGet, "/api/user/urls", "", bearer(created.Key))
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Отозванный ключ не должен работать")
+
+		response = do(http.MethodDelete, target, "", withCookies)
+		assert.Equal(t, http.StatusNotFound, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+}
+
+func TestAccounts(t *testing.T) {
+	h := setupHandler()
+
+	do := func(method, target, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	// Анонимный пользователь сокращает ссылку и регистрируется
+	response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/before-signup"}`, nil)
+	anonymousCookies := response.Result().Cookies()
+	response.Result().Body.Close()
+
+	response = do(http.MethodPost, "/api/user/register", `{"username": "Alice", "email": "alice@example.com", "password": "correct horse"}`, anonymousCookies)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	var account models.ResponseAccount
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&account))
+	assert.Equal(t, "alice", account.Username)
+	assert.Equal(t, 1, account.MergedURLs, "Ссылки анонимного пользователя должны остаться в аккаунте")
+
+	t.Run("invalid", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/user/register", `{"username": "a", "password": "short"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("taken", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/user/register", `{"username": "bob", "email": "ALICE@example.com", "password": "correct horse"}`, nil)
+		assert.Equal(t, http.StatusConflict, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/user/login", `{"login": "alice", "password": "wrong password"}`, nil)
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("login merges anonymous links", func(t *testing.T) {
+		// Cookie потеряна: новый анонимный пользователь сокращает ссылку и входит в аккаунт
+		response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/after-login"}`, nil)
+		cookies := response.Result().Cookies()
+		response.Result().Body.Close()
+
+		response = do(http.MethodPost, "/api/user/login", `{"login": "alice@example.com", "password": "correct horse"}`, cookies)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		var loggedIn models.ResponseAccount
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&loggedIn))
+		assert.Equal(t, account.UserID, loggedIn.UserID)
+		assert.Equal(t, 1, loggedIn.MergedURLs)
+
+		response = do(http.MethodGet, "/api/user/urls", "", response.Result().Cookies())
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Contains(t, response.Body.String(), "https://longurl.com/before-signup")
+		assert.Contains(t, response.Body.String(), "https://longurl.com/after-login")
+	})
+
+	t.Run("register revokes anonymous session", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/before-register"}`, nil)
+		cookies := response.Result().Cookies()
+		response.Result().Body.Close()
+		response = do(http.MethodPost, "/api/user/keys", `{"name": "anonymous"}`, cookies)
+		assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+		var created models.ResponseAPIKey
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&created))
+
+		response = do(http.MethodPost, "/api/user/register", `{"username": "carol", "password": "correct horse"}`, cookies)
+		assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+		var registered models.ResponseAccount
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&registered))
+		assert.Equal(t, 1, registered.MergedURLs)
+
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		request.Header.Set("Authorization", "Bearer "+created.Key)
+		response = httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Ключ анонимного пользователя не должен давать доступ к аккаунту")
+
+		response = do(http.MethodGet, "/api/user/urls", "", cookies)
+		assert.NotContains(t, response.Body.String(), "https://longurl.com/before-register",
+			"Cookie анонимного пользователя не должна давать доступ к аккаунту")
+	})
+
+	t.Run("login revokes anonymous API keys", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/shorten", `{"url": "https://longurl.com/anonymous-key"}`, nil)
+		cookies := response.Result().Cookies()
+		response.Result().Body.Close()
+		response = do(http.MethodPost, "/api/user/keys", `{"name": "anonymous"}`, cookies)
+		assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+		var created models.ResponseAPIKey
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&created))
+
+		response = do(http.MethodPost, "/api/user/login", `{"login": "alice", "password": "correct horse"}`, cookies)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+
+		request, _ := http.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		request.Header.Set("Authorization", "Bearer "+created.Key)
+		response = httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		assert.Equal(t, http.StatusUnauthorized, response.Code, "Ключ анонимного пользователя не должен давать доступ к аккаунту")
+	})
+}
+
+func TestOrgs(t *testing.T) {
+	h := setupHandler()
+
+	do := func(method, target, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, bytes.NewBufferString(body))
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+	// newUser создаёт анонимного пользователя и возвращает его cookie и ID
+	newUser := func(longURL string) ([]*http.Cookie, string) {
+		response := do(http.MethodPost, "/api/shorten", `{"url": "`+longURL+`"}`, nil)
+		cookies := response.Result().Cookies()
+		response.Result().Body.Close()
+		userID, err := testAuth.GetUserID(cookies[0].Value)
+		assert.NoError(t, err)
+		return cookies, strconv.Itoa(userID)
+	}
+
+	owner, ownerID := newUser("https://longurl.com/owner")
+	editor, editorID := newUser("https://longurl.com/editor")
+	viewer, viewerID := newUser("https://longurl.com/viewer")
+	outsider, _ := newUser("https://longurl.com/outsider")
+
+	response := do(http.MethodPost, "/api/orgs", `{"name": "Marketing"}`, owner)
+	assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+	var org models.Organization
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&org))
+	orgPath := "/api/orgs/" + strconv.FormatInt(org.ID, 10)
+	orgQuery := "?org_id=" + strconv.FormatInt(org.ID, 10)
+
+	response = do(http.MethodPut, orgPath+"/members/"+editorID, `{"role": "editor"}`, owner)
+	assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+	response = do(http.MethodPut, orgPath+"/members/"+viewerID, `{"role": "viewer"}`, owner)
+	assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+
+	t.Run("members", func(t *testing.T) {
+		response := do(http.MethodPut, orgPath+"/members/"+viewerID, `{"role": "owner"}`, editor)
+		assert.Equal(t, http.StatusForbidden, response.Code, "Участниками управляет только владелец")
+
+		response = do(http.MethodPut, orgPath+"/members/"+viewerID, `{"role": "admin"}`, owner)
+		assert.Equal(t, http.StatusBadRequest, response.Code, "Код ответа не совпадает с ожидаемым")
+
+		response = do(http.MethodGet, orgPath+"/members", "", viewer)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		var members []models.OrgMember
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&members))
+		assert.Len(t, members, 3)
+
+		response = do(http.MethodGet, orgPath+"/members", "", outsider)
+		assert.Equal(t, http.StatusNotFound, response.Code, "Посторонний не должен видеть организацию")
+	})
+
+	var shortURL string
+	t.Run("create links", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/shorten"+orgQuery, `{"url": "https://longurl.com/campaign"}`, editor)
+		assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+		var result models.ResponseShortURL
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+		shortURL = result.ShortURL
+
+		response = do(http.MethodPost, "/api/shorten"+orgQuery, `{"url": "https://longurl.com/by-viewer"}`, viewer)
+		assert.Equal(t, http.StatusForbidden, response.Code, "Наблюдатель не может создавать ссылки организации")
+
+		response = do(http.MethodPost, "/api/shorten"+orgQuery, `{"url": "https://longurl.com/by-outsider"}`, outsider)
+		assert.Equal(t, http.StatusNotFound, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("list and stats", func(t *testing.T) {
+		response := do(http.MethodGet, "/api/user/urls"+orgQuery, "", viewer)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Contains(t, response.Body.String(), "https://longurl.com/campaign")
+
+		response = do(http.MethodGet, "/api/user/urls", "", editor)
+		assert.NotContains(t, response.Body.String(), "https://longurl.com/campaign", "Ссылка организации не входит в личные")
+
+		id := shortURL[len("https://example.com/"):]
+		response = do(http.MethodGet, "/api/user/urls/"+id+"/stats", "", owner)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		response = do(http.MethodGet, "/api/user/urls/"+id+"/stats", "", outsider)
+		assert.Equal(t, http.StatusForbidden, response.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		id := shortURL[len("https://example.com/"):]
+		deleteAs := func(cookies []*http.Cookie) string {
+			response := do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`, cookies)
+			assert.Equal(t, http.StatusAccepted, response.Code, "Код ответа не совпадает с ожидаемым")
+			var job models.ResponseDeleteJob
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&job))
+			var status models.DeleteJob
+			assert.Eventually(t, func() bool {
+				response := do(http.MethodGet, "/api/user/jobs/"+job.JobID, "", cookies)
+				_ = json.NewDecoder(response.Body).Decode(&status)
+				return status.Status != models.DeleteStatusPending
+			}, 5*time.Second, 10*time.Millisecond)
+			return status.Status
+		}
+
+		assert.Equal(t, models.DeleteStatusFailed, deleteAs(viewer), "Наблюдатель не может удалять ссылки организации")
+		// Владелец удаляет ссылку, созданную редактором
+		assert.Equal(t, models.DeleteStatusSucceeded, deleteAs(owner))
+
+		response := do(http.MethodGet, "/api/user/urls/trash"+orgQuery, "", viewer)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Contains(t, response.Body.String(), "https://longurl.com/campaign")
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		response := do(http.MethodGet, "/api/orgs", "", viewer)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Contains(t, response.Body.String(), `"role":"viewer"`)
+
+		response = do(http.MethodDelete, orgPath+"/members/"+ownerID, "", owner)
+		assert.Equal(t, http.StatusConflict, response.Code, "Нельзя исключить последнего владельца")
+
+		response = do(http.MethodDelete, orgPath+"/members/"+viewerID, "", viewer)
+		assert.Equal(t, http.StatusNoContent, response.Code, "Участник может выйти из организации сам")
+	})
+
+	t.Run("demoted creator", func(t *testing.T) {
+		response := do(http.MethodPost, "/api/shorten"+orgQuery, `{"url": "https://longurl.com/demoted"}`, editor)
+		assert.Equal(t, http.StatusCreated, response.Code, "Код ответа не совпадает с ожидаемым")
+		var result models.ResponseShortURL
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+		id := result.ShortURL[len("https://example.com/"):]
+		redirectCode := func() int {
+			return do(http.MethodGet, "/"+id, "", nil).Code
+		}
+
+		response = do(http.MethodPut, orgPath+"/members/"+editorID, `{"role": "viewer"}`, owner)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		response = do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`, editor)
+		assert.Equal(t, http.StatusAccepted, response.Code, "Код ответа не совпадает с ожидаемым")
+		var job models.ResponseDeleteJob
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&job))
+		var status models.DeleteJob
+		assert.Eventually(t, func() bool {
+			response := do(http.MethodGet, "/api/user/jobs/"+job.JobID, "", editor)
+			_ = json.NewDecoder(response.Body).Decode(&status)
+			return status.Status != models.DeleteStatusPending
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, models.DeleteStatusFailed, status.Status, "Пониженный создатель не может удалить ссылку организации")
+		assert.Equal(t, http.StatusTemporaryRedirect, redirectCode(), "Ссылка не должна удаляться")
+
+		response = do(http.MethodDelete, "/api/user/urls", `["`+id+`"]`, owner)
+		assert.Equal(t, http.StatusAccepted, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Eventually(t, func() bool {
+			return redirectCode() == http.StatusGone
+		}, 5*time.Second, 10*time.Millisecond)
+
+		response = do(http.MethodDelete, orgPath+"/members/"+editorID, "", owner)
+		assert.Equal(t, http.StatusNoContent, response.Code, "Код ответа не совпадает с ожидаемым")
+		response = do(http.MethodPost, "/api/user/urls/restore", `["`+id+`"]`, editor)
+		assert.Equal(t, http.StatusOK, response.Code, "Код ответа не совпадает с ожидаемым")
+		var restored models.ResponseRestoreShortURL
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&restored))
+		assert.Equal(t, []string{id}, restored.NotRestored, "Исключённый создатель не может восстановить ссылку организации")
+		assert.Equal(t, http.StatusGone, redirectCode(), "Ссылка должна остаться удалённой")
+	})
+}
+
+func TestShutdownFlushesClicks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := logger.New(0)
+	s, _ := storage2.New(ctx, &config.Config{}, log)
+	h := server.NewHandler(ctx, "https://example.com", s, log)
+
+	request, _ := http.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBufferString(`{"url": "https://longurl.com/flush", "alias": "flush-me"}`))
+	h.ServeHTTP(httptest.NewRecorder(), request)
+	request, _ = http.NewRequest(http.MethodGet, "/flush-me", nil)
+	h.ServeHTTP(httptest.NewRecorder(), request)
+
+	cancel()
+	h.Wait()
+
+	clicks, err := s.GetClicks(context.Background(), "flush-me", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, clicks, 1, "Переход из буфера должен быть записан при остановке")
+}
@@ -1,11 +1,18 @@
 package authn
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
 	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/vook88/go-url-shortener/internal/config"
 )
 
 type Claims struct {
@@ -16,18 +23,163 @@ type Claims struct {
 var ErrUserIDNotFound = errors.New("userID not found in token")
 var ErrTokenIsNotValid = errors.New("token is not valid")
 
-const TokenExp = time.Hour * 24
-const SecretKey = "supersecretkey"
+// ErrUnknownKey — токен подписан ключом, которого нет в конфигурации.
+var ErrUnknownKey = errors.New("token is signed with unknown key")
+
+// DefaultTokenExp — срок действия токена, если он не задан в конфигурации.
+const DefaultTokenExp = time.Hour * 24
+
+// ephemeralKeyID — kid случайного ключа, который создаёт NewEphemeral.
+const ephemeralKeyID = "ephemeral"
+
+// key — ключ подписи токенов.
+type key struct {
+	id     string
+	method jwt.SigningMethod
+	// sign равен nil у ключей, которые только проверяют подписи.
+	sign   interface{}
+	verify interface{}
+}
+
+// Authenticator выпускает и проверяет токены пользователей. Токены подписываются одним ключом,
+// а проверяются любым из известных по заголовку kid.
+type Authenticator struct {
+	keys     map[string]key
+	signing  key
+	tokenExp time.Duration
+}
+
+// New создаёт Authenticator с ключами из cfg. Если ключи не заданы, используется случайный ключ,
+// и токены перестают действовать после перезапуска.
+func New(cfg *config.Config) (*Authenticator, error) {
+	tokenExp := cfg.TokenLifetime
+	if tokenExp <= 0 {
+		tokenExp = DefaultTokenExp
+	}
+
+	configKeys := cfg.AllJWTKeys()
+	if len(configKeys) == 0 {
+		return NewEphemeral(tokenExp), nil
+	}
+
+	a := &Authenticator{keys: make(map[string]key, len(configKeys)), tokenExp: tokenExp}
+	for _, ck := range configKeys {
+		k, err := loadKey(ck)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", ck.ID, err)
+		}
+		if _, ok := a.keys[k.id]; ok {
+			return nil, fmt.Errorf("duplicate jwt key %q", k.id)
+		}
+		a.keys[k.id] = k
+		if a.signing.sign == nil && k.sign != nil && (cfg.JWTSigningKeyID == "" || cfg.JWTSigningKeyID == k.id) {
+			a.signing = k
+		}
+	}
+	if a.signing.sign == nil {
+		return nil, errors.New("no jwt key to sign tokens with")
+	}
+	return a, nil
+}
+
+// NewEphemeral создаёт Authenticator со случайным HMAC-ключом. Подходит для тестов и разработки.
+func NewEphemeral(tokenExp time.Duration) *Authenticator {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("cannot generate jwt key: %v", err))
+	}
+	k := key{id: ephemeralKeyID, method: jwt.SigningMethodHS256, sign: secret, verify: secret}
+	return &Authenticator{keys: map[string]key{k.id: k}, signing: k, tokenExp: tokenExp}
+}
+
+func loadKey(ck config.JWTKey) (key, error) {
+	k := key{id: ck.ID}
+	switch ck.Algorithm {
+	case config.JWTAlgorithmHS256:
+		k.method = jwt.SigningMethodHS256
+		k.sign = []byte(ck.Secret)
+		k.verify = k.sign
+		return k, nil
+	case config.JWTAlgorithmRS256:
+		k.method = jwt.SigningMethodRS256
+	case config.JWTAlgorithmEdDSA:
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return k, fmt.Errorf("unknown algorithm %q", ck.Algorithm)
+	}
+
+	if ck.PrivateKeyFile != "" {
+		data, err := os.ReadFile(ck.PrivateKeyFile)
+		if err != nil {
+			return k, fmt.Errorf("cannot read private key: %w", err)
+		}
+		if k.method == jwt.SigningMethodRS256 {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return k, err
+			}
+			k.sign, k.verify = private, &private.PublicKey
+		} else {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return k, err
+			}
+			k.sign, k.verify = private, private.(crypto.Signer).Public()
+		}
+	}
+
+	// Открытый ключ из файла имеет приоритет над выведенным из закрытого
+	if ck.PublicKeyFile != "" {
+		data, err := os.ReadFile(ck.PublicKeyFile)
+		if err != nil {
+			return k, fmt.Errorf("cannot read public key: %w", err)
+		}
+		if k.method == jwt.SigningMethodRS256 {
+			k.verify, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		} else {
+			k.verify, err = jwt.ParseEdPublicKeyFromPEM(data)
+		}
+		if err != nil {
+			return k, err
+		}
+	}
+	if k.verify == nil {
+		return k, errors.New("private_key_file or public_key_file is required")
+	}
+	return k, checkKeyPair(k)
+}
+
+// checkKeyPair проверяет, что открытый ключ соответствует закрытому.
+func checkKeyPair(k key) error {
+	if k.sign == nil {
+		return nil
+	}
+	var match bool
+	switch private := k.sign.(type) {
+	case *rsa.PrivateKey:
+		public, ok := k.verify.(*rsa.PublicKey)
+		match = ok && private.PublicKey.Equal(public)
+	case ed25519.PrivateKey:
+		public, ok := k.verify.(ed25519.PublicKey)
+		match = ok && private.Public().(ed25519.PublicKey).Equal(public)
+	}
+	if !match {
+		return errors.New("public key does not match private key")
+	}
+	return nil
+}
 
-func BuildJWTString(userID int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// BuildJWTString выпускает токен пользователя, подписанный текущим ключом.
+func (a *Authenticator) BuildJWTString(userID int) (string, error) {
+	token := jwt.NewWithClaims(a.signing.method, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExp)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(a.tokenExp)),
 		},
 		UserID: userID,
 	})
+	token.Header["kid"] = a.signing.id
 
-	tokenString, err := token.SignedString([]byte(SecretKey))
+	tokenString, err := token.SignedString(a.signing.sign)
 	if err != nil {
 		return "", err
 	}
@@ -35,13 +187,20 @@ func BuildJWTString(userID int) (string, error) {
 	return tokenString, nil
 }
 
-func GetUserID(tokenString string) (int, error) {
+// GetUserID проверяет токен ключом из его заголовка kid и возвращает ID пользователя.
+func (a *Authenticator) GetUserID(tokenString string) (int, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := a.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+		}
+		// Алгоритм берём из ключа, а не из токена, иначе открытый ключ RS256 можно выдать за секрет HS256
+		if t.Method.Alg() != k.method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return []byte(SecretKey), nil
+		return k.verify, nil
 	})
 
 	if err != nil {
@@ -0,0 +1,108 @@
+package authn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/vook88/go-url-shortener/internal/config"
+)
+
+const (
+	oldSecret = "old-secret-0123456789-0123456789-0123456789"
+	newSecret = "new-secret-0123456789-0123456789-0123456789"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, err := New(&config.Config{JWTKeys: []config.JWTKey{{ID: "old", Algorithm: config.JWTAlgorithmHS256, Secret: oldSecret}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.BuildJWTString(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := New(&config.Config{
+		JWTKeys: []config.JWTKey{
+			{ID: "old", Algorithm: config.JWTAlgorithmHS256, Secret: oldSecret},
+			{ID: "new", Algorithm: config.JWTAlgorithmHS256, Secret: newSecret},
+		},
+		JWTSigningKeyID: "new",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := rotated.GetUserID(token); err != nil || userID != 42 {
+		t.Errorf("token signed with old key: got %d, %v", userID, err)
+	}
+
+	newToken, _ := rotated.BuildJWTString(43)
+	if _, err = old.GetUserID(newToken); err == nil {
+		t.Error("token signed with unknown key is accepted")
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	rsaPublicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	for _, key := range []config.JWTKey{
+		{ID: "rsa", Algorithm: config.JWTAlgorithmRS256, PrivateKeyFile: writePEM(t, "rsa.pem", "PRIVATE KEY", rsaDER)},
+		{ID: "ed", Algorithm: config.JWTAlgorithmEdDSA, PrivateKeyFile: writePEM(t, "ed.pem", "PRIVATE KEY", edDER)},
+	} {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			a, err := New(&config.Config{JWTKeys: []config.JWTKey{key}, TokenLifetime: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := a.BuildJWTString(7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userID, err := a.GetUserID(token); err != nil || userID != 7 {
+				t.Errorf("got %d, %v", userID, err)
+			}
+		})
+	}
+
+	t.Run("algorithm confusion", func(t *testing.T) {
+		publicPath := writePEM(t, "rsa.pub", "PUBLIC KEY", rsaPublicDER)
+		a, err := New(&config.Config{JWTKeys: []config.JWTKey{
+			{ID: "rsa", Algorithm: config.JWTAlgorithmRS256, PublicKeyFile: publicPath},
+			{ID: "hs", Algorithm: config.JWTAlgorithmHS256, Secret: newSecret},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicPEM, _ := os.ReadFile(publicPath)
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: 1})
+		token.Header["kid"] = "rsa"
+		forged, _ := token.SignedString(publicPEM)
+		if _, err = a.GetUserID(forged); err == nil {
+			t.Error("HS256 token signed with RSA public key is accepted")
+		}
+	})
+}
@@ -1,37 +1,507 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Поддерживаемые значения StorageBackend.
+const (
+	BackendMemory   = "memory"
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// CommandPrintConfig — аргумент командной строки, по которому сервис печатает
+// итоговую конфигурацию и завершается.
+const CommandPrintConfig = "print-config"
+
+// Config — настройки сервиса. Тег json задаёт имя поля в файле конфигурации,
+// тег secret помечает поля, которые print-config не показывает.
 type Config struct {
-	ServerAddress   string
-	BaseURL         string
-	FileStoragePath string
-	DatabaseDSN     string
+	ServerAddress    string        `json:"server_address"`
+	BaseURL          string        `json:"base_url"`
+	FileStoragePath  string        `json:"file_storage_path"`
+	DatabaseDSN      string        `json:"database_dsn" secret:"dsn"`
+	SnapshotInterval time.Duration `json:"snapshot_interval"`
+	// StorageBackend — memory, file, postgres или sqlite. Пустое значение — выбор по DATABASE_DSN и FILE_STORAGE_PATH.
+	StorageBackend string `json:"storage_backend"`
+	// StorageFallback — бэкенды, которые пробуются по порядку, если StorageBackend недоступен.
+	StorageFallback []string `json:"storage_fallback"`
+	// TrashRetention — сколько удалённые ссылки можно восстановить; затем они удаляются окончательно.
+	TrashRetention time.Duration `json:"trash_retention"`
+	// ShutdownTimeout — сколько при остановке ждать завершения текущих запросов.
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	// RateLimitCreate, RateLimitBatch и RateLimitRedirect — сколько запросов в минуту одному клиенту
+	// разрешено на создание ссылок, пакетное создание и переходы. 0 отключает ограничение.
+	RateLimitCreate   int `json:"rate_limit_create"`
+	RateLimitBatch    int `json:"rate_limit_batch"`
+	RateLimitRedirect int `json:"rate_limit_redirect"`
+	// MaxUserLinks и MaxBatchSize — квоты по умолчанию на число активных ссылок пользователя
+	// и размер пакета в /api/shorten/batch. 0 отключает квоту. Индивидуальные квоты хранятся у пользователя.
+	MaxUserLinks int `json:"max_user_links"`
+	MaxBatchSize int `json:"max_batch_size"`
+	// UserQuotas — индивидуальные квоты пользователей, которые записываются в хранилище при старте.
+	// Пользователь в списке без квот возвращается к квотам по умолчанию. Задаются только в файле конфигурации.
+	UserQuotas []UserQuota `json:"user_quotas"`
+	// IPHashSecret — ключ HMAC, которым хешируются адреса клиентов в статистике переходов.
+	// Без него ключ случайный, и после перезапуска уникальные посетители считаются заново.
+	IPHashSecret string `json:"ip_hash_secret" secret:"true"`
+
+	// EnableHTTPS включает HTTPS на ServerAddress.
+	EnableHTTPS bool `json:"enable_https"`
+	// TLSCertFile и TLSKeyFile — пути к сертификату и ключу в формате PEM.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// TLSSelfSigned — сгенерировать самоподписанный сертификат при старте. Только для разработки.
+	TLSSelfSigned bool `json:"tls_self_signed"`
+	// TLSMinVersion — минимальная версия TLS: 1.0, 1.1, 1.2 или 1.3.
+	TLSMinVersion string `json:"tls_min_version"`
+	// TLSCipherSuites — разрешённые наборы шифров для TLS 1.2 и ниже. Пустой список — набор Go по умолчанию.
+	TLSCipherSuites []string `json:"tls_cipher_suites"`
+	// HTTPRedirectAddress — адрес HTTP-листенера, перенаправляющего запросы на HTTPS. Пустое значение отключает его.
+	HTTPRedirectAddress string `json:"http_redirect_address"`
+
+	// JWTKeys — ключи подписи токенов. Новые токены подписываются ключом JWTSigningKeyID,
+	// а проверяются ключом из заголовка kid, поэтому ключи можно менять, не разлогинивая пользователей.
+	// Задаются только в файле конфигурации.
+	JWTKeys []JWTKey `json:"jwt_keys"`
+	// JWTSigningKeyID — kid ключа для подписи новых токенов. Пустое значение — первый ключ, которым можно подписывать.
+	JWTSigningKeyID string `json:"jwt_signing_key_id"`
+	// JWTSecret — HMAC-секрет ключа с kid JWTSecretKeyID для настройки одним ключом.
+	JWTSecret string `json:"jwt_secret" secret:"true"`
+	// TokenLifetime — срок действия выдаваемых токенов.
+	TokenLifetime time.Duration `json:"token_lifetime"`
+
+	// ConfigFile — файл конфигурации из флага -c или переменной CONFIG.
+	ConfigFile string `json:"-"`
+	// PrintConfig — напечатать итоговую конфигурацию вместо запуска сервера.
+	PrintConfig bool `json:"-"`
 }
 
-func NewConfig() *Config {
+// Поддерживаемые алгоритмы подписи токенов.
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// JWTSecretKeyID — kid ключа, заданного через JWTSecret.
+const JWTSecretKeyID = "default"
+
+// minJWTSecretLength — минимальная длина HMAC-секрета в байтах.
+const minJWTSecretLength = 32
+
+// JWTKey — ключ подписи токенов.
+type JWTKey struct {
+	ID string `json:"id"`
+	// Algorithm — HS256, RS256 или EdDSA.
+	Algorithm string `json:"algorithm"`
+	// Secret — секрет для HS256.
+	Secret string `json:"secret" secret:"true"`
+	// PrivateKeyFile — закрытый ключ RS256 или EdDSA в формате PEM. Ключ без него только проверяет подписи.
+	PrivateKeyFile string `json:"private_key_file"`
+	// PublicKeyFile — открытый ключ в формате PEM. Не нужен, если задан PrivateKeyFile.
+	PublicKeyFile string `json:"public_key_file"`
+}
+
+// UserQuota — индивидуальные квоты пользователя UserID. Незаданная квота — квота по умолчанию, 0 — без ограничения.
+type UserQuota struct {
+	UserID       int  `json:"user_id"`
+	MaxLinks     *int `json:"max_links,omitempty"`
+	MaxBatchSize *int `json:"max_batch_size,omitempty"`
+}
+
+// CanSign сообщает, можно ли подписывать ключом токены.
+func (k JWTKey) CanSign() bool {
+	if k.Algorithm == JWTAlgorithmHS256 {
+		return k.Secret != ""
+	}
+	return k.PrivateKeyFile != ""
+}
+
+// AllJWTKeys возвращает ключи из JWTKeys вместе с ключом, заданным через JWTSecret.
+func (c *Config) AllJWTKeys() []JWTKey {
+	keys := append([]JWTKey(nil), c.JWTKeys...)
+	if c.JWTSecret != "" {
+		keys = append(keys, JWTKey{ID: JWTSecretKeyID, Algorithm: JWTAlgorithmHS256, Secret: c.JWTSecret})
+	}
+	return keys
+}
+
+// NewConfig собирает конфигурацию из аргументов командной строки, переменных окружения и файла конфигурации.
+func NewConfig() (*Config, error) {
+	return Load(os.Args[1:], os.LookupEnv)
+}
+
+// Load собирает конфигурацию. Приоритет источников: флаги, затем переменные окружения,
+// затем файл конфигурации (-c или CONFIG, JSON или YAML), затем значения по умолчанию.
+// Все ошибки в значениях собираются и возвращаются вместе.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	// Сначала разбираем флаги отдельно, чтобы узнать путь к файлу и запомнить заданные флаги
+	var parsed Config
+	fs := newFlagSet(&parsed)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	setFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	// newFlagSet записывает в c значения по умолчанию
 	var c Config
-	flag.StringVar(&c.ServerAddress, "a", "localhost:8080", "HTTP server address")
-	flag.StringVar(&c.BaseURL, "b", "http://localhost:8080", "Base URL for shortened URLs")
-	flag.StringVar(&c.FileStoragePath, "f", "", "Path for storage file")
-	flag.StringVar(&c.DatabaseDSN, "d", "", "Database DSN")
-	flag.Parse()
+	flags := newFlagSet(&c)
 
-	if envServerAddress, exists := os.LookupEnv("SERVER_ADDRESS"); exists {
-		c.ServerAddress = envServerAddress
+	var errs []error
+	c.ConfigFile = parsed.ConfigFile
+	if c.ConfigFile == "" {
+		c.ConfigFile, _ = lookupEnv("CONFIG")
 	}
-	if envBaseURL, exists := os.LookupEnv("BASE_URL"); exists {
-		c.BaseURL = envBaseURL
+	if c.ConfigFile != "" {
+		fileErrs, err := loadFile(&c, c.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, fileErrs...)
 	}
-	if envFileStoragePage, exists := os.LookupEnv("FILE_STORAGE_PATH"); exists {
-		c.FileStoragePath = envFileStoragePage
+
+	errs = append(errs, c.loadEnv(lookupEnv)...)
+
+	for name, value := range setFlags {
+		if err := flags.Set(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", name, err))
+		}
+	}
+
+	switch command := fs.Arg(0); command {
+	case "":
+	case CommandPrintConfig:
+		c.PrintConfig = true
+	default:
+		errs = append(errs, fmt.Errorf("unknown command %q", command))
+	}
+
+	errs = append(errs, c.Validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &c, nil
+}
+
+func newFlagSet(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&c.ConfigFile, "c", "", "Path to JSON or YAML configuration file")
+	fs.StringVar(&c.ServerAddress, "a", "localhost:8080", "HTTP server address")
+	fs.StringVar(&c.BaseURL, "b", "http://localhost:8080", "Base URL for shortened URLs")
+	fs.StringVar(&c.FileStoragePath, "f", "", "Path for storage file")
+	fs.StringVar(&c.DatabaseDSN, "d", "", "Database DSN")
+	fs.DurationVar(&c.SnapshotInterval, "snapshot-interval", 5*time.Minute, "Interval between storage file snapshots, 0 disables them")
+	fs.StringVar(&c.StorageBackend, "storage", "", "Storage backend: memory, file, postgres or sqlite")
+	fs.Var((*listValue)(&c.StorageFallback), "storage-fallback", "Comma-separated storage backends to try if the main one is unavailable")
+	fs.DurationVar(&c.TrashRetention, "trash-retention", 30*24*time.Hour, "How long deleted URLs can be restored before they are purged")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "How long to wait for in-flight requests on shutdown")
+	fs.IntVar(&c.RateLimitCreate, "rate-limit-create", 60, "Requests per minute per client to create URLs, 0 disables the limit")
+	fs.IntVar(&c.RateLimitBatch, "rate-limit-batch", 10, "Batch requests per minute per client, 0 disables the limit")
+	fs.IntVar(&c.RateLimitRedirect, "rate-limit-redirect", 600, "Redirects per minute per client, 0 disables the limit")
+	fs.IntVar(&c.MaxUserLinks, "max-user-links", 0, "Default maximum number of active links per user, 0 disables the quota")
+	fs.IntVar(&c.MaxBatchSize, "max-batch-size", 1000, "Default maximum number of URLs in one batch request, 0 disables the quota")
+	fs.StringVar(&c.IPHashSecret, "ip-hash-secret", "", "HMAC secret for hashing client IP addresses in click stats")
+	fs.BoolVar(&c.EnableHTTPS, "s", false, "Enable HTTPS")
+	fs.StringVar(&c.TLSCertFile, "tls-cert", "", "Path to TLS certificate in PEM format")
+	fs.StringVar(&c.TLSKeyFile, "tls-key", "", "Path to TLS private key in PEM format")
+	fs.BoolVar(&c.TLSSelfSigned, "tls-self-signed", false, "Generate a self-signed TLS certificate on startup (development only)")
+	fs.StringVar(&c.TLSMinVersion, "tls-min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	fs.Var((*listValue)(&c.TLSCipherSuites), "tls-ciphers", "Comma-separated TLS cipher suites for TLS 1.2 and below")
+	fs.StringVar(&c.HTTPRedirectAddress, "http-redirect-address", "", "Address of an HTTP listener redirecting to HTTPS")
+	fs.StringVar(&c.JWTSigningKeyID, "jwt-signing-key-id", "", "Key ID (kid) of the JWT key used to sign new tokens")
+	fs.StringVar(&c.JWTSecret, "jwt-secret", "", "HMAC secret of the JWT key \""+JWTSecretKeyID+"\"")
+	fs.DurationVar(&c.TokenLifetime, "token-lifetime", 24*time.Hour, "Lifetime of issued auth tokens")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [%s]\n", fs.Name(), CommandPrintConfig)
+		fs.PrintDefaults()
 	}
-	if envDatabaseDSN, exists := os.LookupEnv("DATABASE_DSN"); exists {
-		c.DatabaseDSN = envDatabaseDSN
+	return fs
+}
+
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) []error {
+	env := envLoader{lookup: lookupEnv}
+	env.string("SERVER_ADDRESS", &c.ServerAddress)
+	env.string("BASE_URL", &c.BaseURL)
+	env.string("FILE_STORAGE_PATH", &c.FileStoragePath)
+	env.string("DATABASE_DSN", &c.DatabaseDSN)
+	env.string("STORAGE_BACKEND", &c.StorageBackend)
+	env.list("STORAGE_FALLBACK", &c.StorageFallback)
+	env.duration("FILE_STORAGE_SNAPSHOT_INTERVAL", &c.SnapshotInterval)
+	env.duration("TRASH_RETENTION", &c.TrashRetention)
+	env.duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	env.int("RATE_LIMIT_CREATE", &c.RateLimitCreate)
+	env.int("RATE_LIMIT_BATCH", &c.RateLimitBatch)
+	env.int("RATE_LIMIT_REDIRECT", &c.RateLimitRedirect)
+	env.int("MAX_USER_LINKS", &c.MaxUserLinks)
+	env.int("MAX_BATCH_SIZE", &c.MaxBatchSize)
+	env.string("IP_HASH_SECRET", &c.IPHashSecret)
+	env.bool("ENABLE_HTTPS", &c.EnableHTTPS)
+	env.string("TLS_CERT_FILE", &c.TLSCertFile)
+	env.string("TLS_KEY_FILE", &c.TLSKeyFile)
+	env.bool("TLS_SELF_SIGNED", &c.TLSSelfSigned)
+	env.string("TLS_MIN_VERSION", &c.TLSMinVersion)
+	env.list("TLS_CIPHER_SUITES", &c.TLSCipherSuites)
+	env.string("HTTP_REDIRECT_ADDRESS", &c.HTTPRedirectAddress)
+	env.string("JWT_SIGNING_KEY_ID", &c.JWTSigningKeyID)
+	env.string("JWT_SECRET", &c.JWTSecret)
+	env.duration("TOKEN_LIFETIME", &c.TokenLifetime)
+	return env.errs
+}
+
+// Validate проверяет значения всех полей и возвращает по ошибке на каждое неверное.
+func (c *Config) Validate() []error {
+	var errs []error
+	invalid := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
 	}
 
-	return &c
+	if _, _, err := net.SplitHostPort(c.ServerAddress); err != nil {
+		invalid("server_address", "%v", err)
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalid("base_url", "must be an absolute http or https URL, got %q", c.BaseURL)
+	}
+	if c.SnapshotInterval < 0 {
+		invalid("snapshot_interval", "must not be negative")
+	}
+	if c.StorageBackend != "" && !isBackend(c.StorageBackend) {
+		invalid("storage_backend", "unknown backend %q", c.StorageBackend)
+	}
+	for _, backend := range c.StorageFallback {
+		if !isBackend(backend) {
+			invalid("storage_fallback", "unknown backend %q", backend)
+		}
+	}
+	if c.TrashRetention <= 0 {
+		invalid("trash_retention", "must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout", "must be positive")
+	}
+	if c.RateLimitCreate < 0 {
+		invalid("rate_limit_create", "must not be negative")
+	}
+	if c.RateLimitBatch < 0 {
+		invalid("rate_limit_batch", "must not be negative")
+	}
+	if c.RateLimitRedirect < 0 {
+		invalid("rate_limit_redirect", "must not be negative")
+	}
+	if c.MaxUserLinks < 0 {
+		invalid("max_user_links", "must not be negative")
+	}
+	if c.MaxBatchSize < 0 {
+		invalid("max_batch_size", "must not be negative")
+	}
+	errs = append(errs, c.validateUserQuotas()...)
+	switch c.TLSMinVersion {
+	case "1.0", "1.1", "1.2", "1.3":
+	default:
+		invalid("tls_min_version", "must be one of 1.0, 1.1, 1.2, 1.3, got %q", c.TLSMinVersion)
+	}
+	if c.EnableHTTPS {
+		if c.TLSSelfSigned && (c.TLSCertFile != "" || c.TLSKeyFile != "") {
+			invalid("tls_self_signed", "cannot be combined with tls_cert_file and tls_key_file")
+		}
+		if !c.TLSSelfSigned && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+			invalid("tls_cert_file", "HTTPS requires tls_cert_file and tls_key_file or tls_self_signed")
+		}
+	}
+	if c.HTTPRedirectAddress != "" {
+		if !c.EnableHTTPS {
+			invalid("http_redirect_address", "requires enable_https")
+		}
+		if _, _, err := net.SplitHostPort(c.HTTPRedirectAddress); err != nil {
+			invalid("http_redirect_address", "%v", err)
+		}
+	}
+	errs = append(errs, c.validateJWTKeys()...)
+	if c.TokenLifetime <= 0 {
+		invalid("token_lifetime", "must be positive")
+	}
+	return errs
+}
+
+func (c *Config) validateJWTKeys() []error {
+	var errs []error
+	invalid := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	keys := c.AllJWTKeys()
+	ids := make(map[string]JWTKey, len(keys))
+	for i, key := range keys {
+		field := fmt.Sprintf("jwt_keys[%d]", i)
+		if key.ID == JWTSecretKeyID && i == len(keys)-1 && c.JWTSecret != "" {
+			field = "jwt_secret"
+		}
+		if key.ID == "" {
+			invalid(field, "id is required")
+		} else if _, ok := ids[key.ID]; ok {
+			invalid(field, "duplicate id %q", key.ID)
+		}
+		ids[key.ID] = key
+
+		switch key.Algorithm {
+		case JWTAlgorithmHS256:
+			if len(key.Secret) < minJWTSecretLength {
+				invalid(field, "HS256 secret must be at least %d bytes", minJWTSecretLength)
+			}
+			if key.PrivateKeyFile != "" || key.PublicKeyFile != "" {
+				invalid(field, "HS256 key cannot have key files")
+			}
+		case JWTAlgorithmRS256, JWTAlgorithmEdDSA:
+			if key.Secret != "" {
+				invalid(field, "%s key cannot have a secret", key.Algorithm)
+			}
+			if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+				invalid(field, "%s key requires private_key_file or public_key_file", key.Algorithm)
+			}
+		default:
+			invalid(field, "unknown algorithm %q, must be one of %s, %s, %s",
+				key.Algorithm, JWTAlgorithmHS256, JWTAlgorithmRS256, JWTAlgorithmEdDSA)
+		}
+	}
+
+	if c.JWTSigningKeyID != "" {
+		key, ok := ids[c.JWTSigningKeyID]
+		switch {
+		case !ok:
+			invalid("jwt_signing_key_id", "unknown key %q", c.JWTSigningKeyID)
+		case !key.CanSign():
+			invalid("jwt_signing_key_id", "key %q has no private key", c.JWTSigningKeyID)
+		}
+	} else if len(keys) > 0 && !anyCanSign(keys) {
+		invalid("jwt_keys", "no key can sign tokens")
+	}
+	return errs
+}
+
+func (c *Config) validateUserQuotas() []error {
+	var errs []error
+	invalid := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	seen := make(map[int]bool, len(c.UserQuotas))
+	for i, quota := range c.UserQuotas {
+		field := fmt.Sprintf("user_quotas[%d]", i)
+		if quota.UserID <= 0 {
+			invalid(field, "user_id must be positive")
+		} else if seen[quota.UserID] {
+			invalid(field, "duplicate user_id %d", quota.UserID)
+		}
+		seen[quota.UserID] = true
+		if quota.MaxLinks != nil && *quota.MaxLinks < 0 {
+			invalid(field, "max_links must not be negative")
+		}
+		if quota.MaxBatchSize != nil && *quota.MaxBatchSize < 0 {
+			invalid(field, "max_batch_size must not be negative")
+		}
+	}
+	return errs
+}
+
+func anyCanSign(keys []JWTKey) bool {
+	for _, key := range keys {
+		if key.CanSign() {
+			return true
+		}
+	}
+	return false
+}
+
+func isBackend(name string) bool {
+	switch name {
+	case BackendMemory, BackendFile, BackendPostgres, BackendSQLite:
+		return true
+	}
+	return false
+}
+
+// envLoader читает переменные окружения и копит ошибки разбора.
+type envLoader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *envLoader) string(name string, dst *string) {
+	if v, exists := e.lookup(name); exists {
+		*dst = v
+	}
+}
+
+func (e *envLoader) list(name string, dst *[]string) {
+	if v, exists := e.lookup(name); exists {
+		*dst = splitList(v)
+	}
+}
+
+func (e *envLoader) duration(name string, dst *time.Duration) {
+	if v, exists := e.lookup(name); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("env %s: %w", name, err))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (e *envLoader) int(name string, dst *int) {
+	if v, exists := e.lookup(name); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("env %s: %w", name, err))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envLoader) bool(name string, dst *bool) {
+	if v, exists := e.lookup(name); exists {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("env %s: %w", name, err))
+			return
+		}
+		*dst = b
+	}
+}
+
+// listValue — флаг со списком значений через запятую.
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = splitList(s)
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -3,3 +3,9 @@ package contextkeys
 type contextKey string
 
 const UserIDKey contextKey = "userID"
+
+// AuthMethodKey — способ, которым пользователь подтвердил личность.
+const AuthMethodKey contextKey = "authMethod"
+
+// CredentialsKey — результат проверки учётных данных, сохранённый до аутентификации.
+const CredentialsKey contextKey = "credentials"
@@ -3,13 +3,15 @@ package database
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -18,6 +20,11 @@ import (
 	"github.com/vook88/go-url-shortener/internal/models"
 )
 
+// Миграции встраиваются в бинарник, чтобы они находились независимо от рабочего каталога.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 type DB struct {
 	db *sql.DB
 }
@@ -37,15 +44,22 @@ func (d *DB) Ping(ctx context.Context) error {
 	return nil
 }
 
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
 func (d *DB) RunMigrations() error {
 	driver, err := postgres.WithInstance(d.db, &postgres.Config{})
 	if err != nil {
 		return err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://internal/database/migrations",
-		"mydatabase", driver)
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "mydatabase", driver)
 
 	if err != nil {
 		return err
@@ -59,9 +73,14 @@ func (d *DB) RunMigrations() error {
 	return err
 }
 
-func (d *DB) getShortURLByLongURL(ctx context.Context, id string) (string, bool, error) {
+func (d *DB) getShortURLByLongURL(ctx context.Context, userID int, url InsertURL) (string, bool, error) {
 	var shortURL string
-	err := d.db.QueryRowContext(ctx, "SELECT short_url FROM url_mappings WHERE long_url = $1", id).Scan(&shortURL)
+	var err error
+	if url.OrgID != 0 {
+		err = d.db.QueryRowContext(ctx, "SELECT short_url FROM url_mappings WHERE long_url = $1 AND org_id = $2", url.OriginalURL, url.OrgID).Scan(&shortURL)
+	} else {
+		err = d.db.QueryRowContext(ctx, "SELECT short_url FROM url_mappings WHERE long_url = $1 AND user_id = $2 AND org_id IS NULL", url.OriginalURL, userID).Scan(&shortURL)
+	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", false, nil
@@ -71,12 +90,15 @@ func (d *DB) getShortURLByLongURL(ctx context.Context, id string) (string, bool,
 	return shortURL, true, nil
 }
 
-func (d *DB) AddURL(ctx context.Context, userID int, id string, url string) error {
-	_, err := d.db.ExecContext(ctx, "INSERT INTO url_mappings (short_url, long_url, user_id) VALUES ($1, $2, $3)", id, url, userID)
+func (d *DB) AddURL(ctx context.Context, userID int, url InsertURL) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO url_mappings (short_url, long_url, user_id, expires_at, org_id) VALUES ($1, $2, $3, $4, $5)", url.ShortURL, url.OriginalURL, userID, url.ExpiresAt, nullID(url.OrgID))
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) {
+		if isShortURLViolation(pgErr) {
+			return errors2.ErrShortURLTaken
+		}
 		if pgErr.Code == pgerrcode.UniqueViolation {
-			shortURL, _, err2 := d.getShortURLByLongURL(ctx, url)
+			shortURL, _, err2 := d.getShortURLByLongURL(ctx, userID, url)
 			if err2 != nil {
 				return err2
 			}
@@ -89,6 +111,10 @@ func (d *DB) AddURL(ctx context.Context, userID int, id string, url string) erro
 type InsertURL struct {
 	ShortURL    string
 	OriginalURL string
+	// ExpiresAt — срок действия ссылки, nil — бессрочная.
+	ExpiresAt *time.Time
+	// OrgID — организация, которой принадлежит ссылка; 0 — личная ссылка пользователя.
+	OrgID int64
 }
 
 func (d *DB) BatchAddURL(ctx context.Context, userID int, urls []InsertURL) error {
@@ -96,27 +122,38 @@ func (d *DB) BatchAddURL(ctx context.Context, userID int, urls []InsertURL) erro
 	if err != nil {
 		return err
 	}
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO url_mappings (short_url, long_url, user_id) VALUES ($1, $2, $3)")
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO url_mappings (short_url, long_url, user_id, expires_at, org_id) VALUES ($1, $2, $3, $4, $5)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 	for _, url := range urls {
-		_, err = stmt.ExecContext(ctx, url.ShortURL, url.OriginalURL, userID)
+		_, err = stmt.ExecContext(ctx, url.ShortURL, url.OriginalURL, userID, url.ExpiresAt, nullID(url.OrgID))
 		if err != nil {
-			tx.Rollback()
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && isShortURLViolation(pgErr) {
+				return errors2.ErrShortURLTaken
+			}
 			return err
 		}
 	}
 	return tx.Commit()
 }
 
+// isShortURLViolation сообщает, что вставка нарушила уникальность short_url.
+func isShortURLViolation(pgErr *pgconn.PgError) bool {
+	return pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == "url_mappings_short_url_key"
+}
+
 func (d *DB) GetURL(ctx context.Context, id string) (string, bool, error) {
 	var row struct {
 		url       string       `db:"long_url"`
 		deletedAt sql.NullTime `db:"deleted_at"`
+		expired   bool
 	}
-	err := d.db.QueryRowContext(ctx, "SELECT long_url, deleted_at FROM url_mappings WHERE short_url = $1", id).Scan(&row.url, &row.deletedAt)
+	err := d.db.QueryRowContext(ctx, "SELECT long_url, deleted_at, expires_at <= $2 IS TRUE FROM url_mappings WHERE short_url = $1", id, time.Now().UTC()).Scan(&row.url, &row.deletedAt, &row.expired)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", false, nil
@@ -126,6 +163,9 @@ func (d *DB) GetURL(ctx context.Context, id string) (string, bool, error) {
 	if row.deletedAt.Valid {
 		return "", false, errors2.ErrURLDeleted
 	}
+	if row.expired {
+		return "", false, errors2.ErrURLExpired
+	}
 	return row.url, true, nil
 }
 
@@ -139,44 +179,836 @@ func (d *DB) AddUser(ctx context.Context) (int, error) {
 }
 
 func (d *DB) GetUserURLs(ctx context.Context, userID int) (models.BatchUserURLs, error) {
-	rows, err := d.db.QueryContext(ctx, "SELECT long_url as original_url, short_url FROM url_mappings WHERE user_id = $1", userID)
+	rows, err := d.db.QueryContext(ctx, "SELECT long_url as original_url, short_url, expires_at FROM url_mappings WHERE user_id = $1 AND org_id IS NULL AND deleted_at IS NULL", userID)
 	if err != nil {
 		return nil, err
 	}
-	if rows.Err() != nil {
-		return nil, rows.Err()
-	}
+	return scanUserURLs(rows)
+}
+
+// scanUserURLs читает ссылки из результата запроса long_url, short_url, expires_at. Общая для Postgres и SQLite.
+func scanUserURLs(rows *sql.Rows) (models.BatchUserURLs, error) {
 	defer rows.Close()
+
 	var urls models.BatchUserURLs
 	for rows.Next() {
 		var url models.UserURL
-		err = rows.Scan(&url.OriginalURL, &url.ShortURL)
-		if err != nil {
+		if err := rows.Scan(&url.OriginalURL, &url.ShortURL, &url.ExpiresAt); err != nil {
 			return nil, err
 		}
 		urls = append(urls, url)
 	}
-	return urls, nil
+	return urls, rows.Err()
 }
 
-func (d *DB) BatchDeleteURLs(ctx context.Context, urls []string) error {
+func (d *DB) BatchDeleteURLs(ctx context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
 	if len(urls) == 0 {
-		return nil // Нет URL для удаления
+		return nil, nil // Нет URL для удаления
 	}
 
+	// Удаляются только пары (short_url, user_id), то есть только ссылки их владельцев
+	placeholders, args := urlPairs(urls, time.Now().UTC())
+	query := fmt.Sprintf("UPDATE url_mappings SET deleted_at = COALESCE(deleted_at, $1) WHERE (short_url, user_id) IN (%s) RETURNING short_url, user_id", placeholders)
+
+	return queryDeletedURLs(ctx, d.db, query, args...)
+}
+
+// urlPairs возвращает список ($2, $3), ($4, $5), ... для пар (short_url, user_id)
+// и аргументы запроса, начиная с first.
+func urlPairs(urls []models.DeleteURL, first interface{}) (string, []interface{}) {
 	placeholders := make([]string, len(urls))
-	args := make([]interface{}, len(urls))
+	args := make([]interface{}, 0, len(urls)*2+1)
+	args = append(args, first)
 	for i, url := range urls {
-		placeholders[i] = fmt.Sprintf("$%d", i+1)
-		args[i] = url
+		placeholders[i] = fmt.Sprintf("($%d, $%d)", 2*i+2, 2*i+3)
+		args = append(args, url.ShortURL, url.UserID)
+	}
+	return strings.Join(placeholders, ","), args
+}
+
+func (d *DB) GetDeletedURLs(ctx context.Context, userID int) ([]models.DeletedURL, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, long_url, deleted_at FROM url_mappings WHERE user_id = $1 AND org_id IS NULL AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", userID)
+	if err != nil {
+		return nil, err
 	}
-	query := fmt.Sprintf("UPDATE url_mappings SET deleted_at = NOW() WHERE short_url IN (%s)", strings.Join(placeholders, ","))
+	return scanDeletedURLs(rows)
+}
+
+func (d *DB) RestoreURLs(ctx context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args := urlPairs(urls, deletedAfter.UTC())
+	query := fmt.Sprintf("UPDATE url_mappings SET deleted_at = NULL WHERE (short_url, user_id) IN (%s) AND deleted_at >= $1 RETURNING short_url, user_id", placeholders)
 
-	// Выполняем запрос
-	_, err := d.db.ExecContext(ctx, query, args...)
+	return queryDeletedURLs(ctx, d.db, query, args...)
+}
+
+func (d *DB) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return purgeDeletedURLs(ctx, d.db, []string{
+		"DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM url_mappings WHERE deleted_at < $1)",
+		"DELETE FROM url_history WHERE short_url IN (SELECT short_url FROM url_mappings WHERE deleted_at < $1)",
+		"DELETE FROM url_mappings WHERE deleted_at < $1",
+	}, deletedBefore.UTC())
+}
+
+// purgeDeletedURLs удаляет в одной транзакции переходы, историю и сами ссылки, удалённые раньше deletedBefore,
+// и возвращает число удалённых ссылок. Последний запрос должен удалять ссылки. Общая для Postgres и SQLite.
+func purgeDeletedURLs(ctx context.Context, db *sql.DB, queries []string, deletedBefore time.Time) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var res sql.Result
+	for _, query := range queries {
+		if res, err = tx.ExecContext(ctx, query, deletedBefore); err != nil {
+			return 0, err
+		}
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+func scanDeletedURLs(rows *sql.Rows) ([]models.DeletedURL, error) {
+	defer rows.Close()
+
+	var urls []models.DeletedURL
+	for rows.Next() {
+		var url models.DeletedURL
+		if err := rows.Scan(&url.ShortURL, &url.OriginalURL, &url.DeletedAt); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
+
+// queryDeletedURLs выполняет запрос удаления с RETURNING short_url, user_id
+// и возвращает удалённые ссылки. Общая для Postgres и SQLite.
+func queryDeletedURLs(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]models.DeleteURL, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deleted []models.DeleteURL
+	for rows.Next() {
+		var url models.DeleteURL
+		if err = rows.Scan(&url.ShortURL, &url.UserID); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, url)
+	}
+	return deleted, rows.Err()
+}
+
+func (d *DB) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	res, err := d.db.ExecContext(ctx, "UPDATE url_mappings SET deleted_at = $1 WHERE expires_at <= $1 AND deleted_at IS NULL", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (d *DB) AddClicks(ctx context.Context, clicks []models.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash) VALUES ($1, $2, $3, $4, $5)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ShortURL, click.ClickedAt.UTC(), click.Referrer, click.UserAgent, click.IPHash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *DB) GetClicks(ctx context.Context, id string, from, to time.Time) ([]models.Click, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, clicked_at, referrer, user_agent, ip_hash FROM clicks WHERE short_url = $1 AND clicked_at >= $2 AND clicked_at < $3 ORDER BY clicked_at", id, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clicks []models.Click
+	for rows.Next() {
+		var click models.Click
+		if err = rows.Scan(&click.ShortURL, &click.ClickedAt, &click.Referrer, &click.UserAgent, &click.IPHash); err != nil {
+			return nil, err
+		}
+		clicks = append(clicks, click)
+	}
+	return clicks, rows.Err()
+}
+
+func (d *DB) GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	const where = "FROM clicks WHERE short_url = $1 AND clicked_at >= $2 AND clicked_at < $3"
+	return queryClickStats(ctx, d.db, clickStatsQueries{
+		totals:        "SELECT COUNT(*), COUNT(DISTINCT NULLIF(ip_hash, '')) " + where,
+		perDay:        "SELECT date_trunc('day', clicked_at) AS start, COUNT(*) " + where + " GROUP BY start ORDER BY start",
+		perHour:       "SELECT date_trunc('hour', clicked_at) AS start, COUNT(*) " + where + " GROUP BY start ORDER BY start",
+		topReferrers:  "SELECT referrer, COUNT(*) AS clicks " + where + " AND referrer <> '' GROUP BY referrer ORDER BY clicks DESC, referrer LIMIT $4",
+		topUserAgents: "SELECT user_agent, COUNT(*) AS clicks " + where + " AND user_agent <> '' GROUP BY user_agent ORDER BY clicks DESC, user_agent LIMIT $4",
+	}, id, from.UTC(), to.UTC(), top)
+}
+
+// clickStatsQueries — запросы статистики переходов. Параметры запросов: ссылка, начало и конец периода,
+// а у запросов top ещё и лимит. Запросы по дням и часам возвращают начало интервала и число переходов.
+type clickStatsQueries struct {
+	totals        string
+	perDay        string
+	perHour       string
+	topReferrers  string
+	topUserAgents string
+}
+
+// queryClickStats считает статистику переходов в базе, не выгружая сами переходы. Общая для Postgres и SQLite.
+func queryClickStats(ctx context.Context, db *sql.DB, q clickStatsQueries, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	var stats models.ClickStats
+	err := db.QueryRowContext(ctx, q.totals, id, from, to).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return models.ClickStats{}, err
+	}
+	if stats.ClicksPerDay, err = queryTimeBuckets(ctx, db, q.perDay, id, from, to); err != nil {
+		return models.ClickStats{}, err
+	}
+	if stats.ClicksPerHour, err = queryTimeBuckets(ctx, db, q.perHour, id, from, to); err != nil {
+		return models.ClickStats{}, err
+	}
+	if stats.TopReferrers, err = queryTopItems(ctx, db, q.topReferrers, id, from, to, top); err != nil {
+		return models.ClickStats{}, err
+	}
+	if stats.TopUserAgents, err = queryTopItems(ctx, db, q.topUserAgents, id, from, to, top); err != nil {
+		return models.ClickStats{}, err
+	}
+	return stats, nil
+}
+
+// bucketLayout — формат начала интервала, если база возвращает его строкой, как SQLite.
+const bucketLayout = "2006-01-02 15"
+
+func queryTimeBuckets(ctx context.Context, db *sql.DB, query string, args ...any) ([]models.TimeBucket, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []models.TimeBucket
+	for rows.Next() {
+		var start any
+		var bucket models.TimeBucket
+		if err = rows.Scan(&start, &bucket.Clicks); err != nil {
+			return nil, err
+		}
+		switch v := start.(type) {
+		case time.Time:
+			bucket.Start = v.UTC()
+		case string:
+			if bucket.Start, err = time.ParseInLocation(bucketLayout, v, time.UTC); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected bucket start %T", start)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+func queryTopItems(ctx context.Context, db *sql.DB, query string, args ...any) ([]models.TopItem, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	var items []models.TopItem
+	for rows.Next() {
+		var item models.TopItem
+		if err = rows.Scan(&item.Value, &item.Clicks); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (d *DB) GetURLOwner(ctx context.Context, id string) (models.URLOwner, bool, error) {
+	return queryURLOwner(ctx, d.db, "SELECT user_id, org_id FROM url_mappings WHERE short_url = $1", id)
+}
+
+// queryURLOwner читает владельца ссылки из запроса user_id, org_id. Общая для Postgres и SQLite.
+func queryURLOwner(ctx context.Context, db *sql.DB, query string, id string) (models.URLOwner, bool, error) {
+	var userID, orgID sql.NullInt64
+	err := db.QueryRowContext(ctx, query, id).Scan(&userID, &orgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.URLOwner{}, false, nil
+		}
+		return models.URLOwner{}, false, err
+	}
+	return models.URLOwner{UserID: int(userID.Int64), OrgID: orgID.Int64}, true, nil
+}
+
+func (d *DB) UpdateURL(ctx context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	owner, err := updateURL(ctx, d.db, []string{
+		"SELECT long_url, user_id, org_id, deleted_at FROM url_mappings WHERE short_url = $1 FOR UPDATE",
+		"INSERT INTO url_history (short_url, previous_url, changed_at, changed_by) VALUES ($1, $2, $3, $4)",
+		"UPDATE url_mappings SET long_url = $1 WHERE short_url = $2",
+	}, id, originalURL, changedBy, changedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		shortURL, _, err2 := d.getShortURLByLongURL(ctx, owner.UserID, InsertURL{OriginalURL: originalURL, OrgID: owner.OrgID})
+		if err2 != nil {
+			return err2
+		}
+		return errors2.NewDuplicateURLError(shortURL)
+	}
+	return err
+}
+
+func (d *DB) GetURLHistory(ctx context.Context, id string) ([]models.URLChange, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, previous_url, changed_at, changed_by FROM url_history WHERE short_url = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	return scanURLHistory(rows)
+}
+
+// updateURL в одной транзакции читает ссылку первым запросом, записывает её прежний адрес
+// в историю вторым и меняет адрес третьим. Возвращает владельца ссылки, чтобы при нарушении
+// уникальности вызывающий мог найти дубликат. Общая для Postgres и SQLite.
+func updateURL(ctx context.Context, db *sql.DB, queries []string, id, originalURL string, changedBy int, changedAt time.Time) (models.URLOwner, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.URLOwner{}, err
+	}
+	defer tx.Rollback()
+
+	var previousURL string
+	var userID, orgID sql.NullInt64
+	var deletedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, queries[0], id).Scan(&previousURL, &userID, &orgID, &deletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.URLOwner{}, errors2.ErrURLNotFound
+		}
+		return models.URLOwner{}, err
+	}
+	owner := models.URLOwner{UserID: int(userID.Int64), OrgID: orgID.Int64}
+	if deletedAt.Valid {
+		return owner, errors2.ErrURLDeleted
+	}
+	if previousURL == originalURL {
+		return owner, nil
+	}
+	if _, err = tx.ExecContext(ctx, queries[1], id, previousURL, changedAt.UTC(), changedBy); err != nil {
+		return owner, err
+	}
+	if _, err = tx.ExecContext(ctx, queries[2], originalURL, id); err != nil {
+		return owner, err
+	}
+	return owner, tx.Commit()
+}
+
+func scanURLHistory(rows *sql.Rows) ([]models.URLChange, error) {
+	defer rows.Close()
+
+	var changes []models.URLChange
+	for rows.Next() {
+		var change models.URLChange
+		if err := rows.Scan(&change.ShortURL, &change.PreviousURL, &change.ChangedAt, &change.ChangedBy); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (d *DB) EnqueueDeletes(ctx context.Context, tasks []models.DeleteTask) error {
+	return execDeleteTasks(ctx, d.db, "INSERT INTO delete_queue (job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at) VALUES ($1, $2, $3, $4, $5, $6, $7)", tasks, insertDeleteTaskArgs)
+}
+
+func (d *DB) DueDeletes(ctx context.Context, now time.Time, limit int) ([]models.DeleteTask, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at, last_error, dead_at FROM delete_queue WHERE dead_at IS NULL AND next_attempt_at <= $1 ORDER BY id LIMIT $2", now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeleteTasks(rows)
+}
+
+func (d *DB) UpdateDeleteTasks(ctx context.Context, tasks []models.DeleteTask) error {
+	return execDeleteTasks(ctx, d.db, "UPDATE delete_queue SET attempts = $1, next_attempt_at = $2, last_error = $3, dead_at = $4 WHERE id = $5", tasks, updateDeleteTaskArgs)
+}
+
+func (d *DB) FinishDeletes(ctx context.Context, ids []int64) error {
+	tasks := make([]models.DeleteTask, 0, len(ids))
+	for _, id := range ids {
+		tasks = append(tasks, models.DeleteTask{ID: id})
+	}
+	return execDeleteTasks(ctx, d.db, "DELETE FROM delete_queue WHERE id = $1", tasks, func(task models.DeleteTask) []interface{} {
+		return []interface{}{task.ID}
+	})
+}
+
+func (d *DB) DeadDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at, last_error, dead_at FROM delete_queue WHERE dead_at IS NOT NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanDeleteTasks(rows)
+}
+
+func (d *DB) ListDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at, last_error, dead_at FROM delete_queue WHERE dead_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanDeleteTasks(rows)
+}
+
+func (d *DB) PendingDeletes(ctx context.Context) (int, error) {
+	var n int
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM delete_queue WHERE dead_at IS NULL").Scan(&n)
+	return n, err
+}
+
+// execDeleteTasks выполняет query для каждой задачи в одной транзакции. Общая для Postgres и SQLite.
+func execDeleteTasks(ctx context.Context, db *sql.DB, query string, tasks []models.DeleteTask, args func(models.DeleteTask) []interface{}) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, task := range tasks {
+		if _, err = stmt.ExecContext(ctx, args(task)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertDeleteTaskArgs(task models.DeleteTask) []interface{} {
+	return []interface{}{task.JobID, task.RequestedBy, task.CreatedAt.UTC(), task.UserID, task.ShortURL, task.Attempts, task.NextAttemptAt.UTC()}
+}
+
+func updateDeleteTaskArgs(task models.DeleteTask) []interface{} {
+	var deadAt *time.Time
+	if task.DeadAt != nil {
+		t := task.DeadAt.UTC()
+		deadAt = &t
+	}
+	return []interface{}{task.Attempts, task.NextAttemptAt.UTC(), task.LastError, deadAt, task.ID}
+}
+
+func scanDeleteTasks(rows *sql.Rows) ([]models.DeleteTask, error) {
+	defer rows.Close()
+
+	var tasks []models.DeleteTask
+	for rows.Next() {
+		var task models.DeleteTask
+		var createdAt, deadAt sql.NullTime
+		err := rows.Scan(&task.ID, &task.JobID, &task.RequestedBy, &createdAt, &task.UserID, &task.ShortURL,
+			&task.Attempts, &task.NextAttemptAt, &task.LastError, &deadAt)
+		if err != nil {
+			return nil, err
+		}
+		// Задачи, поставленные до появления created_at, остаются с нулевым временем
+		task.CreatedAt = createdAt.Time
+		if deadAt.Valid {
+			task.DeadAt = &deadAt.Time
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (d *DB) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	err := d.db.QueryRowContext(ctx, "INSERT INTO api_keys (user_id, name, prefix, key_hash, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		key.UserID, key.Name, key.Prefix, key.Hash, key.CreatedAt.UTC()).Scan(&key.ID)
+	return key, err
+}
+
+func (d *DB) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, user_id, name, prefix, key_hash, created_at, revoked_at FROM api_keys WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKeys(rows)
+}
+
+func (d *DB) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, user_id, name, prefix, key_hash, created_at, revoked_at FROM api_keys WHERE key_hash = $1", hash)
+	if err != nil {
+		return models.APIKey{}, false, err
+	}
+	keys, err := scanAPIKeys(rows)
+	if err != nil || len(keys) == 0 {
+		return models.APIKey{}, false, err
+	}
+	return keys[0], true, nil
+}
+
+func (d *DB) RevokeAPIKey(ctx context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	res, err := d.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL", revokedAt.UTC(), id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// scanAPIKeys читает ключи из результата запроса. Общая для Postgres и SQLite.
+func scanAPIKeys(rows *sql.Rows) ([]models.APIKey, error) {
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (d *DB) RegisterUser(ctx context.Context, account models.Account) error {
+	res, err := d.db.ExecContext(ctx, "UPDATE users SET username = $1, email = $2, password_hash = $3, registered_at = $4 WHERE id = $5 AND password_hash IS NULL",
+		account.Username, nullString(account.Email), account.PasswordHash, account.RegisteredAt.UTC(), account.UserID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return errors2.ErrAccountExists
+	}
+	return registeredUser(res, err)
+}
+
+func (d *DB) GetAccount(ctx context.Context, login string) (models.Account, bool, error) {
+	return queryAccount(ctx, d.db, "SELECT id, username, COALESCE(email, ''), password_hash, registered_at FROM users WHERE password_hash IS NOT NULL AND (username = $1 OR email = $1)", login)
+}
+
+func (d *DB) GetAccountByUserID(ctx context.Context, userID int) (models.Account, bool, error) {
+	return queryAccount(ctx, d.db, "SELECT id, username, COALESCE(email, ''), password_hash, registered_at FROM users WHERE password_hash IS NOT NULL AND id = $1", userID)
+}
+
+func (d *DB) MergeUsers(ctx context.Context, from, to int, mergedAt time.Time) (int, error) {
+	return mergeUsers(ctx, d.db, []string{
+		"UPDATE url_mappings SET user_id = $1 WHERE user_id = $2 AND org_id IS NULL AND NOT EXISTS (SELECT 1 FROM url_mappings m WHERE m.user_id = $1 AND m.org_id IS NULL AND m.long_url = url_mappings.long_url)",
+		"UPDATE api_keys SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL",
+	}, from, to, mergedAt)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullID возвращает NULL для нулевого идентификатора.
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// registeredUser проверяет результат привязки учётных данных: если ни одна строка не изменилась,
+// пользователь уже зарегистрирован. Общая для Postgres и SQLite.
+func registeredUser(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors2.ErrAlreadyRegistered
+	}
 	return nil
 }
+
+func queryAccount(ctx context.Context, db *sql.DB, query string, arg interface{}) (models.Account, bool, error) {
+	var account models.Account
+	err := db.QueryRowContext(ctx, query, arg).Scan(&account.UserID, &account.Username, &account.Email, &account.PasswordHash, &account.RegisteredAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Account{}, false, nil
+		}
+		return models.Account{}, false, err
+	}
+	return account, true, nil
+}
+
+// mergeUsers выполняет в одной транзакции запрос, переносящий ссылки, с аргументами (to, from)
+// и запрос, отзывающий API-ключи, с аргументами (from, mergedAt). Возвращает число перенесённых ссылок.
+// Общая для Postgres и SQLite.
+func mergeUsers(ctx context.Context, db *sql.DB, queries []string, from, to int, mergedAt time.Time) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, queries[0], to, from)
+	if err != nil {
+		return 0, err
+	}
+	merged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, queries[1], from, mergedAt.UTC()); err != nil {
+		return 0, err
+	}
+	return int(merged), tx.Commit()
+}
+
+func (d *DB) CreateOrg(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	return createOrg(ctx, d.db, []string{
+		"INSERT INTO organizations (name, created_at) VALUES ($1, $2) RETURNING id",
+		"INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)",
+	}, org, ownerID)
+}
+
+func (d *DB) GetUserOrgs(ctx context.Context, userID int) ([]models.OrgMembership, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT o.id, o.name, o.created_at, m.role FROM organizations o JOIN org_members m ON m.org_id = o.id WHERE m.user_id = $1 ORDER BY o.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+func (d *DB) GetOrgMembers(ctx context.Context, orgID int64) ([]models.OrgMember, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT org_id, user_id, role FROM org_members WHERE org_id = $1 ORDER BY user_id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanOrgMembers(rows)
+}
+
+func (d *DB) GetOrgRole(ctx context.Context, orgID int64, userID int) (string, bool, error) {
+	return queryOrgRole(ctx, d.db, "SELECT role FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+}
+
+func (d *DB) SetOrgMember(ctx context.Context, member models.OrgMember) error {
+	_, err := changeOrgMember(ctx, d.db, []string{
+		"SELECT id FROM organizations WHERE id = $1 FOR UPDATE",
+		"INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role",
+		"SELECT COUNT(*) FROM org_members WHERE org_id = $1 AND role = $2",
+	}, member.OrgID, member.UserID, member.Role)
+	return err
+}
+
+func (d *DB) RemoveOrgMember(ctx context.Context, orgID int64, userID int) (bool, error) {
+	n, err := changeOrgMember(ctx, d.db, []string{
+		"SELECT id FROM organizations WHERE id = $1 FOR UPDATE",
+		"DELETE FROM org_members WHERE org_id = $1 AND user_id = $2",
+		"SELECT COUNT(*) FROM org_members WHERE org_id = $1 AND role = $2",
+	}, orgID, userID)
+	if errors.Is(err, errors2.ErrOrgNotFound) {
+		return false, nil
+	}
+	return n > 0, err
+}
+
+func (d *DB) GetOrgURLs(ctx context.Context, orgID int64) (models.BatchUserURLs, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT long_url, short_url, expires_at FROM url_mappings WHERE org_id = $1 AND deleted_at IS NULL", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserURLs(rows)
+}
+
+func (d *DB) GetDeletedOrgURLs(ctx context.Context, orgID int64) ([]models.DeletedURL, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, long_url, deleted_at FROM url_mappings WHERE org_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeletedURLs(rows)
+}
+
+// createOrg в одной транзакции создаёт организацию первым запросом и добавляет владельца вторым.
+// Общая для Postgres и SQLite.
+func createOrg(ctx context.Context, db *sql.DB, queries []string, org models.Organization, ownerID int) (models.Organization, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Organization{}, err
+	}
+	defer tx.Rollback()
+
+	if err = tx.QueryRowContext(ctx, queries[0], org.Name, org.CreatedAt.UTC()).Scan(&org.ID); err != nil {
+		return models.Organization{}, err
+	}
+	if _, err = tx.ExecContext(ctx, queries[1], org.ID, ownerID, models.RoleOwner); err != nil {
+		return models.Organization{}, err
+	}
+	return org, tx.Commit()
+}
+
+// changeOrgMember в одной транзакции блокирует организацию первым запросом, меняет участника
+// вторым с аргументами orgID и args и третьим проверяет, что у организации остался владелец.
+// Возвращает число изменённых строк. Общая для Postgres и SQLite.
+func changeOrgMember(ctx context.Context, db *sql.DB, queries []string, orgID int64, args ...interface{}) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err = tx.QueryRowContext(ctx, queries[0], orgID).Scan(&orgID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors2.ErrOrgNotFound
+		}
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, queries[1], append([]interface{}{orgID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	var owners int
+	if err = tx.QueryRowContext(ctx, queries[2], orgID, models.RoleOwner).Scan(&owners); err != nil {
+		return 0, err
+	}
+	if owners == 0 {
+		return 0, errors2.ErrLastOwner
+	}
+	return n, tx.Commit()
+}
+
+func queryOrgRole(ctx context.Context, db *sql.DB, query string, orgID int64, userID int) (string, bool, error) {
+	var role string
+	err := db.QueryRowContext(ctx, query, orgID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return role, true, nil
+}
+
+func scanMemberships(rows *sql.Rows) ([]models.OrgMembership, error) {
+	defer rows.Close()
+
+	var orgs []models.OrgMembership
+	for rows.Next() {
+		var org models.OrgMembership
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt, &org.Role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func scanOrgMembers(rows *sql.Rows) ([]models.OrgMember, error) {
+	defer rows.Close()
+
+	var members []models.OrgMember
+	for rows.Next() {
+		var member models.OrgMember
+		if err := rows.Scan(&member.OrgID, &member.UserID, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (d *DB) GetUserQuota(ctx context.Context, userID int) (models.UserQuota, error) {
+	return queryUserQuota(ctx, d.db, "SELECT max_links, max_batch_size FROM users WHERE id = $1", userID)
+}
+
+// queryUserQuota читает квоты из запроса max_links, max_batch_size. Общая для Postgres и SQLite.
+func queryUserQuota(ctx context.Context, db *sql.DB, query string, userID int) (models.UserQuota, error) {
+	var maxLinks, maxBatchSize sql.NullInt64
+	err := db.QueryRowContext(ctx, query, userID).Scan(&maxLinks, &maxBatchSize)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.UserQuota{}, nil
+		}
+		return models.UserQuota{}, err
+	}
+	return models.UserQuota{MaxLinks: intPtr(maxLinks), MaxBatchSize: intPtr(maxBatchSize)}, nil
+}
+
+func (d *DB) SetUserQuota(ctx context.Context, userID int, quota models.UserQuota) error {
+	res, err := d.db.ExecContext(ctx, "UPDATE users SET max_links = $1, max_batch_size = $2 WHERE id = $3",
+		nullInt(quota.MaxLinks), nullInt(quota.MaxBatchSize), userID)
+	return updatedUser(res, err)
+}
+
+func (d *DB) CountUserURLs(ctx context.Context, userID int, now time.Time) (int, error) {
+	var n int
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM url_mappings WHERE user_id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > $2)",
+		userID, now.UTC()).Scan(&n)
+	return n, err
+}
+
+// updatedUser проверяет, что запрос изменил строку пользователя. Общая для Postgres и SQLite.
+func updatedUser(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors2.ErrUserNotFound
+	}
+	return nil
+}
+
+func nullInt(v *int) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
+
+func intPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
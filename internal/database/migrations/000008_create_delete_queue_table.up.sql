@@ -0,0 +1,12 @@
+CREATE TABLE delete_queue (
+    id BIGSERIAL PRIMARY KEY,
+    job_id VARCHAR(36) NOT NULL,
+    user_id INT NOT NULL,
+    short_url VARCHAR(255) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    dead_at TIMESTAMP
+);
+
+CREATE INDEX delete_queue_next_attempt_at ON delete_queue (next_attempt_at) WHERE dead_at IS NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    prefix VARCHAR(32) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN username VARCHAR(64) UNIQUE,
+    ADD COLUMN email VARCHAR(255) UNIQUE,
+    ADD COLUMN password_hash TEXT,
+    ADD COLUMN registered_at TIMESTAMP;
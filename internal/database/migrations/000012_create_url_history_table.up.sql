@@ -0,0 +1,9 @@
+CREATE TABLE url_history (
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR(255) NOT NULL,
+    previous_url TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL,
+    changed_by INT NOT NULL
+);
+
+CREATE INDEX url_history_short_url ON url_history (short_url);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN max_links INT,
+    ADD COLUMN max_batch_size INT;
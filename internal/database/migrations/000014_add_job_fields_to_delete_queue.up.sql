@@ -0,0 +1,3 @@
+ALTER TABLE delete_queue
+    ADD COLUMN requested_by INT NOT NULL DEFAULT 0,
+    ADD COLUMN created_at TIMESTAMP;
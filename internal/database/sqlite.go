@@ -0,0 +1,510 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+)
+
+// SQLiteScheme — префикс DATABASE_DSN, по которому выбирается SQLite, например sqlite://shortener.db.
+const SQLiteScheme = "sqlite://"
+
+// Миграции SQLite встраиваются в бинарник, чтобы для однонодовой установки хватало одного файла.
+//
+//go:embed sqlite_migrations/*.sql
+var sqliteMigrations embed.FS
+
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+// NewSQLite открывает базу SQLite по DSN вида sqlite://path/to/file.db.
+func NewSQLite(dsn string) (*SQLiteDB, error) {
+	path := strings.TrimPrefix(dsn, SQLiteScheme)
+	if path == "" {
+		return nil, errors.New("sqlite database path is empty")
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite допускает одного писателя, поэтому запросы выполняются через одно соединение.
+	db.SetMaxOpenConns(1)
+	return &SQLiteDB{db: db}, nil
+}
+
+func (d *SQLiteDB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *SQLiteDB) RunMigrations() error {
+	driver, err := migratesqlite.WithInstance(d.db, &migratesqlite.Config{})
+	if err != nil {
+		return err
+	}
+	source, err := iofs.New(sqliteMigrations, "sqlite_migrations")
+	if err != nil {
+		return err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite", driver)
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	if err == nil || errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+
+	return err
+}
+
+func (d *SQLiteDB) Close() error {
+	return d.db.Close()
+}
+
+func (d *SQLiteDB) getShortURLByLongURL(ctx context.Context, userID int, url InsertURL) (string, error) {
+	var shortURL string
+	var err error
+	if url.OrgID != 0 {
+		err = d.db.QueryRowContext(ctx, "SELECT short_url FROM url_mappings WHERE long_url = ? AND org_id = ?", url.OriginalURL, url.OrgID).Scan(&shortURL)
+	} else {
+		err = d.db.QueryRowContext(ctx, "SELECT short_url FROM url_mappings WHERE long_url = ? AND user_id = ? AND org_id IS NULL", url.OriginalURL, userID).Scan(&shortURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	return shortURL, nil
+}
+
+func (d *SQLiteDB) AddURL(ctx context.Context, userID int, url InsertURL) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO url_mappings (short_url, long_url, user_id, expires_at, org_id) VALUES (?, ?, ?, ?, ?)", url.ShortURL, url.OriginalURL, userID, url.ExpiresAt, nullID(url.OrgID))
+	if isSQLiteShortURLViolation(err) {
+		return errors2.ErrShortURLTaken
+	}
+	if isSQLiteUniqueViolation(err) {
+		shortURL, err2 := d.getShortURLByLongURL(ctx, userID, url)
+		if err2 != nil {
+			return err2
+		}
+		return errors2.NewDuplicateURLError(shortURL)
+	}
+	return err
+}
+
+func (d *SQLiteDB) BatchAddURL(ctx context.Context, userID int, urls []InsertURL) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO url_mappings (short_url, long_url, user_id, expires_at, org_id) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, url := range urls {
+		if _, err = stmt.ExecContext(ctx, url.ShortURL, url.OriginalURL, userID, url.ExpiresAt, nullID(url.OrgID)); err != nil {
+			if isSQLiteShortURLViolation(err) {
+				return errors2.ErrShortURLTaken
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *SQLiteDB) GetURL(ctx context.Context, id string) (string, bool, error) {
+	var url string
+	var deletedAt, expiresAt sql.NullTime
+	err := d.db.QueryRowContext(ctx, "SELECT long_url, deleted_at, expires_at FROM url_mappings WHERE short_url = ?", id).Scan(&url, &deletedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if deletedAt.Valid {
+		return "", false, errors2.ErrURLDeleted
+	}
+	if expiresAt.Valid && !expiresAt.Time.After(time.Now()) {
+		return "", false, errors2.ErrURLExpired
+	}
+	return url, true, nil
+}
+
+func (d *SQLiteDB) AddUser(ctx context.Context) (int, error) {
+	var id int
+	if err := d.db.QueryRowContext(ctx, "INSERT INTO users DEFAULT VALUES RETURNING id").Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (d *SQLiteDB) GetUserURLs(ctx context.Context, userID int) (models.BatchUserURLs, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT long_url, short_url, expires_at FROM url_mappings WHERE user_id = ? AND org_id IS NULL AND deleted_at IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserURLs(rows)
+}
+
+func (d *SQLiteDB) BatchDeleteURLs(ctx context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args := sqliteURLPairs(urls, time.Now().UTC())
+	query := fmt.Sprintf("UPDATE url_mappings SET deleted_at = COALESCE(deleted_at, ?) WHERE (short_url, user_id) IN (VALUES %s) RETURNING short_url, user_id", placeholders)
+
+	return queryDeletedURLs(ctx, d.db, query, args...)
+}
+
+// sqliteURLPairs — то же, что urlPairs, для позиционных параметров SQLite.
+func sqliteURLPairs(urls []models.DeleteURL, first interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(urls))
+	args := make([]interface{}, 0, len(urls)*2+1)
+	args = append(args, first)
+	for i, url := range urls {
+		placeholders[i] = "(?, ?)"
+		args = append(args, url.ShortURL, url.UserID)
+	}
+	return strings.Join(placeholders, ","), args
+}
+
+func (d *SQLiteDB) GetDeletedURLs(ctx context.Context, userID int) ([]models.DeletedURL, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, long_url, deleted_at FROM url_mappings WHERE user_id = ? AND org_id IS NULL AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeletedURLs(rows)
+}
+
+func (d *SQLiteDB) RestoreURLs(ctx context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args := sqliteURLPairs(urls, deletedAfter.UTC())
+	// Граница передаётся первым аргументом, поэтому условие на неё идёт раньше списка пар
+	query := fmt.Sprintf("UPDATE url_mappings SET deleted_at = NULL WHERE deleted_at >= ? AND (short_url, user_id) IN (VALUES %s) RETURNING short_url, user_id", placeholders)
+
+	return queryDeletedURLs(ctx, d.db, query, args...)
+}
+
+func (d *SQLiteDB) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return purgeDeletedURLs(ctx, d.db, []string{
+		"DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM url_mappings WHERE deleted_at < ?)",
+		"DELETE FROM url_history WHERE short_url IN (SELECT short_url FROM url_mappings WHERE deleted_at < ?)",
+		"DELETE FROM url_mappings WHERE deleted_at < ?",
+	}, deletedBefore.UTC())
+}
+
+func (d *SQLiteDB) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	res, err := d.db.ExecContext(ctx, "UPDATE url_mappings SET deleted_at = ?1 WHERE expires_at <= ?1 AND deleted_at IS NULL", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// isSQLiteShortURLViolation сообщает, что вставка нарушила уникальность short_url.
+// SQLite не сообщает имя ограничения, только перечень колонок.
+func isSQLiteShortURLViolation(err error) bool {
+	return isSQLiteUniqueViolation(err) && strings.Contains(err.Error(), "UNIQUE constraint failed: url_mappings.short_url")
+}
+
+func (d *SQLiteDB) AddClicks(ctx context.Context, clicks []models.Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ShortURL, click.ClickedAt.UTC(), click.Referrer, click.UserAgent, click.IPHash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *SQLiteDB) GetClicks(ctx context.Context, id string, from, to time.Time) ([]models.Click, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, clicked_at, referrer, user_agent, ip_hash FROM clicks WHERE short_url = ? AND clicked_at >= ? AND clicked_at < ? ORDER BY clicked_at", id, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clicks []models.Click
+	for rows.Next() {
+		var click models.Click
+		if err = rows.Scan(&click.ShortURL, &click.ClickedAt, &click.Referrer, &click.UserAgent, &click.IPHash); err != nil {
+			return nil, err
+		}
+		clicks = append(clicks, click)
+	}
+	return clicks, rows.Err()
+}
+
+func (d *SQLiteDB) GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	// Драйвер хранит время строкой вида "2006-01-02 15:04:05 +0000 UTC", которую не разбирают
+	// функции даты SQLite, поэтому интервалы берутся префиксом строки.
+	const where = "FROM clicks WHERE short_url = ? AND clicked_at >= ? AND clicked_at < ?"
+	return queryClickStats(ctx, d.db, clickStatsQueries{
+		totals:        "SELECT COUNT(*), COUNT(DISTINCT NULLIF(ip_hash, '')) " + where,
+		perDay:        "SELECT substr(clicked_at, 1, 10) || ' 00' AS start, COUNT(*) " + where + " GROUP BY start ORDER BY start",
+		perHour:       "SELECT substr(clicked_at, 1, 13) AS start, COUNT(*) " + where + " GROUP BY start ORDER BY start",
+		topReferrers:  "SELECT referrer, COUNT(*) AS clicks " + where + " AND referrer <> '' GROUP BY referrer ORDER BY clicks DESC, referrer LIMIT ?",
+		topUserAgents: "SELECT user_agent, COUNT(*) AS clicks " + where + " AND user_agent <> '' GROUP BY user_agent ORDER BY clicks DESC, user_agent LIMIT ?",
+	}, id, from.UTC(), to.UTC(), top)
+}
+
+func (d *SQLiteDB) GetURLOwner(ctx context.Context, id string) (models.URLOwner, bool, error) {
+	return queryURLOwner(ctx, d.db, "SELECT user_id, org_id FROM url_mappings WHERE short_url = ?", id)
+}
+
+func (d *SQLiteDB) UpdateURL(ctx context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	// Соединение одно, поэтому строку не нужно блокировать отдельно.
+	owner, err := updateURL(ctx, d.db, []string{
+		"SELECT long_url, user_id, org_id, deleted_at FROM url_mappings WHERE short_url = ?",
+		"INSERT INTO url_history (short_url, previous_url, changed_at, changed_by) VALUES (?, ?, ?, ?)",
+		"UPDATE url_mappings SET long_url = ? WHERE short_url = ?",
+	}, id, originalURL, changedBy, changedAt)
+	if isSQLiteUniqueViolation(err) {
+		shortURL, err2 := d.getShortURLByLongURL(ctx, owner.UserID, InsertURL{OriginalURL: originalURL, OrgID: owner.OrgID})
+		if err2 != nil {
+			return err2
+		}
+		return errors2.NewDuplicateURLError(shortURL)
+	}
+	return err
+}
+
+func (d *SQLiteDB) GetURLHistory(ctx context.Context, id string) ([]models.URLChange, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, previous_url, changed_at, changed_by FROM url_history WHERE short_url = ? ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	return scanURLHistory(rows)
+}
+
+func (d *SQLiteDB) EnqueueDeletes(ctx context.Context, tasks []models.DeleteTask) error {
+	return execDeleteTasks(ctx, d.db, "INSERT INTO delete_queue (job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?)", tasks, insertDeleteTaskArgs)
+}
+
+func (d *SQLiteDB) DueDeletes(ctx context.Context, now time.Time, limit int) ([]models.DeleteTask, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at, last_error, dead_at FROM delete_queue WHERE dead_at IS NULL AND next_attempt_at <= ? ORDER BY id LIMIT ?", now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeleteTasks(rows)
+}
+
+func (d *SQLiteDB) UpdateDeleteTasks(ctx context.Context, tasks []models.DeleteTask) error {
+	return execDeleteTasks(ctx, d.db, "UPDATE delete_queue SET attempts = ?, next_attempt_at = ?, last_error = ?, dead_at = ? WHERE id = ?", tasks, updateDeleteTaskArgs)
+}
+
+func (d *SQLiteDB) FinishDeletes(ctx context.Context, ids []int64) error {
+	tasks := make([]models.DeleteTask, 0, len(ids))
+	for _, id := range ids {
+		tasks = append(tasks, models.DeleteTask{ID: id})
+	}
+	return execDeleteTasks(ctx, d.db, "DELETE FROM delete_queue WHERE id = ?", tasks, func(task models.DeleteTask) []interface{} {
+		return []interface{}{task.ID}
+	})
+}
+
+func (d *SQLiteDB) DeadDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at, last_error, dead_at FROM delete_queue WHERE dead_at IS NOT NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanDeleteTasks(rows)
+}
+
+func (d *SQLiteDB) ListDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, job_id, requested_by, created_at, user_id, short_url, attempts, next_attempt_at, last_error, dead_at FROM delete_queue WHERE dead_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return scanDeleteTasks(rows)
+}
+
+func (d *SQLiteDB) PendingDeletes(ctx context.Context) (int, error) {
+	var n int
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM delete_queue WHERE dead_at IS NULL").Scan(&n)
+	return n, err
+}
+
+func (d *SQLiteDB) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	err := d.db.QueryRowContext(ctx, "INSERT INTO api_keys (user_id, name, prefix, key_hash, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		key.UserID, key.Name, key.Prefix, key.Hash, key.CreatedAt.UTC()).Scan(&key.ID)
+	return key, err
+}
+
+func (d *SQLiteDB) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, user_id, name, prefix, key_hash, created_at, revoked_at FROM api_keys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKeys(rows)
+}
+
+func (d *SQLiteDB) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, user_id, name, prefix, key_hash, created_at, revoked_at FROM api_keys WHERE key_hash = ?", hash)
+	if err != nil {
+		return models.APIKey{}, false, err
+	}
+	keys, err := scanAPIKeys(rows)
+	if err != nil || len(keys) == 0 {
+		return models.APIKey{}, false, err
+	}
+	return keys[0], true, nil
+}
+
+func (d *SQLiteDB) RevokeAPIKey(ctx context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	res, err := d.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL", revokedAt.UTC(), id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (d *SQLiteDB) RegisterUser(ctx context.Context, account models.Account) error {
+	res, err := d.db.ExecContext(ctx, "UPDATE users SET username = ?, email = ?, password_hash = ?, registered_at = ? WHERE id = ? AND password_hash IS NULL",
+		account.Username, nullString(account.Email), account.PasswordHash, account.RegisteredAt.UTC(), account.UserID)
+	if isSQLiteUniqueViolation(err) {
+		return errors2.ErrAccountExists
+	}
+	return registeredUser(res, err)
+}
+
+func (d *SQLiteDB) GetAccount(ctx context.Context, login string) (models.Account, bool, error) {
+	return queryAccount(ctx, d.db, "SELECT id, username, COALESCE(email, ''), password_hash, registered_at FROM users WHERE password_hash IS NOT NULL AND (username = ?1 OR email = ?1)", login)
+}
+
+func (d *SQLiteDB) GetAccountByUserID(ctx context.Context, userID int) (models.Account, bool, error) {
+	return queryAccount(ctx, d.db, "SELECT id, username, COALESCE(email, ''), password_hash, registered_at FROM users WHERE password_hash IS NOT NULL AND id = ?", userID)
+}
+
+func (d *SQLiteDB) MergeUsers(ctx context.Context, from, to int, mergedAt time.Time) (int, error) {
+	return mergeUsers(ctx, d.db, []string{
+		"UPDATE url_mappings SET user_id = ?1 WHERE user_id = ?2 AND org_id IS NULL AND NOT EXISTS (SELECT 1 FROM url_mappings m WHERE m.user_id = ?1 AND m.org_id IS NULL AND m.long_url = url_mappings.long_url)",
+		"UPDATE api_keys SET revoked_at = ?2 WHERE user_id = ?1 AND revoked_at IS NULL",
+	}, from, to, mergedAt)
+}
+
+func (d *SQLiteDB) CreateOrg(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	return createOrg(ctx, d.db, []string{
+		"INSERT INTO organizations (name, created_at) VALUES (?, ?) RETURNING id",
+		"INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?)",
+	}, org, ownerID)
+}
+
+func (d *SQLiteDB) GetUserOrgs(ctx context.Context, userID int) ([]models.OrgMembership, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT o.id, o.name, o.created_at, m.role FROM organizations o JOIN org_members m ON m.org_id = o.id WHERE m.user_id = ? ORDER BY o.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+func (d *SQLiteDB) GetOrgMembers(ctx context.Context, orgID int64) ([]models.OrgMember, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT org_id, user_id, role FROM org_members WHERE org_id = ? ORDER BY user_id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanOrgMembers(rows)
+}
+
+func (d *SQLiteDB) GetOrgRole(ctx context.Context, orgID int64, userID int) (string, bool, error) {
+	return queryOrgRole(ctx, d.db, "SELECT role FROM org_members WHERE org_id = ? AND user_id = ?", orgID, userID)
+}
+
+// Транзакции SQLite выполняются по одной, поэтому организацию достаточно прочитать.
+func (d *SQLiteDB) SetOrgMember(ctx context.Context, member models.OrgMember) error {
+	_, err := changeOrgMember(ctx, d.db, []string{
+		"SELECT id FROM organizations WHERE id = ?",
+		"INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?) ON CONFLICT (org_id, user_id) DO UPDATE SET role = excluded.role",
+		"SELECT COUNT(*) FROM org_members WHERE org_id = ? AND role = ?",
+	}, member.OrgID, member.UserID, member.Role)
+	return err
+}
+
+func (d *SQLiteDB) RemoveOrgMember(ctx context.Context, orgID int64, userID int) (bool, error) {
+	n, err := changeOrgMember(ctx, d.db, []string{
+		"SELECT id FROM organizations WHERE id = ?",
+		"DELETE FROM org_members WHERE org_id = ? AND user_id = ?",
+		"SELECT COUNT(*) FROM org_members WHERE org_id = ? AND role = ?",
+	}, orgID, userID)
+	if errors.Is(err, errors2.ErrOrgNotFound) {
+		return false, nil
+	}
+	return n > 0, err
+}
+
+func (d *SQLiteDB) GetOrgURLs(ctx context.Context, orgID int64) (models.BatchUserURLs, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT long_url, short_url, expires_at FROM url_mappings WHERE org_id = ? AND deleted_at IS NULL", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserURLs(rows)
+}
+
+func (d *SQLiteDB) GetDeletedOrgURLs(ctx context.Context, orgID int64) ([]models.DeletedURL, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT short_url, long_url, deleted_at FROM url_mappings WHERE org_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeletedURLs(rows)
+}
+
+func (d *SQLiteDB) GetUserQuota(ctx context.Context, userID int) (models.UserQuota, error) {
+	return queryUserQuota(ctx, d.db, "SELECT max_links, max_batch_size FROM users WHERE id = ?", userID)
+}
+
+func (d *SQLiteDB) SetUserQuota(ctx context.Context, userID int, quota models.UserQuota) error {
+	res, err := d.db.ExecContext(ctx, "UPDATE users SET max_links = ?, max_batch_size = ? WHERE id = ?",
+		nullInt(quota.MaxLinks), nullInt(quota.MaxBatchSize), userID)
+	return updatedUser(res, err)
+}
+
+func (d *SQLiteDB) CountUserURLs(ctx context.Context, userID int, now time.Time) (int, error) {
+	var n int
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM url_mappings WHERE user_id = ? AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+		userID, now.UTC()).Scan(&n)
+	return n, err
+}
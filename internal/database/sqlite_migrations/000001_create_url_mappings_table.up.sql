@@ -0,0 +1,5 @@
+CREATE TABLE url_mappings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    long_url TEXT NOT NULL,
+    short_url VARCHAR(255) NOT NULL UNIQUE
+);
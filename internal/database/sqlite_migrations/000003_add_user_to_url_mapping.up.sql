@@ -0,0 +1,2 @@
+ALTER TABLE url_mappings
+    ADD COLUMN user_id INTEGER REFERENCES users(id);
@@ -0,0 +1,3 @@
+-- SQLite не умеет удалять ограничения, поэтому уникальность long_url
+-- не создаётся в 000001, а здесь сразу заводится индекс по паре (long_url, user_id).
+CREATE UNIQUE INDEX long_url_user_id_unique ON url_mappings (long_url, user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE url_mappings
+    ADD COLUMN deleted_at TIMESTAMP;
@@ -0,0 +1,10 @@
+CREATE TABLE clicks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_url VARCHAR(255) NOT NULL,
+    clicked_at TIMESTAMP NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_hash VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX clicks_short_url_clicked_at ON clicks (short_url, clicked_at);
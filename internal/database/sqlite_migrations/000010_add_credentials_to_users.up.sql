@@ -0,0 +1,8 @@
+-- SQLite не умеет добавлять столбцы с UNIQUE, поэтому уникальность задаётся индексами.
+ALTER TABLE users ADD COLUMN username VARCHAR(64);
+ALTER TABLE users ADD COLUMN email VARCHAR(255);
+ALTER TABLE users ADD COLUMN password_hash TEXT;
+ALTER TABLE users ADD COLUMN registered_at TIMESTAMP;
+
+CREATE UNIQUE INDEX users_username_unique ON users (username);
+CREATE UNIQUE INDEX users_email_unique ON users (email);
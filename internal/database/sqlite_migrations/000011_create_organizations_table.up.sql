@@ -0,0 +1,21 @@
+CREATE TABLE organizations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE org_members (
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX org_members_user_id ON org_members (user_id);
+
+ALTER TABLE url_mappings ADD COLUMN org_id INTEGER REFERENCES organizations(id);
+
+-- Дубликаты ищутся среди личных ссылок пользователя или среди ссылок организации.
+DROP INDEX long_url_user_id_unique;
+CREATE UNIQUE INDEX long_url_user_id_unique ON url_mappings (long_url, user_id) WHERE org_id IS NULL;
+CREATE UNIQUE INDEX long_url_org_id_unique ON url_mappings (long_url, org_id) WHERE org_id IS NOT NULL;
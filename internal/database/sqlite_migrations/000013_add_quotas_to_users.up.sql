@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN max_links INT;
+ALTER TABLE users ADD COLUMN max_batch_size INT;
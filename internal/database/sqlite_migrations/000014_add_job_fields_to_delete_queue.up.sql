@@ -0,0 +1,2 @@
+ALTER TABLE delete_queue ADD COLUMN requested_by INT NOT NULL DEFAULT 0;
+ALTER TABLE delete_queue ADD COLUMN created_at TIMESTAMP;
@@ -17,3 +17,52 @@ func (e *DuplicateURLError) Error() string {
 }
 
 var ErrURLDeleted = errors1.New("URL has been deleted")
+
+// ErrShortURLTaken возвращается, если короткий идентификатор уже занят любым пользователем.
+var ErrShortURLTaken = errors1.New("short URL is already taken")
+
+var ErrURLExpired = errors1.New("URL has expired")
+
+var ErrURLNotFound = errors1.New("URL not found")
+
+// ErrForbidden возвращается, когда пользователь обращается к чужой ссылке
+// или к ссылке организации, в которой у него недостаточно прав.
+var ErrForbidden = errors1.New("access to URL is forbidden")
+
+var ErrJobNotFound = errors1.New("job not found")
+
+var ErrAPIKeyNotFound = errors1.New("API key not found")
+
+// ErrAccountExists возвращается, если имя пользователя или email уже заняты.
+var ErrAccountExists = errors1.New("username or email is already taken")
+
+// ErrAlreadyRegistered возвращается при попытке привязать учётные данные к уже зарегистрированному пользователю.
+var ErrAlreadyRegistered = errors1.New("user is already registered")
+
+var ErrInvalidCredentials = errors1.New("invalid login or password")
+
+// ErrInvalidAccount возвращается, если имя пользователя, email или пароль не проходят проверку.
+var ErrInvalidAccount = errors1.New("invalid account data")
+
+// ErrOrgNotFound возвращается и для организации, в которой пользователь не состоит,
+// чтобы не раскрывать её существование.
+var ErrOrgNotFound = errors1.New("organization not found")
+
+// ErrOrgForbidden возвращается, когда роли пользователя в организации недостаточно для действия.
+var ErrOrgForbidden = errors1.New("insufficient role in organization")
+
+var ErrMemberNotFound = errors1.New("organization member not found")
+
+// ErrInvalidRole возвращается для роли, отличной от owner, editor и viewer.
+var ErrInvalidRole = errors1.New("invalid role")
+
+// ErrLastOwner возвращается при попытке исключить или понизить последнего владельца организации.
+var ErrLastOwner = errors1.New("organization must have at least one owner")
+
+var ErrUserNotFound = errors1.New("user not found")
+
+// ErrEmptyURL возвращается, если новый адрес ссылки не задан.
+var ErrEmptyURL = errors1.New("url must not be empty")
+
+// ErrInvalidURL возвращается, если новый адрес ссылки не абсолютный URL со схемой http или https.
+var ErrInvalidURL = errors1.New("url must be an absolute http or https URL")
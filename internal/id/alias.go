@@ -0,0 +1,45 @@
+package id
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MinAliasLength = 3
+	MaxAliasLength = 64
+)
+
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// reservedAliases совпадают с путями роутера и не могут быть короткими ссылками.
+var reservedAliases = map[string]struct{}{
+	"api":     {},
+	"ping":    {},
+	"metrics": {},
+	"debug":   {},
+	"health":  {},
+	"static":  {},
+}
+
+// ValidateAlias проверяет пользовательский короткий идентификатор:
+// допустимы латинские буквы, цифры, '-' и '_', длина от MinAliasLength до MaxAliasLength.
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrInvalidAlias, MinAliasLength, MaxAliasLength)
+	}
+	for _, r := range alias {
+		if !isAliasRune(r) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidAlias, r)
+		}
+	}
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
+
+func isAliasRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+}
@@ -0,0 +1,191 @@
+// Package metrics собирает метрики сервиса в формате Prometheus.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// Результаты перехода по короткой ссылке для RedirectResult.
+const (
+	RedirectHit  = "hit"
+	RedirectMiss = "miss"
+	// RedirectGone — ссылка удалена или истекла.
+	RedirectGone = "gone"
+)
+
+// Виды конфликтов для Conflict.
+const (
+	// ConflictURL — у пользователя уже есть ссылка на этот адрес.
+	ConflictURL = "url"
+	// ConflictAlias — короткий идентификатор занят.
+	ConflictAlias = "alias"
+)
+
+// unmatchedRoute — метка запросов, не попавших ни в один маршрут, чтобы
+// произвольные пути не раздували число временных рядов.
+const unmatchedRoute = "unmatched"
+
+// Metrics хранит метрики сервиса в собственном реестре. Методы записи безопасны
+// для nil-получателя, поэтому код, которому метрики не переданы, их просто не пишет.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	redirects        *prometheus.CounterVec
+	conflicts        *prometheus.CounterVec
+	deleteQueueDepth prometheus.Gauge
+	deleteFlush      prometheus.Histogram
+	storageDuration  *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Short URL lookups by result: hit, miss or gone.",
+		}, []string{"result"}),
+		conflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "duplicate_conflicts_total",
+			Help:      "Requests rejected with 409 because the URL or alias already exists.",
+		}, []string{"kind"}),
+		deleteQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delete_queue_depth",
+			Help:      "Delete tasks waiting in the queue, excluding dead ones.",
+		}),
+		deleteFlush: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delete_batch_flush_duration_seconds",
+			Help:      "Latency of deleting one batch of URLs from the delete queue.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Storage operation latency by backend and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "operation"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.redirects,
+		m.conflicts,
+		m.deleteQueueDepth,
+		m.deleteFlush,
+		m.storageDuration,
+	)
+	return m
+}
+
+// Handler отдаёт метрики в текстовом формате Prometheus.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware считает запросы и их длительность по шаблону маршрута chi, методу и коду ответа.
+// Шаблон известен только после маршрутизации, поэтому middleware подключается к корневому роутеру.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := strconv.Itoa(sw.status)
+		method := methodLabel(r.Method)
+		m.requests.WithLabelValues(route, method, status).Inc()
+		m.requestDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RedirectResult учитывает поиск короткой ссылки при переходе.
+func (m *Metrics) RedirectResult(result string) {
+	if m == nil {
+		return
+	}
+	m.redirects.WithLabelValues(result).Inc()
+}
+
+// Conflict учитывает ответ 409 на создание или изменение ссылки.
+func (m *Metrics) Conflict(kind string) {
+	if m == nil {
+		return
+	}
+	m.conflicts.WithLabelValues(kind).Inc()
+}
+
+// SetDeleteQueueDepth запоминает число задач в очереди на удаление.
+func (m *Metrics) SetDeleteQueueDepth(n int) {
+	if m == nil {
+		return
+	}
+	m.deleteQueueDepth.Set(float64(n))
+}
+
+// ObserveDeleteFlush учитывает длительность удаления пакета ссылок.
+func (m *Metrics) ObserveDeleteFlush(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.deleteFlush.Observe(d.Seconds())
+}
+
+func (m *Metrics) observeStorage(backend, operation string, d time.Duration) {
+	m.storageDuration.WithLabelValues(backend, operation).Observe(d.Seconds())
+}
+
+// methodLabel ограничивает метку метода стандартными методами HTTP.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// statusWriter запоминает код ответа.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
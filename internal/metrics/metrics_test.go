@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusGone)
+	})
+
+	for _, target := range []string{"/first", "/second", "/api/unknown"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if got := testutil.ToFloat64(m.requests.WithLabelValues("/{id}", http.MethodGet, "410")); got != 2 {
+		t.Errorf("Expected 2 requests counted by route pattern, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.requests.WithLabelValues(unmatchedRoute, http.MethodGet, "404")); got != 1 {
+		t.Errorf("Expected 1 unmatched request, got %v", got)
+	}
+	if got := testutil.CollectAndCount(m.requestDuration); got != 2 {
+		t.Errorf("Expected latency histograms for 2 label sets, got %d", got)
+	}
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	s := InstrumentStorage(storage.NewMemoryURLStorage(), storage.BackendMemory, m)
+	if _, ok := s.(storage.Compactor); ok {
+		t.Errorf("Expected memory storage wrapper not to implement Compactor")
+	}
+	if _, err := s.GenerateUserID(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, _, err := s.GetURL(ctx, "missing"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := testutil.CollectAndCount(m.storageDuration); got != 2 {
+		t.Errorf("Expected latency of 2 operations, got %d", got)
+	}
+
+	file, err := storage.NewFileURLStorage(t.TempDir() + "/urls.json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	s = InstrumentStorage(file, storage.Backend(file), m)
+	defer s.Close()
+	compactor, ok := s.(storage.Compactor)
+	if !ok {
+		t.Fatalf("Expected file storage wrapper to implement Compactor")
+	}
+	if err = compactor.Compact(ctx); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if got := testutil.CollectAndCount(m.storageDuration, "shortener_storage_operation_duration_seconds"); got != 3 {
+		t.Errorf("Expected latency of 3 operations, got %d", got)
+	}
+}
@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/database"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// InstrumentStorage оборачивает хранилище так, что длительность каждой операции попадает
+// в метрику с меткой backend. Если хранилище реализует storage.Compactor, его реализует и обёртка.
+func InstrumentStorage(s storage.URLStorage, backend string, m *Metrics) storage.URLStorage {
+	instrumented := &instrumentedStorage{next: s, backend: backend, metrics: m}
+	if compactor, ok := s.(storage.Compactor); ok {
+		return &instrumentedCompactor{instrumentedStorage: instrumented, compactor: compactor}
+	}
+	return instrumented
+}
+
+type instrumentedStorage struct {
+	next    storage.URLStorage
+	backend string
+	metrics *Metrics
+}
+
+var _ storage.URLStorage = (*instrumentedStorage)(nil)
+
+type instrumentedCompactor struct {
+	*instrumentedStorage
+	compactor storage.Compactor
+}
+
+func (s *instrumentedCompactor) Compact(ctx context.Context) error {
+	defer s.observe("Compact", time.Now())
+	return s.compactor.Compact(ctx)
+}
+
+func (s *instrumentedStorage) observe(operation string, start time.Time) {
+	s.metrics.observeStorage(s.backend, operation, time.Since(start))
+}
+
+func (s *instrumentedStorage) AddURL(ctx context.Context, userID int, url database.InsertURL) error {
+	defer s.observe("AddURL", time.Now())
+	return s.next.AddURL(ctx, userID, url)
+}
+
+func (s *instrumentedStorage) BatchAddURL(ctx context.Context, userID int, insertURLs []database.InsertURL) error {
+	defer s.observe("BatchAddURL", time.Now())
+	return s.next.BatchAddURL(ctx, userID, insertURLs)
+}
+
+func (s *instrumentedStorage) GetURL(ctx context.Context, id string) (string, bool, error) {
+	defer s.observe("GetURL", time.Now())
+	return s.next.GetURL(ctx, id)
+}
+
+func (s *instrumentedStorage) GetUserURLs(ctx context.Context, userID int) (models.BatchUserURLs, error) {
+	defer s.observe("GetUserURLs", time.Now())
+	return s.next.GetUserURLs(ctx, userID)
+}
+
+func (s *instrumentedStorage) GetDeletedURLs(ctx context.Context, userID int) ([]models.DeletedURL, error) {
+	defer s.observe("GetDeletedURLs", time.Now())
+	return s.next.GetDeletedURLs(ctx, userID)
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	defer s.observe("Ping", time.Now())
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedStorage) GenerateUserID(ctx context.Context) (int, error) {
+	defer s.observe("GenerateUserID", time.Now())
+	return s.next.GenerateUserID(ctx)
+}
+
+func (s *instrumentedStorage) BatchDeleteURLs(ctx context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
+	defer s.observe("BatchDeleteURLs", time.Now())
+	return s.next.BatchDeleteURLs(ctx, urls)
+}
+
+func (s *instrumentedStorage) RestoreURLs(ctx context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	defer s.observe("RestoreURLs", time.Now())
+	return s.next.RestoreURLs(ctx, urls, deletedAfter)
+}
+
+func (s *instrumentedStorage) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	defer s.observe("PurgeDeletedURLs", time.Now())
+	return s.next.PurgeDeletedURLs(ctx, deletedBefore)
+}
+
+func (s *instrumentedStorage) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	defer s.observe("DeleteExpiredURLs", time.Now())
+	return s.next.DeleteExpiredURLs(ctx)
+}
+
+func (s *instrumentedStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	defer s.observe("AddClicks", time.Now())
+	return s.next.AddClicks(ctx, clicks)
+}
+
+func (s *instrumentedStorage) GetClicks(ctx context.Context, id string, from, to time.Time) ([]models.Click, error) {
+	defer s.observe("GetClicks", time.Now())
+	return s.next.GetClicks(ctx, id, from, to)
+}
+
+func (s *instrumentedStorage) GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	defer s.observe("GetClickStats", time.Now())
+	return s.next.GetClickStats(ctx, id, from, to, top)
+}
+
+func (s *instrumentedStorage) GetURLOwner(ctx context.Context, id string) (models.URLOwner, bool, error) {
+	defer s.observe("GetURLOwner", time.Now())
+	return s.next.GetURLOwner(ctx, id)
+}
+
+func (s *instrumentedStorage) UpdateURL(ctx context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	defer s.observe("UpdateURL", time.Now())
+	return s.next.UpdateURL(ctx, id, originalURL, changedBy, changedAt)
+}
+
+func (s *instrumentedStorage) GetURLHistory(ctx context.Context, id string) ([]models.URLChange, error) {
+	defer s.observe("GetURLHistory", time.Now())
+	return s.next.GetURLHistory(ctx, id)
+}
+
+func (s *instrumentedStorage) Close() error {
+	defer s.observe("Close", time.Now())
+	return s.next.Close()
+}
+
+func (s *instrumentedStorage) EnqueueDeletes(ctx context.Context, tasks []models.DeleteTask) error {
+	defer s.observe("EnqueueDeletes", time.Now())
+	return s.next.EnqueueDeletes(ctx, tasks)
+}
+
+func (s *instrumentedStorage) DueDeletes(ctx context.Context, now time.Time, limit int) ([]models.DeleteTask, error) {
+	defer s.observe("DueDeletes", time.Now())
+	return s.next.DueDeletes(ctx, now, limit)
+}
+
+func (s *instrumentedStorage) UpdateDeleteTasks(ctx context.Context, tasks []models.DeleteTask) error {
+	defer s.observe("UpdateDeleteTasks", time.Now())
+	return s.next.UpdateDeleteTasks(ctx, tasks)
+}
+
+func (s *instrumentedStorage) FinishDeletes(ctx context.Context, ids []int64) error {
+	defer s.observe("FinishDeletes", time.Now())
+	return s.next.FinishDeletes(ctx, ids)
+}
+
+func (s *instrumentedStorage) DeadDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	defer s.observe("DeadDeletes", time.Now())
+	return s.next.DeadDeletes(ctx)
+}
+
+func (s *instrumentedStorage) ListDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	defer s.observe("ListDeletes", time.Now())
+	return s.next.ListDeletes(ctx)
+}
+
+func (s *instrumentedStorage) PendingDeletes(ctx context.Context) (int, error) {
+	defer s.observe("PendingDeletes", time.Now())
+	return s.next.PendingDeletes(ctx)
+}
+
+func (s *instrumentedStorage) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	defer s.observe("AddAPIKey", time.Now())
+	return s.next.AddAPIKey(ctx, key)
+}
+
+func (s *instrumentedStorage) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	defer s.observe("GetAPIKeys", time.Now())
+	return s.next.GetAPIKeys(ctx, userID)
+}
+
+func (s *instrumentedStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	defer s.observe("GetAPIKeyByHash", time.Now())
+	return s.next.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *instrumentedStorage) RevokeAPIKey(ctx context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	defer s.observe("RevokeAPIKey", time.Now())
+	return s.next.RevokeAPIKey(ctx, userID, id, revokedAt)
+}
+
+func (s *instrumentedStorage) RegisterUser(ctx context.Context, account models.Account) error {
+	defer s.observe("RegisterUser", time.Now())
+	return s.next.RegisterUser(ctx, account)
+}
+
+func (s *instrumentedStorage) GetAccount(ctx context.Context, login string) (models.Account, bool, error) {
+	defer s.observe("GetAccount", time.Now())
+	return s.next.GetAccount(ctx, login)
+}
+
+func (s *instrumentedStorage) GetAccountByUserID(ctx context.Context, userID int) (models.Account, bool, error) {
+	defer s.observe("GetAccountByUserID", time.Now())
+	return s.next.GetAccountByUserID(ctx, userID)
+}
+
+func (s *instrumentedStorage) MergeUsers(ctx context.Context, from, to int, mergedAt time.Time) (int, error) {
+	defer s.observe("MergeUsers", time.Now())
+	return s.next.MergeUsers(ctx, from, to, mergedAt)
+}
+
+func (s *instrumentedStorage) CreateOrg(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	defer s.observe("CreateOrg", time.Now())
+	return s.next.CreateOrg(ctx, org, ownerID)
+}
+
+func (s *instrumentedStorage) GetUserOrgs(ctx context.Context, userID int) ([]models.OrgMembership, error) {
+	defer s.observe("GetUserOrgs", time.Now())
+	return s.next.GetUserOrgs(ctx, userID)
+}
+
+func (s *instrumentedStorage) GetOrgMembers(ctx context.Context, orgID int64) ([]models.OrgMember, error) {
+	defer s.observe("GetOrgMembers", time.Now())
+	return s.next.GetOrgMembers(ctx, orgID)
+}
+
+func (s *instrumentedStorage) GetOrgRole(ctx context.Context, orgID int64, userID int) (string, bool, error) {
+	defer s.observe("GetOrgRole", time.Now())
+	return s.next.GetOrgRole(ctx, orgID, userID)
+}
+
+func (s *instrumentedStorage) SetOrgMember(ctx context.Context, member models.OrgMember) error {
+	defer s.observe("SetOrgMember", time.Now())
+	return s.next.SetOrgMember(ctx, member)
+}
+
+func (s *instrumentedStorage) RemoveOrgMember(ctx context.Context, orgID int64, userID int) (bool, error) {
+	defer s.observe("RemoveOrgMember", time.Now())
+	return s.next.RemoveOrgMember(ctx, orgID, userID)
+}
+
+func (s *instrumentedStorage) GetOrgURLs(ctx context.Context, orgID int64) (models.BatchUserURLs, error) {
+	defer s.observe("GetOrgURLs", time.Now())
+	return s.next.GetOrgURLs(ctx, orgID)
+}
+
+func (s *instrumentedStorage) GetDeletedOrgURLs(ctx context.Context, orgID int64) ([]models.DeletedURL, error) {
+	defer s.observe("GetDeletedOrgURLs", time.Now())
+	return s.next.GetDeletedOrgURLs(ctx, orgID)
+}
+
+func (s *instrumentedStorage) GetUserQuota(ctx context.Context, userID int) (models.UserQuota, error) {
+	defer s.observe("GetUserQuota", time.Now())
+	return s.next.GetUserQuota(ctx, userID)
+}
+
+func (s *instrumentedStorage) SetUserQuota(ctx context.Context, userID int, quota models.UserQuota) error {
+	defer s.observe("SetUserQuota", time.Now())
+	return s.next.SetUserQuota(ctx, userID, quota)
+}
+
+func (s *instrumentedStorage) CountUserURLs(ctx context.Context, userID int, now time.Time) (int, error) {
+	defer s.observe("CountUserURLs", time.Now())
+	return s.next.CountUserURLs(ctx, userID, now)
+}
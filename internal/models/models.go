@@ -1,7 +1,23 @@
 package models
 
+import "time"
+
+// LinkOptions — необязательные параметры создаваемой ссылки.
+type LinkOptions struct {
+	// ExpiresAt — момент, после которого ссылка перестаёт работать.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL — время жизни ссылки в секундах. Нельзя задавать вместе с ExpiresAt.
+	TTL int64 `json:"ttl,omitempty"`
+	// Alias — желаемый короткий идентификатор вместо случайного.
+	Alias string `json:"alias,omitempty"`
+	// OrgID — организация, которой будет принадлежать ссылка; 0 — личная ссылка.
+	// Задаётся параметром запроса org_id, а не телом.
+	OrgID int64 `json:"-"`
+}
+
 type RequestShortURL struct {
 	URL string `json:"url"`
+	LinkOptions
 }
 
 type ResponseShortURL struct {
@@ -13,6 +29,7 @@ type RequestBatchLongURLs []BatchLongURL
 type BatchLongURL struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	LinkOptions
 }
 
 type ResponseBatchShortURLs []BatchShortURL
@@ -23,10 +40,278 @@ type BatchShortURL struct {
 }
 
 type UserURL struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 }
 
 type BatchUserURLs []UserURL
 
 type RequestDeleteShortURL []string
+
+// DeleteURL — запрос пользователя UserID на удаление его ссылки ShortURL.
+type DeleteURL struct {
+	UserID   int    `json:"user_id"`
+	ShortURL string `json:"short_url"`
+}
+
+// DeletedURL — ссылка в корзине пользователя.
+type DeletedURL struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	// RestoreBefore — до какого момента ссылку можно восстановить.
+	RestoreBefore time.Time `json:"restore_before"`
+}
+
+type RequestRestoreShortURL []string
+
+type ResponseRestoreShortURL struct {
+	Restored    []string `json:"restored"`
+	NotRestored []string `json:"not_restored"`
+}
+
+// DeleteTask — элемент очереди на удаление.
+// Задача с заполненным DeadAt исчерпала попытки и больше не выполняется.
+type DeleteTask struct {
+	ID    int64  `json:"id"`
+	JobID string `json:"job_id"`
+	// RequestedBy — пользователь, создавший задание; по нему задание восстанавливается после перезапуска.
+	// UserID — от имени кого удаляется ссылка: для ссылки организации это её создатель.
+	RequestedBy   int        `json:"requested_by,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UserID        int        `json:"user_id"`
+	ShortURL      string     `json:"short_url"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	DeadAt        *time.Time `json:"dead_at,omitempty"`
+}
+
+type ResponseDeleteJob struct {
+	JobID string `json:"job_id"`
+}
+
+// Статусы задания на удаление и отдельных ссылок в нём.
+const (
+	DeleteStatusPending   = "pending"
+	DeleteStatusSucceeded = "succeeded"
+	DeleteStatusFailed    = "failed"
+)
+
+// DeleteJob — состояние задания на удаление ссылок.
+// Задание остаётся в статусе pending, пока не обработаны все его ссылки,
+// и получает статус failed, если удалить не удалось хотя бы одну.
+type DeleteJob struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Pending    int            `json:"pending"`
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	URLs       []DeleteJobURL `json:"urls"`
+}
+
+type DeleteJobURL struct {
+	ShortURL string `json:"short_url"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Click — один переход по короткой ссылке.
+type Click struct {
+	ShortURL  string    `json:"short_url"`
+	ClickedAt time.Time `json:"clicked_at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// IPHash — HMAC-SHA256 адреса клиента, сам адрес не хранится.
+	IPHash string `json:"ip_hash,omitempty"`
+}
+
+// LinkStats — статистика переходов по ссылке за период [From, To).
+type LinkStats struct {
+	ShortURL       string       `json:"short_url"`
+	From           time.Time    `json:"from"`
+	To             time.Time    `json:"to"`
+	TotalClicks    int          `json:"total_clicks"`
+	UniqueVisitors int          `json:"unique_visitors"`
+	ClicksPerDay   []TimeBucket `json:"clicks_per_day"`
+	ClicksPerHour  []TimeBucket `json:"clicks_per_hour"`
+	TopReferrers   []TopItem    `json:"top_referrers"`
+	TopUserAgents  []TopItem    `json:"top_user_agents"`
+}
+
+// ClickStats — переходы по ссылке за период, сгруппированные хранилищем.
+// Интервалы без переходов в ClicksPerDay и ClicksPerHour не попадают.
+type ClickStats struct {
+	TotalClicks    int
+	UniqueVisitors int
+	ClicksPerDay   []TimeBucket
+	ClicksPerHour  []TimeBucket
+	TopReferrers   []TopItem
+	TopUserAgents  []TopItem
+}
+
+type TimeBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+type TopItem struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+// APIKey — долгоживущий ключ доступа пользователя к API. Сам ключ не хранится, только его хэш.
+type APIKey struct {
+	ID     int64  `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	// Prefix — начало ключа, по которому пользователь узнаёт его в списке.
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type RequestAPIKey struct {
+	Name string `json:"name"`
+}
+
+// ResponseAPIKey — API-ключ в ответе. Key заполняется только при создании ключа.
+type ResponseAPIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Account — учётные данные зарегистрированного пользователя. Анонимный пользователь
+// становится зарегистрированным, когда к его ID привязывается Account.
+type Account struct {
+	UserID int `json:"user_id"`
+	// Username и Email хранятся в нижнем регистре. Email может быть пустым.
+	Username     string    `json:"username"`
+	Email        string    `json:"email,omitempty"`
+	PasswordHash string    `json:"password_hash"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+type RequestRegister struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type RequestLogin struct {
+	// Login — имя пользователя или email.
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type ResponseAccount struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	// MergedURLs — сколько ссылок анонимного пользователя перенесено в аккаунт.
+	MergedURLs int `json:"merged_urls"`
+}
+
+// Роли участников организации в порядке возрастания прав: viewer читает ссылки
+// и статистику организации, editor ещё и создаёт, удаляет и восстанавливает их,
+// owner ещё и управляет составом участников.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// RoleLevel возвращает уровень прав роли; 0 — роль неизвестна.
+func RoleLevel(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleOwner:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Organization — организация, которой могут принадлежать ссылки.
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrgMember struct {
+	OrgID  int64  `json:"org_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// OrgMembership — организация пользователя вместе с его ролью в ней.
+type OrgMembership struct {
+	Organization
+	Role string `json:"role"`
+}
+
+type RequestOrganization struct {
+	Name string `json:"name"`
+}
+
+type RequestOrgMember struct {
+	Role string `json:"role"`
+}
+
+// URLOwner — владелец ссылки: создавший её пользователь и, для ссылок организации, сама организация.
+type URLOwner struct {
+	UserID int
+	// OrgID — организация, которой принадлежит ссылка; 0 — личная ссылка пользователя.
+	OrgID int64
+}
+
+type RequestUpdateURL struct {
+	URL string `json:"url"`
+}
+
+// URLChange — запись истории ссылки: адрес PreviousURL, который заменили, когда и кто это сделал.
+type URLChange struct {
+	ShortURL    string    `json:"short_url"`
+	PreviousURL string    `json:"previous_url"`
+	ChangedAt   time.Time `json:"changed_at"`
+	ChangedBy   int       `json:"changed_by"`
+}
+
+// UserQuota — индивидуальные квоты пользователя. nil — действует квота по умолчанию, 0 — без ограничения.
+type UserQuota struct {
+	MaxLinks     *int `json:"max_links,omitempty"`
+	MaxBatchSize *int `json:"max_batch_size,omitempty"`
+}
+
+// Quota — действующие квоты пользователя и их использование. Нулевая квота — без ограничения.
+type Quota struct {
+	MaxLinks     int `json:"max_links"`
+	UsedLinks    int `json:"used_links"`
+	MaxBatchSize int `json:"max_batch_size"`
+}
+
+// Problem — описание ошибки в формате application/problem+json (RFC 7807).
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	// Расширения для превышенной квоты: её название, значение, использование и запрошенное количество.
+	Quota     string `json:"quota,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	Used      int    `json:"used,omitempty"`
+	Requested int    `json:"requested,omitempty"`
+}
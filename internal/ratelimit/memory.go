@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval — как часто MemoryLimiter удаляет полные корзины, чтобы не копить ключи.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill добавляет токены, накопившиеся к моменту now.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Requests), b.tokens+float64(elapsed)/float64(b.limit.tokensDuration(1)))
+		b.updated = now
+	}
+}
+
+// MemoryLimiter хранит корзины в памяти процесса. Безопасен для конкурентного использования.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+var _ Limiter = (*MemoryLimiter)(nil)
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updated: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	var res Result
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = limit.tokensDuration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = limit.tokensDuration(float64(limit.Requests) - b.tokens)
+	return res, nil
+}
+
+// sweep удаляет корзины, которые к моменту now наполнились: они не отличаются от отсутствующих.
+// Вызывается под блокировкой.
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+
+	for i := 2; i >= 0; i-- {
+		res, err := l.Allow(ctx, "client", limit)
+		if err != nil || !res.Allowed || res.Remaining != i {
+			t.Fatalf("Expected request allowed with %d remaining, got %+v %v", i, res, err)
+		}
+	}
+	res, _ := l.Allow(ctx, "client", limit)
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("Expected request denied for 1s, got %+v", res)
+	}
+	if res, _ = l.Allow(ctx, "other", limit); !res.Allowed {
+		t.Errorf("Expected other client to have its own bucket, got %+v", res)
+	}
+
+	now = now.Add(time.Second)
+	if res, _ = l.Allow(ctx, "client", limit); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Expected one token after 1s, got %+v", res)
+	}
+	if res, _ = l.Allow(ctx, "client", limit); res.Allowed {
+		t.Errorf("Expected request denied, got %+v", res)
+	}
+
+	now = now.Add(time.Hour)
+	if res, _ = l.Allow(ctx, "client", limit); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("Expected bucket to refill up to its capacity, got %+v", res)
+	}
+	if _, ok := l.buckets["other"]; ok {
+		t.Errorf("Expected full bucket to be swept")
+	}
+}
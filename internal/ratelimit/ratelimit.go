@@ -0,0 +1,49 @@
+// Package ratelimit ограничивает частоту запросов по алгоритму корзины токенов.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit — правило корзины токенов: в корзине помещается Requests токенов, и за Period
+// она наполняется заново. Так клиент может сделать Requests запросов подряд, а дальше
+// в среднем не чаще Requests за Period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// PerMinute возвращает правило на n запросов в минуту. При n <= 0 ограничение выключено.
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Period: time.Minute}
+}
+
+// Enabled сообщает, ограничивает ли правило запросы.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// tokensDuration возвращает время, за которое в корзину добавляется tokens токенов.
+func (l Limit) tokensDuration(tokens float64) time.Duration {
+	return time.Duration(tokens * float64(l.Period) / float64(l.Requests))
+}
+
+// Result — решение по запросу и состояние корзины после него.
+type Result struct {
+	Allowed bool
+	// Remaining — сколько запросов ещё можно сделать подряд.
+	Remaining int
+	// RetryAfter — через сколько появится следующий токен, если запрос отклонён.
+	RetryAfter time.Duration
+	// Reset — через сколько корзина наполнится полностью.
+	Reset time.Duration
+}
+
+// Limiter хранит корзины клиентов. MemoryLimiter держит их в памяти процесса; чтобы несколько
+// экземпляров сервиса делили одни лимиты, достаточно реализовать Limiter поверх общего хранилища.
+type Limiter interface {
+	// Allow забирает токен из корзины key с правилом limit, если он там есть.
+	// Корзина, которой ещё нет, считается полной.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
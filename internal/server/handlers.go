@@ -2,20 +2,27 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/vook88/go-url-shortener/internal/authn"
 	"github.com/vook88/go-url-shortener/internal/contextkeys"
 	errors2 "github.com/vook88/go-url-shortener/internal/errors"
 	"github.com/vook88/go-url-shortener/internal/logger"
+	"github.com/vook88/go-url-shortener/internal/metrics"
 	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/ratelimit"
 	"github.com/vook88/go-url-shortener/internal/service"
 	"github.com/vook88/go-url-shortener/internal/storage"
 )
@@ -23,12 +30,90 @@ import (
 type Handler struct {
 	baseURL string
 	storage storage.URLStorage
+	clicks  *service.ClickRecorder
+	deleter *service.Deleter
+	auth    *authn.Authenticator
 	log     zerolog.Logger
 	mux     *chi.Mux
+	// trashRetention — сколько удалённые ссылки можно восстановить.
+	trashRetention time.Duration
+	// workers — фоновые воркеры, запущенные NewHandler.
+	workers sync.WaitGroup
+	// limiter и limits ограничивают частоту запросов; без WithRateLimits ограничений нет.
+	limiter ratelimit.Limiter
+	limits  RateLimits
+	// quota — квоты пользователей по умолчанию; без WithQuota квот нет.
+	quota service.Quota
+	// metrics — метрики Prometheus; без WithMetrics они не собираются и /metrics не отдаётся.
+	metrics *metrics.Metrics
+	// ipHashKey — ключ HMAC для адресов клиентов в статистике; без WithIPHashKey он случайный.
+	ipHashKey []byte
 }
 
-func NewHandler(ctx context.Context, baseURL string, storage storage.URLStorage, log zerolog.Logger) *Handler {
-	go service.BatchDeleteURLs(ctx, storage, log, 10)
+// RateLimits — лимиты групп маршрутов. Нулевое правило не ограничивает запросы.
+type RateLimits struct {
+	// Create — создание ссылок через POST / и /api/shorten.
+	Create ratelimit.Limit
+	// Batch — пакетное создание через /api/shorten/batch.
+	Batch ratelimit.Limit
+	// Redirect — переходы по коротким ссылкам.
+	Redirect ratelimit.Limit
+}
+
+// defaultTrashRetention — срок хранения удалённых ссылок, если он не задан WithTrashRetention.
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// Option задаёт необязательные параметры Handler.
+type Option func(*Handler)
+
+// WithTrashRetention задаёт, сколько удалённые ссылки можно восстановить до окончательного удаления.
+func WithTrashRetention(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.trashRetention = d
+		}
+	}
+}
+
+// WithAuthenticator задаёт ключи, которыми подписываются и проверяются токены пользователей.
+// Без него используется случайный ключ, и токены перестают действовать после перезапуска.
+func WithAuthenticator(auth *authn.Authenticator) Option {
+	return func(h *Handler) {
+		h.auth = auth
+	}
+}
+
+// WithRateLimits включает ограничение частоты запросов с корзинами в limiter.
+func WithRateLimits(limiter ratelimit.Limiter, limits RateLimits) Option {
+	return func(h *Handler) {
+		h.limiter = limiter
+		h.limits = limits
+	}
+}
+
+// WithQuota задаёт квоты пользователей по умолчанию. Индивидуальные квоты хранятся в storage.
+func WithQuota(quota service.Quota) Option {
+	return func(h *Handler) {
+		h.quota = quota
+	}
+}
+
+// WithMetrics включает сбор метрик запросов и обработчиков и отдаёт их на /metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(h *Handler) {
+		h.metrics = m
+	}
+}
+
+// WithIPHashKey задаёт ключ, которым хешируются адреса клиентов в статистике переходов.
+func WithIPHashKey(key []byte) Option {
+	return func(h *Handler) {
+		h.ipHashKey = key
+	}
+}
+
+func NewHandler(ctx context.Context, baseURL string, storage storage.URLStorage, log zerolog.Logger, opts ...Option) *Handler {
+	clicks := service.NewClickRecorder(storage, log, 1000)
 
 	r := chi.NewRouter()
 	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
@@ -38,22 +123,88 @@ func NewHandler(ctx context.Context, baseURL string, storage storage.URLStorage,
 	r.Use(logger.LoggerMiddleware(log))
 	r.Use(gzipMiddleware)
 
-	h := Handler{
+	h := &Handler{
 		baseURL: baseURL,
 		storage: storage,
+		clicks:  clicks,
 		log:     log,
 		mux:     r,
+
+		trashRetention: defaultTrashRetention,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.auth == nil {
+		h.auth = authn.NewEphemeral(authn.DefaultTokenExp)
+	}
+	if len(h.ipHashKey) == 0 {
+		h.ipHashKey = make([]byte, 32)
+		if _, err := rand.Read(h.ipHashKey); err != nil {
+			panic(fmt.Sprintf("cannot generate ip hash key: %v", err))
+		}
+	}
+	if h.metrics != nil {
+		r.Use(h.metrics.Middleware)
+		r.Method(http.MethodGet, "/metrics", h.metrics.Handler())
+	}
+	deleter := service.NewDeleter(storage, log, h.metrics)
+	h.deleter = deleter
+
+	h.goWorker(func() { deleter.Run(ctx, 10, time.Second) })
+	h.goWorker(func() { service.DeleteExpiredURLs(ctx, storage, log, time.Minute) })
+	h.goWorker(func() { service.PurgeDeletedURLs(ctx, storage, log, time.Hour, h.trashRetention) })
+	h.goWorker(func() { clicks.Run(ctx, 100, time.Second) })
 
-	r.With(AuthMiddlewareCheckAndCreate(storage, log)).Post("/", h.generateShortURL)
-	r.With(AuthMiddlewareCheckAndCreate(storage, log)).Post("/api/shorten", h.shortenURL)
-	r.Get("/{id}", h.getShortURL)
+	r.With(h.rateLimit("create", h.limits.Create), AuthMiddlewareCheckAndCreate(h.auth, storage, log)).Post("/", h.generateShortURL)
+	r.With(h.rateLimit("create", h.limits.Create), AuthMiddlewareCheckAndCreate(h.auth, storage, log)).Post("/api/shorten", h.shortenURL)
+	r.With(h.rateLimit("redirect", h.limits.Redirect)).Get("/{id}", h.getShortURL)
 	r.Get("/ping", h.pingDB)
-	r.With(AuthMiddlewareCheckAndCreate(storage, log)).Post("/api/shorten/batch", h.batchShortenURLs)
-	r.With(AuthMiddlewareCheckOnly(log)).Get("/api/user/urls", h.getUserURLs)
-	r.Delete("/api/user/urls", h.deleteUserURLs)
+	r.With(h.rateLimit("batch", h.limits.Batch), AuthMiddlewareCheckAndCreate(h.auth, storage, log)).Post("/api/shorten/batch", h.batchShortenURLs)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/user/urls", h.getUserURLs)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/user/urls/trash", h.getTrash)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Post("/api/user/urls/restore", h.restoreUserURLs)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/user/urls/{id}/stats", h.getURLStats)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Patch("/api/user/urls/{id}", h.updateUserURL)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/user/urls/{id}/history", h.getURLHistory)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Delete("/api/user/urls", h.deleteUserURLs)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/user/jobs/{id}", h.getDeleteJob)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/user/quota", h.getUserQuota)
+	r.With(AuthMiddlewareOptional(h.auth, storage, log), RequireSession).Post("/api/user/register", h.register)
+	r.With(AuthMiddlewareOptional(h.auth, storage, log), RequireSession).Post("/api/user/login", h.login)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log), RequireSession).Post("/api/user/keys", h.createAPIKey)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log), RequireSession).Get("/api/user/keys", h.getAPIKeys)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log), RequireSession).Delete("/api/user/keys/{id}", h.revokeAPIKey)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Post("/api/orgs", h.createOrg)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/orgs", h.getOrgs)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Get("/api/orgs/{id}/members", h.getOrgMembers)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Put("/api/orgs/{id}/members/{userID}", h.setOrgMember)
+	r.With(AuthMiddlewareCheckOnly(h.auth, storage, log)).Delete("/api/orgs/{id}/members/{userID}", h.removeOrgMember)
+
+	return h
+}
+
+// rateLimit возвращает middleware RateLimit для группы маршрутов name или пропускает
+// запросы без проверки, если ограничение не включено.
+func (h *Handler) rateLimit(name string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	if h.limiter == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return RateLimit(h.limiter, name, limit, h.auth, h.storage, h.log)
+}
 
-	return &h
+func (h *Handler) goWorker(run func()) {
+	h.workers.Add(1)
+	go func() {
+		defer h.workers.Done()
+		run()
+	}()
+}
+
+// Wait ждёт, пока фоновые воркеры завершатся после отмены контекста, переданного в NewHandler,
+// и запишут накопленные данные.
+func (h *Handler) Wait() {
+	h.workers.Wait()
 }
 
 func (h *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -79,12 +230,25 @@ func (h *Handler) generateShortURL(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	shortener := service.NewShortener(h.storage, h.baseURL)
+	orgID, err := orgIDParam(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shortener := service.NewShortener(h.storage, h.baseURL, h.quota)
 
-	shortURL, err := shortener.GenerateShortURL(req.Context(), userID, string(url))
+	shortURL, err := shortener.GenerateShortURL(req.Context(), userID, string(url), models.LinkOptions{OrgID: orgID})
+	if writeAccessError(res, err) {
+		return
+	}
+	if h.writeQuotaError(res, err) {
+		return
+	}
 	if err != nil {
 		var dupErr *errors2.DuplicateURLError
 		if errors.As(err, &dupErr) {
+			h.metrics.Conflict(metrics.ConflictURL)
 			res.WriteHeader(http.StatusConflict)
 			_, _ = fmt.Fprintf(res, "%s", h.baseURL+"/"+err.Error())
 			return
@@ -109,17 +273,33 @@ func (h *Handler) getShortURL(res http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		h.log.Error().Msg(err.Error())
 		if errors.Is(err, errors2.ErrURLDeleted) {
+			h.metrics.RedirectResult(metrics.RedirectGone)
 			http.Error(res, "URL not found", http.StatusGone)
 			return
 		}
+		if errors.Is(err, errors2.ErrURLExpired) {
+			h.metrics.RedirectResult(metrics.RedirectGone)
+			http.Error(res, "URL has expired", http.StatusGone)
+			return
+		}
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if !ok {
 		h.log.Error().Msg("URL not found")
+		h.metrics.RedirectResult(metrics.RedirectMiss)
 		http.Error(res, "", http.StatusBadRequest)
 		return
 	}
+	h.metrics.RedirectResult(metrics.RedirectHit)
+
+	h.clicks.Record(models.Click{
+		ShortURL:  prefix,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPHash:    service.HashIP(h.ipHashKey, clientIP(req)),
+	})
 	http.Redirect(res, req, url, http.StatusTemporaryRedirect)
 }
 
@@ -139,15 +319,32 @@ func (h *Handler) shortenURL(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	shortener := service.NewShortener(h.storage, h.baseURL)
+	shortener := service.NewShortener(h.storage, h.baseURL, h.quota)
 
 	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
 	if !ok {
 		http.Error(res, "user id not found in context", http.StatusInternalServerError)
 		return
 	}
-	shortURL, err := shortener.GenerateShortURL(req.Context(), userID, r.URL)
+	orgID, err := orgIDParam(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.OrgID = orgID
+	shortURL, err := shortener.GenerateShortURL(req.Context(), userID, r.URL, r.LinkOptions)
 	responseStatus := http.StatusCreated
+	if writeAccessError(res, err) {
+		return
+	}
+	if h.writeQuotaError(res, err) {
+		return
+	}
+	if errors.Is(err, errors2.ErrShortURLTaken) {
+		h.metrics.Conflict(metrics.ConflictAlias)
+		http.Error(res, "alias is already taken", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		var dupErr *errors2.DuplicateURLError
 		if !errors.As(err, &dupErr) {
@@ -155,6 +352,7 @@ func (h *Handler) shortenURL(res http.ResponseWriter, req *http.Request) {
 			return
 
 		}
+		h.metrics.Conflict(metrics.ConflictURL)
 		shortURL = h.baseURL + "/" + err.Error()
 		responseStatus = http.StatusConflict
 	}
@@ -191,14 +389,33 @@ func (h *Handler) batchShortenURLs(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	s := service.NewShortener(h.storage, h.baseURL)
+	s := service.NewShortener(h.storage, h.baseURL, h.quota)
 
 	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
 	if !ok {
 		http.Error(res, "user id not found in context", http.StatusInternalServerError)
 		return
 	}
+	orgID, err := orgIDParam(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range request {
+		request[i].OrgID = orgID
+	}
 	shortURLs, err := s.BatchGenerateShortURL(req.Context(), userID, request)
+	if writeAccessError(res, err) {
+		return
+	}
+	if h.writeQuotaError(res, err) {
+		return
+	}
+	if errors.Is(err, errors2.ErrShortURLTaken) {
+		h.metrics.Conflict(metrics.ConflictAlias)
+		http.Error(res, "alias is already taken", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
@@ -230,7 +447,16 @@ func (h *Handler) getUserURLs(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	urls, err := h.storage.GetUserURLs(req.Context(), userID)
+	orgID, err := orgIDParam(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urls, err := service.UserURLs(req.Context(), h.storage, userID, orgID)
+	if writeAccessError(res, err) {
+		return
+	}
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
@@ -257,6 +483,71 @@ func (h *Handler) getUserURLs(res http.ResponseWriter, req *http.Request) {
 
 }
 
+func (h *Handler) getTrash(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	orgID, err := orgIDParam(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urls, err := service.Trash(req.Context(), h.storage, userID, orgID, h.trashRetention)
+	if writeAccessError(res, err) {
+		return
+	}
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range urls {
+		urls[i].ShortURL = h.baseURL + "/" + urls[i].ShortURL
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if len(urls) > 0 {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusNoContent)
+	}
+	if err = json.NewEncoder(res).Encode(urls); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) restoreUserURLs(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var urls models.RequestRestoreShortURL
+	if err := json.NewDecoder(req.Body).Decode(&urls); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := service.RestoreURLs(req.Context(), h.storage, userID, urls, h.trashRetention)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(res).Encode(result); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
 func (h *Handler) deleteUserURLs(res http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodDelete {
 		http.Error(res, "Only DELETE requests are allowed!", http.StatusBadRequest)
@@ -265,6 +556,12 @@ func (h *Handler) deleteUserURLs(res http.ResponseWriter, req *http.Request) {
 
 	h.log.Debug().Msg("deleting user URLs")
 
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
 	var urls models.RequestDeleteShortURL
 	err := json.NewDecoder(req.Body).Decode(&urls)
 	if err != nil {
@@ -272,11 +569,594 @@ func (h *Handler) deleteUserURLs(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	s := service.NewShortener(h.storage, h.baseURL)
-	s.BatchDeleteShortURL(req.Context(), urls)
+	jobID, err := h.deleter.Delete(req.Context(), userID, urls)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 
+	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(http.StatusAccepted)
 	h.log.Debug().Msg("sending HTTP 202 response")
+	if err = json.NewEncoder(res).Encode(models.ResponseDeleteJob{JobID: jobID}); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) getDeleteJob(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := h.deleter.Job(userID, chi.URLParam(req, "id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, errors2.ErrJobNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errors2.ErrForbidden):
+			http.Error(res, err.Error(), http.StatusForbidden)
+		default:
+			h.log.Error().Msg(err.Error())
+			http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(res).Encode(job); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) register(res http.ResponseWriter, req *http.Request) {
+	// Ссылки анонимного пользователя, если он есть, переходят в новый аккаунт
+	currentUserID, _ := req.Context().Value(contextkeys.UserIDKey).(int)
+
+	var request models.RequestRegister
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account, merged, err := service.Register(req.Context(), h.storage, currentUserID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, errors2.ErrInvalidAccount):
+			http.Error(res, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errors2.ErrAccountExists):
+			http.Error(res, err.Error(), http.StatusConflict)
+		default:
+			h.log.Error().Msg(err.Error())
+			http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.writeAccount(res, http.StatusCreated, account, merged)
+}
+
+func (h *Handler) login(res http.ResponseWriter, req *http.Request) {
+	currentUserID, _ := req.Context().Value(contextkeys.UserIDKey).(int)
+
+	var request models.RequestLogin
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account, merged, err := service.Login(req.Context(), h.storage, currentUserID, request.Login, request.Password)
+	if err != nil {
+		if errors.Is(err, errors2.ErrInvalidCredentials) {
+			http.Error(res, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.writeAccount(res, http.StatusOK, account, merged)
+}
+
+// writeAccount выдаёт cookie аккаунта и пишет его в ответ.
+func (h *Handler) writeAccount(res http.ResponseWriter, status int, account models.Account, merged int) {
+	encodedValue, err := h.auth.BuildJWTString(account.UserID)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     CookieAuthName,
+		Value:    encodedValue,
+		Path:     "/",
+		HttpOnly: true,
+	})
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	response := models.ResponseAccount{UserID: account.UserID, Username: account.Username, Email: account.Email, MergedURLs: merged}
+	if err = json.NewEncoder(res).Encode(response); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+// maxAPIKeyNameLength ограничивает длину названия API-ключа.
+const maxAPIKeyNameLength = 255
+
+func (h *Handler) createAPIKey(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var request models.RequestAPIKey
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Name) > maxAPIKeyNameLength {
+		http.Error(res, fmt.Sprintf("name must be at most %d bytes", maxAPIKeyNameLength), http.StatusBadRequest)
+		return
+	}
+
+	apiKey, key, err := service.CreateAPIKey(req.Context(), h.storage, userID, request.Name)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := responseAPIKey(apiKey)
+	response.Key = key
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(res).Encode(response); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) getAPIKeys(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	apiKeys, err := h.storage.GetAPIKeys(req.Context(), userID)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.ResponseAPIKey, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		response = append(response, responseAPIKey(apiKey))
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if len(response) > 0 {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusNoContent)
+	}
+	if err = json.NewEncoder(res).Encode(response); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) revokeAPIKey(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(req, "id"), 10, 64)
+	if err != nil {
+		http.Error(res, "invalid key id", http.StatusBadRequest)
+		return
+	}
+
+	if err = service.RevokeAPIKey(req.Context(), h.storage, userID, id); err != nil {
+		if errors.Is(err, errors2.ErrAPIKeyNotFound) {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func responseAPIKey(apiKey models.APIKey) models.ResponseAPIKey {
+	return models.ResponseAPIKey{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		CreatedAt: apiKey.CreatedAt,
+		RevokedAt: apiKey.RevokedAt,
+	}
+}
+
+// maxStatsRange ограничивает период статистики, чтобы почасовой ряд оставался разумного размера.
+const maxStatsRange = 366 * 24 * time.Hour
+
+func (h *Handler) getURLStats(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-30 * 24 * time.Hour)
+	var err error
+	if v := req.URL.Query().Get("from"); v != "" {
+		if from, err = parseStatsTime(v, false); err != nil {
+			http.Error(res, "invalid from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := req.URL.Query().Get("to"); v != "" {
+		if to, err = parseStatsTime(v, true); err != nil {
+			http.Error(res, "invalid to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) || to.Sub(from) > maxStatsRange {
+		http.Error(res, "from must be before to and the range must not exceed 366 days", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := service.LinkStats(req.Context(), h.storage, userID, chi.URLParam(req, "id"), from, to)
+	if writeAccessError(res, err) {
+		return
+	}
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(res).Encode(stats); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+// parseStatsTime разбирает время в формате RFC 3339 или дату YYYY-MM-DD.
+// Дата в конце периода включается целиком.
+func parseStatsTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, nil
+}
+
+func (h *Handler) updateUserURL(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+	defer req.Body.Close()
+
+	var r models.RequestUpdateURL
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := service.UpdateURL(req.Context(), h.storage, userID, chi.URLParam(req, "id"), r.URL)
+	if writeAccessError(res, err) {
+		return
+	}
+	var dupErr *errors2.DuplicateURLError
+	switch {
+	case err == nil:
+		res.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errors2.ErrEmptyURL), errors.Is(err, errors2.ErrInvalidURL):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errors2.ErrURLDeleted):
+		http.Error(res, err.Error(), http.StatusGone)
+	case errors.As(err, &dupErr):
+		// Как и при сокращении, в ответе — уже существующая ссылка на этот адрес
+		h.metrics.Conflict(metrics.ConflictURL)
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusConflict)
+		if err = json.NewEncoder(res).Encode(models.ResponseShortURL{ShortURL: h.baseURL + "/" + dupErr.Error()}); err != nil {
+			h.log.Debug().Msgf("error encoding response: %s", err.Error())
+		}
+	default:
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) getURLHistory(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	changes, err := service.URLHistory(req.Context(), h.storage, userID, chi.URLParam(req, "id"))
+	if writeAccessError(res, err) {
+		return
+	}
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range changes {
+		changes[i].ShortURL = h.baseURL + "/" + changes[i].ShortURL
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if len(changes) > 0 {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusNoContent)
+	}
+	if err = json.NewEncoder(res).Encode(changes); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+// maxOrgNameLength ограничивает длину названия организации.
+const maxOrgNameLength = 255
+
+func (h *Handler) createOrg(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var request models.RequestOrganization
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || len(request.Name) > maxOrgNameLength {
+		http.Error(res, fmt.Sprintf("name must be 1 to %d bytes long", maxOrgNameLength), http.StatusBadRequest)
+		return
+	}
+
+	org, err := service.CreateOrg(req.Context(), h.storage, userID, request.Name)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(res).Encode(org); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) getOrgs(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	orgs, err := h.storage.GetUserOrgs(req.Context(), userID)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if len(orgs) > 0 {
+		res.WriteHeader(http.StatusOK)
+	} else {
+		res.WriteHeader(http.StatusNoContent)
+	}
+	if err = json.NewEncoder(res).Encode(orgs); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) getOrgMembers(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	orgID, err := strconv.ParseInt(chi.URLParam(req, "id"), 10, 64)
+	if err != nil {
+		http.Error(res, "invalid organization id", http.StatusBadRequest)
+		return
+	}
+
+	members, err := service.OrgMembers(req.Context(), h.storage, userID, orgID)
+	if writeAccessError(res, err) {
+		return
+	}
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(res).Encode(members); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) setOrgMember(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	orgID, memberID, err := orgMemberParams(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request models.RequestOrgMember
+	if err = json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	member := models.OrgMember{OrgID: orgID, UserID: memberID, Role: request.Role}
+	err = service.SetOrgMember(req.Context(), h.storage, userID, member)
+	if writeAccessError(res, err) {
+		return
+	}
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(res).Encode(member); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+func (h *Handler) removeOrgMember(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	orgID, memberID, err := orgMemberParams(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = service.RemoveOrgMember(req.Context(), h.storage, userID, orgID, memberID)
+	if writeAccessError(res, err) {
+		return
+	}
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// orgIDParam возвращает организацию из параметра запроса org_id; 0 — параметр не задан.
+func orgIDParam(req *http.Request) (int64, error) {
+	v := req.URL.Query().Get("org_id")
+	if v == "" {
+		return 0, nil
+	}
+	orgID, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || orgID <= 0 {
+		return 0, errors.New("invalid org_id")
+	}
+	return orgID, nil
+}
+
+// orgMemberParams возвращает организацию и участника из пути /api/orgs/{id}/members/{userID}.
+func orgMemberParams(req *http.Request) (int64, int, error) {
+	orgID, err := strconv.ParseInt(chi.URLParam(req, "id"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("invalid organization id")
+	}
+	memberID, err := strconv.Atoi(chi.URLParam(req, "userID"))
+	if err != nil {
+		return 0, 0, errors.New("invalid user id")
+	}
+	return orgID, memberID, nil
+}
+
+// getUserQuota отдаёт квоты пользователя с учётом индивидуальных и число его активных ссылок.
+func (h *Handler) getUserQuota(res http.ResponseWriter, req *http.Request) {
+	userID, ok := req.Context().Value(contextkeys.UserIDKey).(int)
+	if !ok {
+		http.Error(res, "user id not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	quota, err := service.NewShortener(h.storage, h.baseURL, h.quota).Quota(req.Context(), userID)
+	if err != nil {
+		h.log.Error().Msg(err.Error())
+		http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(res).Encode(quota); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+}
+
+// writeQuotaError отвечает 403 в формате application/problem+json, если err — превышение квоты.
+func (h *Handler) writeQuotaError(res http.ResponseWriter, err error) bool {
+	var quotaErr *service.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	problem := models.Problem{
+		Type:      "/problems/quota-exceeded",
+		Title:     "Quota exceeded",
+		Status:    http.StatusForbidden,
+		Detail:    quotaErr.Error(),
+		Quota:     quotaErr.Quota,
+		Limit:     quotaErr.Limit,
+		Used:      quotaErr.Used,
+		Requested: quotaErr.Requested,
+	}
+	res.Header().Set("Content-Type", "application/problem+json")
+	res.WriteHeader(problem.Status)
+	if err = json.NewEncoder(res).Encode(problem); err != nil {
+		h.log.Debug().Msgf("error encoding response: %s", err.Error())
+	}
+	return true
+}
+
+// writeAccessError отвечает на ошибку проверки прав доступа к ссылке или организации
+// и сообщает, была ли err такой ошибкой.
+func writeAccessError(res http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errors2.ErrURLNotFound), errors.Is(err, errors2.ErrOrgNotFound), errors.Is(err, errors2.ErrMemberNotFound):
+		http.Error(res, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errors2.ErrForbidden), errors.Is(err, errors2.ErrOrgForbidden):
+		http.Error(res, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errors2.ErrInvalidRole):
+		http.Error(res, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errors2.ErrLastOwner):
+		http.Error(res, err.Error(), http.StatusConflict)
+	default:
+		return false
+	}
+	return true
 }
 
 func (h *Handler) pingDB(res http.ResponseWriter, req *http.Request) {
@@ -3,18 +3,34 @@ package server
 import (
 	"context"
 	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 
 	"github.com/vook88/go-url-shortener/internal/authn"
 	"github.com/vook88/go-url-shortener/internal/contextkeys"
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/ratelimit"
+	"github.com/vook88/go-url-shortener/internal/service"
 	"github.com/vook88/go-url-shortener/internal/storage"
 )
 
 var CookieAuthName = "auth-token"
 
+// clientIP возвращает адрес клиента без порта.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func checkSupportedContentType(r *http.Request) bool {
 	contentType := r.Header.Get("Content-Type")
 	supportedContentTypes := []string{"application/json", "text/html"}
@@ -56,33 +72,101 @@ func gzipMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddlewareCheckAndCreate(storage storage.URLStorage, log zerolog.Logger) func(http.Handler) http.Handler {
+// Способы, которыми пользователь подтвердил личность. Сохраняются в контексте запроса по ключу contextkeys.AuthMethodKey.
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+	AuthMethodAPIKey = "api_key"
+)
+
+var (
+	// errNoCredentials — в запросе нет ни заголовка Authorization, ни cookie.
+	errNoCredentials = errors.New("no credentials")
+	// errAuthInternal — учётные данные не удалось проверить из-за внутренней ошибки.
+	errAuthInternal = errors.New("cannot check credentials")
+)
+
+// credentials — результат проверки учётных данных запроса. RateLimit проверяет их раньше
+// аутентификации и сохраняет в контексте по ключу contextkeys.CredentialsKey, чтобы
+// middleware аутентификации не проверяли их второй раз.
+type credentials struct {
+	userID int
+	method string
+	// apiKeyID — ID ключа, если method — AuthMethodAPIKey.
+	apiKeyID int64
+	err      error
+}
+
+// authenticate определяет пользователя по заголовку Authorization: Bearer, а без него — по cookie.
+// В заголовке передаётся JWT или API-ключ. Возвращает ID пользователя и способ входа.
+func authenticate(r *http.Request, auth *authn.Authenticator, storage storage.URLStorage) (int, string, error) {
+	c, ok := r.Context().Value(contextkeys.CredentialsKey).(credentials)
+	if !ok {
+		c = checkCredentials(r, auth, storage)
+	}
+	return c.userID, c.method, c.err
+}
+
+func checkCredentials(r *http.Request, auth *authn.Authenticator, storage storage.URLStorage) credentials {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return credentials{err: errors.New("unsupported authorization scheme")}
+		}
+		if service.IsAPIKey(token) {
+			key, err := service.AuthenticateAPIKey(r.Context(), storage, token)
+			if err != nil && !errors.Is(err, errors2.ErrAPIKeyNotFound) {
+				err = errors.Join(errAuthInternal, err)
+			}
+			return credentials{userID: key.UserID, method: AuthMethodAPIKey, apiKeyID: key.ID, err: err}
+		}
+		userID, err := auth.GetUserID(token)
+		return credentials{userID: userID, method: AuthMethodBearer, err: err}
+	}
+
+	cookie, err := r.Cookie(CookieAuthName)
+	if err != nil {
+		if errors.Is(err, http.ErrNoCookie) {
+			return credentials{err: errNoCredentials}
+		}
+		return credentials{err: errors.Join(errAuthInternal, err)}
+	}
+	userID, err := auth.GetUserID(cookie.Value)
+	return credentials{userID: userID, method: AuthMethodCookie, err: err}
+}
+
+func withUser(r *http.Request, userID int, method string) *http.Request {
+	ctx := context.WithValue(r.Context(), contextkeys.UserIDKey, userID)
+	ctx = context.WithValue(ctx, contextkeys.AuthMethodKey, method)
+	return r.WithContext(ctx)
+}
+
+// AuthMiddlewareCheckAndCreate пропускает пользователя с действующими учётными данными,
+// а анонимному создаёт нового пользователя и выдаёт cookie. Неверный заголовок Authorization
+// отклоняется: клиенты API не должны молча получать новую личность.
+func AuthMiddlewareCheckAndCreate(auth *authn.Authenticator, storage storage.URLStorage, log zerolog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var userID int
-
-			cookie, err := r.Cookie(CookieAuthName)
-			if err != nil {
-				log.Error().Msgf("Error when parsing Cookie: %s", err.Error())
-				if !errors.Is(err, http.ErrNoCookie) {
-					log.Debug().Msg(err.Error())
-					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-					return
-				}
-			} else {
-				userID, err = authn.GetUserID(cookie.Value)
-				log.Debug().Msgf("User ID: %d", userID)
-				if err == nil {
-					ctx2 := context.WithValue(r.Context(), contextkeys.UserIDKey, userID)
-					next.ServeHTTP(w, r.WithContext(ctx2))
-					return
-				}
+			userID, method, err := authenticate(r, auth, storage)
+			log.Debug().Msgf("User ID: %d", userID)
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, withUser(r, userID, method))
+				return
+			case errors.Is(err, errAuthInternal):
 				log.Error().Msg(err.Error())
-				if !errors.Is(err, authn.ErrTokenIsNotValid) {
-					http.Error(w, err.Error(), http.StatusUnauthorized)
-					return
-				}
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			case errors.Is(err, errNoCredentials):
+			case method == AuthMethodCookie && errors.Is(err, authn.ErrTokenIsNotValid):
+				log.Error().Msg(err.Error())
+			default:
+				log.Error().Msg(err.Error())
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
 			}
+
 			userID, err = storage.GenerateUserID(r.Context())
 			if err != nil {
 				log.Debug().Msg(err.Error())
@@ -90,7 +174,7 @@ func AuthMiddlewareCheckAndCreate(storage storage.URLStorage, log zerolog.Logger
 				return
 			}
 
-			encodedValue, err2 := authn.BuildJWTString(userID)
+			encodedValue, err2 := auth.BuildJWTString(userID)
 			if err2 != nil {
 				log.Debug().Msg(err2.Error())
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -104,38 +188,112 @@ func AuthMiddlewareCheckAndCreate(storage storage.URLStorage, log zerolog.Logger
 				HttpOnly: true,
 			})
 
-			ctx2 := context.WithValue(r.Context(), contextkeys.UserIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx2))
+			next.ServeHTTP(w, withUser(r, userID, AuthMethodCookie))
 		})
 	}
 }
 
-func AuthMiddlewareCheckOnly(log zerolog.Logger) func(http.Handler) http.Handler {
+// AuthMiddlewareCheckOnly пропускает только пользователя с действующими учётными данными.
+func AuthMiddlewareCheckOnly(auth *authn.Authenticator, storage storage.URLStorage, log zerolog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie(CookieAuthName)
+			userID, method, err := authenticate(r, auth, storage)
 			if err != nil {
-				log.Error().Msgf("Error when parsing Cookie: %s", err.Error())
-				if !errors.Is(err, http.ErrNoCookie) {
-					log.Debug().Msg(err.Error())
+				log.Error().Msg(err.Error())
+				if errors.Is(err, errAuthInternal) {
 					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 					return
 				}
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			userID, err := authn.GetUserID(cookie.Value)
+			next.ServeHTTP(w, withUser(r, userID, method))
+		})
+	}
+}
+
+// AuthMiddlewareOptional определяет пользователя, если учётные данные действительны,
+// и пропускает запрос без пользователя в контексте в остальных случаях.
+func AuthMiddlewareOptional(auth *authn.Authenticator, storage storage.URLStorage, log zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, method, err := authenticate(r, auth, storage)
 			if err != nil {
-				log.Error().Msg(err.Error())
-				if !errors.Is(err, authn.ErrTokenIsNotValid) {
-					http.Error(w, err.Error(), http.StatusUnauthorized)
+				if errors.Is(err, errAuthInternal) {
+					log.Error().Msg(err.Error())
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 					return
 				}
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, withUser(r, userID, method))
+		})
+	}
+}
+
+// RequireSession отклоняет запросы, подписанные API-ключом. Им закрыто управление самими ключами,
+// чтобы утёкший ключ нельзя было использовать для выпуска новых.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if method, _ := r.Context().Value(contextkeys.AuthMethodKey).(string); method == AuthMethodAPIKey {
+			http.Error(w, "API keys cannot manage API keys", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit ограничивает частоту запросов клиента правилом limit. Запросы считаются отдельно
+// для каждого name, так что у групп маршрутов независимые лимиты. Middleware ставится раньше
+// аутентификации, чтобы анонимный клиент не мог без ограничений заводить новых пользователей.
+// Проверенные учётные данные сохраняются в контексте для следующей за ним аутентификации.
+func RateLimit(limiter ratelimit.Limiter, name string, limit ratelimit.Limit, auth *authn.Authenticator, storage storage.URLStorage, log zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := checkCredentials(r, auth, storage)
+			r = r.WithContext(context.WithValue(r.Context(), contextkeys.CredentialsKey, c))
+			res, err := limiter.Allow(r.Context(), name+":"+rateLimitKey(r, c), limit)
+			if err != nil {
+				// Недоступный лимитер не должен останавливать сервис
+				log.Error().Msgf("Cannot check rate limit: %s", err.Error())
+				next.ServeHTTP(w, r)
 				return
 			}
-			ctx2 := context.WithValue(r.Context(), contextkeys.UserIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx2))
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+			header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			header.Set("RateLimit-Policy", strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(ceilSeconds(limit.Period)))
+			if !res.Allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// rateLimitKey возвращает, по чему считать запросы клиента: по действующему API-ключу, по пользователю
+// из действующего токена, а без учётных данных или с недействительными — по адресу. Иначе клиент
+// получал бы новую корзину на каждую выдуманную строку sk_….
+func rateLimitKey(r *http.Request, c credentials) string {
+	switch {
+	case c.err != nil:
+	case c.method == AuthMethodAPIKey:
+		return "key:" + strconv.FormatInt(c.apiKeyID, 10)
+	default:
+		return "user:" + strconv.Itoa(c.userID)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// ceilSeconds округляет длительность вверх до целых секунд, как того требуют заголовки.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
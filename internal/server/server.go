@@ -1,24 +1,93 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"net/http"
 )
 
 type Server struct {
 	httpServer *http.Server
+	// redirectServer перенаправляет HTTP-запросы на HTTPS, если задан WithHTTPRedirect.
+	redirectServer *http.Server
 }
 
+// ServerOption задаёт необязательные параметры Server.
+type ServerOption func(*Server)
+
+// WithTLS включает HTTPS с настройками tlsConfig, в которых уже есть сертификат.
+func WithTLS(tlsConfig *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.httpServer.TLSConfig = tlsConfig
+	}
+}
+
+// WithHTTPRedirect запускает на address HTTP-листенер, перенаправляющий запросы на HTTPS.
+func WithHTTPRedirect(address string) ServerOption {
+	return func(s *Server) {
+		s.redirectServer = &http.Server{
+			Addr:    address,
+			Handler: httpsRedirect(s.httpServer.Addr),
+		}
+	}
+}
+
+// Run принимает соединения, пока сервер не будет остановлен Shutdown.
+// После Shutdown возвращает nil.
 func (s *Server) Run() error {
+	if s.redirectServer == nil {
+		return ignoreClosed(s.serve())
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- ignoreClosed(s.redirectServer.ListenAndServe()) }()
+	go func() { errs <- ignoreClosed(s.serve()) }()
+
+	err := <-errs
+	if err != nil {
+		// Один из листенеров не запустился, второй без него не нужен
+		s.httpServer.Close()
+		s.redirectServer.Close()
+	}
+	return errors.Join(err, <-errs)
+}
+
+func (s *Server) serve() error {
+	if s.httpServer.TLSConfig != nil {
+		// Сертификат уже загружен в TLSConfig
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
 	return s.httpServer.ListenAndServe()
 }
 
-func New(serverAddress string, h *Handler) *Server {
+// Shutdown перестаёт принимать соединения и ждёт завершения текущих запросов,
+// но не дольше, чем позволяет ctx.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	if s.redirectServer != nil {
+		err = s.redirectServer.Shutdown(ctx)
+	}
+	return errors.Join(err, s.httpServer.Shutdown(ctx))
+}
+
+func ignoreClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func New(serverAddress string, h *Handler, opts ...ServerOption) *Server {
 	s := &Server{
 		httpServer: &http.Server{
 			Addr:    serverAddress,
 			Handler: h,
 		},
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	return s
 }
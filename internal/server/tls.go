@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig собирает настройки TLS из конфигурации: сертификат из файлов
+// или самоподписанный, минимальную версию и наборы шифров.
+func NewTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", cfg.TLSMinVersion)
+	}
+	cipherSuites, err := parseCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	var cert tls.Certificate
+	switch {
+	case cfg.TLSSelfSigned && (cfg.TLSCertFile != "" || cfg.TLSKeyFile != ""):
+		return nil, errors.New("self-signed certificate cannot be combined with certificate files")
+	case cfg.TLSSelfSigned:
+		cert, err = selfSignedCertificate(cfg.ServerAddress)
+	case cfg.TLSCertFile == "" || cfg.TLSKeyFile == "":
+		return nil, errors.New("HTTPS requires both certificate and key files or a self-signed certificate")
+	default:
+		cert, err = tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}, nil
+}
+
+// parseCipherSuites переводит имена наборов шифров в идентификаторы.
+// Допускаются только наборы, которые Go считает безопасными.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// selfSignedCertificate генерирует сертификат на год для localhost и хоста из serverAddress.
+func selfSignedCertificate(serverAddress string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"go-url-shortener"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, _, err := net.SplitHostPort(serverAddress); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "localhost" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// httpsRedirect перенаправляет запрос на тот же путь по HTTPS на порт httpsAddress.
+func httpsRedirect(httpsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
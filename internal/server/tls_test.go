@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vook88/go-url-shortener/internal/config"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{name: "self-signed", cfg: config.Config{ServerAddress: "example.com:8443", TLSSelfSigned: true, TLSMinVersion: "1.3"}},
+		{name: "ciphers", cfg: config.Config{TLSSelfSigned: true, TLSMinVersion: "1.2", TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}},
+		{name: "no certificate", cfg: config.Config{TLSMinVersion: "1.2"}, wantErr: true},
+		{name: "self-signed with files", cfg: config.Config{TLSSelfSigned: true, TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", TLSMinVersion: "1.2"}, wantErr: true},
+		{name: "missing files", cfg: config.Config{TLSCertFile: "missing.pem", TLSKeyFile: "missing.pem", TLSMinVersion: "1.2"}, wantErr: true},
+		{name: "unknown version", cfg: config.Config{TLSSelfSigned: true, TLSMinVersion: "2.0"}, wantErr: true},
+		{name: "insecure cipher", cfg: config.Config{TLSSelfSigned: true, TLSMinVersion: "1.2", TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tlsConfig, err := NewTLSConfig(&tc.cfg)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, tlsConfig.Certificates, 1, "Должен быть один сертификат")
+			assert.Equal(t, tlsVersions[tc.cfg.TLSMinVersion], tlsConfig.MinVersion)
+			assert.Len(t, tlsConfig.CipherSuites, len(tc.cfg.TLSCipherSuites))
+		})
+	}
+}
+
+func TestSelfSignedServer(t *testing.T) {
+	tlsConfig, err := NewTLSConfig(&config.Config{TLSSelfSigned: true, TLSMinVersion: "1.2"})
+	assert.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	response, err := client.Get(srv.URL)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode, "Код ответа не совпадает с ожидаемым")
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	testCases := []struct {
+		address  string
+		host     string
+		expected string
+	}{
+		{address: ":8443", host: "example.com:8080", expected: "https://example.com:8443/abc?x=1"},
+		{address: ":443", host: "example.com", expected: "https://example.com/abc?x=1"},
+	}
+	for _, tc := range testCases {
+		request := httptest.NewRequest(http.MethodPost, "http://"+tc.host+"/abc?x=1", nil)
+		response := httptest.NewRecorder()
+		httpsRedirect(tc.address).ServeHTTP(response, request)
+
+		assert.Equal(t, http.StatusPermanentRedirect, response.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Equal(t, tc.expected, response.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// Ограничения на учётные данные. bcrypt учитывает только первые 72 байта пароля.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+var usernameRe = regexp.MustCompile(`^[a-z0-9_.-]{3,64}$`)
+
+// dummyPasswordHash сравнивается с паролем, если аккаунт не найден, чтобы время ответа
+// не выдавало, существует ли такой пользователь. Вычисляется при первом входе.
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+func dummyHash() []byte {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	return dummyPasswordHash
+}
+
+// Register регистрирует пользователя с новым ID. Ссылки анонимного пользователя currentUserID
+// переносятся в аккаунт так же, как при входе; их число возвращается вторым значением.
+// API-ключи и cookie анонимного пользователя к аккаунту доступа не дают.
+func Register(ctx context.Context, storage storage.URLStorage, currentUserID int, request models.RequestRegister) (models.Account, int, error) {
+	account := models.Account{
+		Username: strings.ToLower(strings.TrimSpace(request.Username)),
+		Email:    strings.ToLower(strings.TrimSpace(request.Email)),
+	}
+	if err := validateAccount(account, request.Password); err != nil {
+		return models.Account{}, 0, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.Account{}, 0, err
+	}
+	account.PasswordHash = string(hash)
+	account.RegisteredAt = time.Now().UTC()
+
+	if account.UserID, err = storage.GenerateUserID(ctx); err != nil {
+		return models.Account{}, 0, err
+	}
+	if err = storage.RegisterUser(ctx, account); err != nil {
+		return models.Account{}, 0, err
+	}
+
+	if currentUserID == 0 {
+		return account, 0, nil
+	}
+	if _, registered, err := storage.GetAccountByUserID(ctx, currentUserID); err != nil || registered {
+		return account, 0, err
+	}
+	merged, err := storage.MergeUsers(ctx, currentUserID, account.UserID, time.Now())
+	if err != nil {
+		return models.Account{}, 0, err
+	}
+	return account, merged, nil
+}
+
+// Login проверяет учётные данные и возвращает аккаунт. Ссылки анонимного пользователя
+// currentUserID переносятся в аккаунт; их число возвращается вторым значением.
+// API-ключи анонимного пользователя при этом отзываются.
+func Login(ctx context.Context, storage storage.URLStorage, currentUserID int, login, password string) (models.Account, int, error) {
+	account, ok, err := storage.GetAccount(ctx, strings.ToLower(strings.TrimSpace(login)))
+	if err != nil {
+		return models.Account{}, 0, err
+	}
+	hash := dummyHash()
+	if ok {
+		hash = []byte(account.PasswordHash)
+	}
+	if err = bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return models.Account{}, 0, errors2.ErrInvalidCredentials
+	}
+
+	if currentUserID == 0 || currentUserID == account.UserID {
+		return account, 0, nil
+	}
+	if _, registered, err := storage.GetAccountByUserID(ctx, currentUserID); err != nil || registered {
+		return account, 0, err
+	}
+	merged, err := storage.MergeUsers(ctx, currentUserID, account.UserID, time.Now())
+	if err != nil {
+		return models.Account{}, 0, err
+	}
+	return account, merged, nil
+}
+
+func validateAccount(account models.Account, password string) error {
+	var problems []string
+	if !usernameRe.MatchString(account.Username) {
+		problems = append(problems, "username must be 3-64 characters: letters, digits, '_', '.' or '-'")
+	}
+	if account.Email != "" {
+		if address, err := mail.ParseAddress(account.Email); err != nil || address.Address != account.Email {
+			problems = append(problems, "email is not valid")
+		}
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		problems = append(problems, fmt.Sprintf("password must be %d-%d bytes", minPasswordLength, maxPasswordLength))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errors2.ErrInvalidAccount, strings.Join(problems, "; "))
+	}
+	return nil
+}
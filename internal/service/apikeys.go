@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// APIKeyPrefix отличает API-ключи от JWT в заголовке Authorization.
+const APIKeyPrefix = "sk_"
+
+// apiKeyVisibleLength — сколько первых символов ключа хранится открыто, чтобы его можно было узнать в списке.
+const apiKeyVisibleLength = len(APIKeyPrefix) + 8
+
+// IsAPIKey сообщает, похожа ли строка на API-ключ.
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, APIKeyPrefix)
+}
+
+// CreateAPIKey создаёт пользователю новый API-ключ и возвращает его вместе с самим ключом.
+// Ключ не хранится и больше нигде не возвращается.
+func CreateAPIKey(ctx context.Context, storage storage.URLStorage, userID int, name string) (models.APIKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.APIKey{}, "", err
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiKey, err := storage.AddAPIKey(ctx, models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:apiKeyVisibleLength],
+		Hash:      hashAPIKey(key),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	return apiKey, key, nil
+}
+
+// AuthenticateAPIKey возвращает действующий ключ вместе с его владельцем. Для неизвестного
+// и отозванного ключа возвращается ErrAPIKeyNotFound.
+func AuthenticateAPIKey(ctx context.Context, storage storage.URLStorage, key string) (models.APIKey, error) {
+	apiKey, ok, err := storage.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if !ok || apiKey.RevokedAt != nil {
+		return models.APIKey{}, errors2.ErrAPIKeyNotFound
+	}
+	return apiKey, nil
+}
+
+// RevokeAPIKey отзывает ключ пользователя. Для чужого, неизвестного и уже отозванного ключа
+// возвращается ErrAPIKeyNotFound.
+func RevokeAPIKey(ctx context.Context, storage storage.URLStorage, userID int, id int64) error {
+	ok, err := storage.RevokeAPIKey(ctx, userID, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors2.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// hashAPIKey возвращает хэш ключа для хранения. Ключи случайные и длинные,
+// поэтому медленная хэш-функция с солью не нужна.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// RequireOrgRole проверяет, что у пользователя userID в организации orgID роль не ниже minRole.
+// Если пользователь в организации не состоит, возвращается ErrOrgNotFound, если роли
+// недостаточно — ErrOrgForbidden.
+func RequireOrgRole(ctx context.Context, s storage.URLStorage, userID int, orgID int64, minRole string) error {
+	role, ok, err := s.GetOrgRole(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors2.ErrOrgNotFound
+	}
+	if models.RoleLevel(role) < models.RoleLevel(minRole) {
+		return errors2.ErrOrgForbidden
+	}
+	return nil
+}
+
+// AuthorizeURL проверяет право пользователя userID на действие со ссылкой shortID и возвращает
+// владельца ссылки. Личная ссылка доступна только создавшему её пользователю, ссылка
+// организации — её участникам с ролью не ниже minRole. Для неизвестной ссылки возвращается
+// ErrURLNotFound, для недоступной — ErrForbidden.
+func AuthorizeURL(ctx context.Context, s storage.URLStorage, userID int, shortID string, minRole string) (models.URLOwner, error) {
+	owner, ok, err := s.GetURLOwner(ctx, shortID)
+	if err != nil {
+		return models.URLOwner{}, err
+	}
+	if !ok {
+		return models.URLOwner{}, errors2.ErrURLNotFound
+	}
+	if owner.OrgID == 0 {
+		if owner.UserID != userID {
+			return models.URLOwner{}, errors2.ErrForbidden
+		}
+		return owner, nil
+	}
+	if err = RequireOrgRole(ctx, s, userID, owner.OrgID, minRole); err != nil {
+		if errors.Is(err, errors2.ErrOrgNotFound) || errors.Is(err, errors2.ErrOrgForbidden) {
+			return models.URLOwner{}, errors2.ErrForbidden
+		}
+		return models.URLOwner{}, err
+	}
+	return owner, nil
+}
+
+// authorizeURLs возвращает запросы на изменение ссылок пользователем userID. Хранилище изменяет
+// ссылку только от имени её создателя, поэтому ссылки организаций, где у userID роль не ниже
+// minRole, передаются от имени создателя. Неизвестные и недоступные ссылки возвращаются вторым
+// значением и в запросы не попадают: создатель ссылки организации, которого исключили или
+// понизили, не должен изменять её от своего имени.
+func authorizeURLs(ctx context.Context, s storage.URLStorage, userID int, shortURLs []string, minRole string) ([]models.DeleteURL, []string, error) {
+	urls := make([]models.DeleteURL, 0, len(shortURLs))
+	var rejected []string
+	for _, shortURL := range shortURLs {
+		owner, err := AuthorizeURL(ctx, s, userID, shortURL, minRole)
+		switch {
+		case err == nil:
+			urls = append(urls, models.DeleteURL{UserID: owner.UserID, ShortURL: shortURL})
+		case errors.Is(err, errors2.ErrURLNotFound) || errors.Is(err, errors2.ErrForbidden):
+			rejected = append(rejected, shortURL)
+		default:
+			return nil, nil, err
+		}
+	}
+	return urls, rejected, nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// ClickRecorder копит переходы по ссылкам в буфере и пакетами записывает их в хранилище,
+// чтобы запись аналитики не задерживала редирект.
+type ClickRecorder struct {
+	clicks  chan models.Click
+	storage storage.URLStorage
+	log     zerolog.Logger
+}
+
+func NewClickRecorder(storage storage.URLStorage, log zerolog.Logger, bufferSize int) *ClickRecorder {
+	return &ClickRecorder{
+		clicks:  make(chan models.Click, bufferSize),
+		storage: storage,
+		log:     log,
+	}
+}
+
+// Record ставит переход в очередь на запись. Если буфер заполнен, переход отбрасывается.
+func (r *ClickRecorder) Record(click models.Click) {
+	select {
+	case r.clicks <- click:
+	default:
+		r.log.Warn().Msgf("Click buffer is full, dropping click on %s", click.ShortURL)
+	}
+}
+
+// Run записывает переходы пакетами по batchSize или раз в flushInterval,
+// пока не будет отменён ctx. После отмены записывает всё, что осталось в буфере.
+func (r *ClickRecorder) Run(ctx context.Context, batchSize int, flushInterval time.Duration) {
+	batch := make([]models.Click, 0, batchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.storage.AddClicks(ctx, batch); err != nil {
+			r.log.Error().Msgf("Cannot save %d clicks: %s", len(batch), err.Error())
+		}
+		batch = batch[:0]
+	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case click := <-r.clicks:
+			batch = append(batch, click)
+			if len(batch) >= batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			// ctx уже отменён, поэтому остаток записывается без него
+			for {
+				select {
+				case click := <-r.clicks:
+					batch = append(batch, click)
+				default:
+					flush(context.Background())
+					return
+				}
+			}
+		}
+	}
+}
+
+// HashIP возвращает HMAC-SHA256 адреса клиента с ключом key в шестнадцатеричном виде.
+// Простой SHA-256 не годится: хеш IPv4-адреса обращается перебором всех адресов.
+func HashIP(key []byte, ip string) string {
+	if ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
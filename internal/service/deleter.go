@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/metrics"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+const (
+	// deleteJobTTL — сколько хранится статус завершённого задания на удаление.
+	deleteJobTTL = time.Hour
+	// deleteRetryBase и deleteRetryMax задают экспоненциальную задержку повторных попыток.
+	deleteRetryBase = time.Second
+	deleteRetryMax  = 5 * time.Minute
+	// deleteMaxAttempts — после стольких неудачных попыток задача попадает в список недоставленных.
+	deleteMaxAttempts = 8
+)
+
+// Deleter ставит запросы на удаление ссылок в очередь хранилища, а воркер Run
+// выбирает их оттуда пакетами. Очередь хранится в хранилище, поэтому запросы
+// переживают перезапуск. Каждая задача несёт пользователя, от имени которого
+// выполняется удаление: хранилище удаляет только его ссылки. Для ссылок организаций,
+// которые пользователь вправе удалять, это создатель ссылки.
+// Результат удаления каждой ссылки записывается в задание запроса.
+type Deleter struct {
+	storage storage.URLStorage
+	log     zerolog.Logger
+	jobs    *DeleteJobs
+	// notify будит воркер, когда в очереди появились задачи.
+	notify chan struct{}
+	// metrics получает глубину очереди и длительность удаления пакетов; nil — метрики не пишутся.
+	metrics *metrics.Metrics
+}
+
+func NewDeleter(storage storage.URLStorage, log zerolog.Logger, metrics *metrics.Metrics) *Deleter {
+	return &Deleter{
+		storage: storage,
+		log:     log,
+		jobs:    NewDeleteJobs(deleteJobTTL),
+		notify:  make(chan struct{}, 1),
+		metrics: metrics,
+	}
+}
+
+// Delete ставит ссылки пользователя userID в очередь на удаление и возвращает идентификатор задания.
+func (d *Deleter) Delete(ctx context.Context, userID int, shortURLs []string) (string, error) {
+	jobID, urls, err := d.jobs.Create(userID, shortURLs)
+	if err != nil {
+		return "", err
+	}
+
+	deletions, rejected, err := authorizeURLs(ctx, d.storage, userID, urls, models.RoleEditor)
+	if err == nil {
+		now := time.Now().UTC()
+		tasks := make([]models.DeleteTask, 0, len(deletions))
+		for _, url := range deletions {
+			tasks = append(tasks, models.DeleteTask{
+				JobID:         jobID,
+				RequestedBy:   userID,
+				CreatedAt:     now,
+				UserID:        url.UserID,
+				ShortURL:      url.ShortURL,
+				NextAttemptAt: now,
+			})
+		}
+		err = d.storage.EnqueueDeletes(ctx, tasks)
+	}
+	if err != nil {
+		for _, url := range urls {
+			d.jobs.Finish(jobID, url, err)
+		}
+		return "", err
+	}
+	for _, url := range rejected {
+		d.jobs.Finish(jobID, url, errors2.ErrURLNotFound)
+	}
+
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+	return jobID, nil
+}
+
+// Job возвращает состояние задания jobID пользователя userID.
+func (d *Deleter) Job(userID int, jobID string) (*models.DeleteJob, error) {
+	return d.jobs.Get(userID, jobID)
+}
+
+// Run выбирает из очереди задачи, время которых наступило, и удаляет ссылки пакетами
+// по batchSize. Очередь проверяется раз в pollInterval и сразу после Delete.
+func (d *Deleter) Run(ctx context.Context, batchSize int, pollInterval time.Duration) {
+	d.restoreJobs(ctx)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		tasks, err := d.storage.DueDeletes(ctx, time.Now(), batchSize)
+		if err != nil {
+			d.log.Error().Msgf("Cannot read delete queue: %s", err.Error())
+		}
+		if len(tasks) > 0 {
+			d.process(ctx, tasks)
+		}
+		d.reportQueueDepth(ctx)
+		// Полный пакет — в очереди могут быть ещё задачи
+		if len(tasks) == batchSize {
+			continue
+		}
+
+		select {
+		case <-d.notify:
+		case <-ticker.C:
+		case <-ctx.Done():
+			d.log.Info().Msg("Context cancelled, stopping the batch delete operation.")
+			return
+		}
+	}
+}
+
+// process удаляет ссылки пакета. Если хранилище вернуло ошибку, задачи откладываются
+// с экспоненциальной задержкой, а после deleteMaxAttempts попыток попадают в список недоставленных.
+func (d *Deleter) process(ctx context.Context, tasks []models.DeleteTask) {
+	urls := make([]models.DeleteURL, 0, len(tasks))
+	for _, task := range tasks {
+		urls = append(urls, models.DeleteURL{UserID: task.UserID, ShortURL: task.ShortURL})
+	}
+
+	start := time.Now()
+	deleted, err := d.storage.BatchDeleteURLs(ctx, urls)
+	d.metrics.ObserveDeleteFlush(time.Since(start))
+	if err != nil {
+		if ctx.Err() != nil {
+			// Сервис останавливается: задачи остаются в очереди без траты попытки
+			return
+		}
+		d.log.Error().Msgf("Cannot batch delete URLs: %s", err.Error())
+		d.retry(ctx, tasks, err)
+		return
+	}
+
+	done := make(map[models.DeleteURL]struct{}, len(deleted))
+	for _, url := range deleted {
+		done[url] = struct{}{}
+	}
+	ids := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	if err = d.storage.FinishDeletes(ctx, ids); err != nil {
+		// Задачи выполнятся повторно, удаление идемпотентно
+		d.log.Error().Msgf("Cannot remove finished tasks from delete queue: %s", err.Error())
+	}
+	for _, task := range tasks {
+		if _, ok := done[models.DeleteURL{UserID: task.UserID, ShortURL: task.ShortURL}]; ok {
+			d.jobs.Finish(task.JobID, task.ShortURL, nil)
+		} else {
+			// Ссылки нет или она принадлежит другому пользователю
+			d.jobs.Finish(task.JobID, task.ShortURL, errors2.ErrURLNotFound)
+		}
+	}
+}
+
+// restoreJobs восстанавливает задания по очереди, чтобы их статус был доступен и после перезапуска,
+// и сообщает о недоставленных задачах: их ссылки остаются неудалёнными, пока задачи не разберут вручную.
+func (d *Deleter) restoreJobs(ctx context.Context) {
+	tasks, err := d.storage.ListDeletes(ctx)
+	if err != nil {
+		d.log.Error().Msgf("Cannot read delete queue: %s", err.Error())
+		return
+	}
+	dead, err := d.storage.DeadDeletes(ctx)
+	if err != nil {
+		d.log.Error().Msgf("Cannot read dead delete tasks: %s", err.Error())
+		return
+	}
+	if len(dead) > 0 {
+		d.log.Error().Msgf("%d delete tasks exhausted their attempts and were not executed", len(dead))
+	}
+	tasks = append(tasks, dead...)
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	d.jobs.Restore(tasks)
+}
+
+// reportQueueDepth обновляет метрику глубины очереди, если метрики включены.
+func (d *Deleter) reportQueueDepth(ctx context.Context) {
+	if d.metrics == nil {
+		return
+	}
+	n, err := d.storage.PendingDeletes(ctx)
+	if err != nil {
+		d.log.Error().Msgf("Cannot count delete queue: %s", err.Error())
+		return
+	}
+	d.metrics.SetDeleteQueueDepth(n)
+}
+
+func (d *Deleter) retry(ctx context.Context, tasks []models.DeleteTask, cause error) {
+	now := time.Now().UTC()
+	for i := range tasks {
+		task := &tasks[i]
+		task.Attempts++
+		task.LastError = cause.Error()
+		if task.Attempts >= deleteMaxAttempts {
+			task.DeadAt = &now
+			d.log.Error().Msgf("Giving up deleting %s of user %d after %d attempts: %s", task.ShortURL, task.UserID, task.Attempts, task.LastError)
+			d.jobs.Finish(task.JobID, task.ShortURL, cause)
+			continue
+		}
+		task.NextAttemptAt = now.Add(retryDelay(task.Attempts))
+	}
+	if err := d.storage.UpdateDeleteTasks(ctx, tasks); err != nil {
+		d.log.Error().Msgf("Cannot reschedule delete tasks: %s", err.Error())
+	}
+}
+
+// retryDelay возвращает задержку перед попыткой после attempts неудачных:
+// deleteRetryBase, затем вдвое больше каждый раз, но не больше deleteRetryMax.
+func retryDelay(attempts int) time.Duration {
+	delay := deleteRetryBase
+	for i := 1; i < attempts && delay < deleteRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > deleteRetryMax {
+		delay = deleteRetryMax
+	}
+	return delay
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// UpdateURL меняет адрес ссылки shortID, сохраняя прежний в истории. Личную ссылку может
+// изменить только её владелец, ссылку организации — участник с ролью не ниже editor.
+// Новый адрес должен быть абсолютным URL со схемой http или https.
+func UpdateURL(ctx context.Context, s storage.URLStorage, userID int, shortID, originalURL string) error {
+	if originalURL == "" {
+		return errors2.ErrEmptyURL
+	}
+	if u, err := url.Parse(originalURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors2.ErrInvalidURL
+	}
+	if _, err := AuthorizeURL(ctx, s, userID, shortID, models.RoleEditor); err != nil {
+		return err
+	}
+	return s.UpdateURL(ctx, shortID, originalURL, userID, time.Now().UTC())
+}
+
+// URLHistory возвращает прежние адреса ссылки shortID в порядке смены.
+// Историю видит владелец ссылки, а для ссылки организации — любой её участник.
+func URLHistory(ctx context.Context, s storage.URLStorage, userID int, shortID string) ([]models.URLChange, error) {
+	if _, err := AuthorizeURL(ctx, s, userID, shortID, models.RoleViewer); err != nil {
+		return nil, err
+	}
+	return s.GetURLHistory(ctx, shortID)
+}
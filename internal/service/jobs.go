@@ -0,0 +1,184 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+)
+
+// DeleteJobs хранит в памяти задания на удаление ссылок и их статусы.
+// Завершённые задания удаляются через ttl после окончания.
+type DeleteJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*deleteJob
+	ttl  time.Duration
+}
+
+type deleteJob struct {
+	userID     int
+	createdAt  time.Time
+	finishedAt *time.Time
+	urls       []models.DeleteJobURL
+	// index — позиция ссылки в urls.
+	index   map[string]int
+	pending int
+}
+
+func NewDeleteJobs(ttl time.Duration) *DeleteJobs {
+	return &DeleteJobs{jobs: make(map[string]*deleteJob), ttl: ttl}
+}
+
+// Create регистрирует задание пользователя userID и возвращает его идентификатор
+// и список ссылок без повторов.
+func (j *DeleteJobs) Create(userID int, shortURLs []string) (string, []string, error) {
+	jobID, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	job := &deleteJob{userID: userID, createdAt: now, index: make(map[string]int, len(shortURLs))}
+	urls := make([]string, 0, len(shortURLs))
+	for _, url := range shortURLs {
+		if _, ok := job.index[url]; ok {
+			continue
+		}
+		job.index[url] = len(job.urls)
+		job.urls = append(job.urls, models.DeleteJobURL{ShortURL: url, Status: models.DeleteStatusPending})
+		urls = append(urls, url)
+	}
+	job.pending = len(job.urls)
+	if job.pending == 0 {
+		job.finishedAt = &now
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.prune(now)
+	j.jobs[jobID.String()] = job
+	return jobID.String(), urls, nil
+}
+
+// Restore восстанавливает задания по задачам, оставшимся в очереди после перезапуска.
+// Ссылки, удалённые до перезапуска, в восстановленное задание не попадают, а недоставленные
+// задачи получают статус failed с последней ошибкой. Задания, которые уже есть в памяти, не меняются.
+func (j *DeleteJobs) Restore(tasks []models.DeleteTask) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	restored := make(map[string]*deleteJob)
+	for _, task := range tasks {
+		if _, ok := j.jobs[task.JobID]; ok && restored[task.JobID] == nil {
+			continue
+		}
+		job, ok := restored[task.JobID]
+		if !ok {
+			// Задачи, поставленные до появления RequestedBy, удаляют личные ссылки самого пользователя
+			userID := task.RequestedBy
+			if userID == 0 {
+				userID = task.UserID
+			}
+			job = &deleteJob{userID: userID, createdAt: task.CreatedAt, index: make(map[string]int)}
+			restored[task.JobID] = job
+			j.jobs[task.JobID] = job
+		}
+		if _, ok = job.index[task.ShortURL]; ok {
+			continue
+		}
+		url := models.DeleteJobURL{ShortURL: task.ShortURL, Status: models.DeleteStatusPending}
+		if task.DeadAt != nil {
+			url.Status = models.DeleteStatusFailed
+			url.Error = task.LastError
+		} else {
+			job.pending++
+		}
+		job.index[task.ShortURL] = len(job.urls)
+		job.urls = append(job.urls, url)
+	}
+
+	now := time.Now().UTC()
+	for _, job := range restored {
+		if job.pending == 0 {
+			job.finishedAt = &now
+		}
+	}
+}
+
+// Finish записывает результат удаления ссылки shortURL в задании jobID.
+func (j *DeleteJobs) Finish(jobID string, shortURL string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[jobID]
+	if !ok {
+		return
+	}
+	i, ok := job.index[shortURL]
+	if !ok || job.urls[i].Status != models.DeleteStatusPending {
+		return
+	}
+	if err != nil {
+		job.urls[i].Status = models.DeleteStatusFailed
+		job.urls[i].Error = err.Error()
+	} else {
+		job.urls[i].Status = models.DeleteStatusSucceeded
+	}
+	job.pending--
+	if job.pending == 0 {
+		now := time.Now().UTC()
+		job.finishedAt = &now
+	}
+}
+
+// Get возвращает состояние задания. Задание видит только создавший его пользователь.
+func (j *DeleteJobs) Get(userID int, jobID string) (*models.DeleteJob, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[jobID]
+	if !ok {
+		return nil, errors2.ErrJobNotFound
+	}
+	if job.userID != userID {
+		return nil, errors2.ErrForbidden
+	}
+
+	result := &models.DeleteJob{
+		ID:         jobID,
+		Status:     models.DeleteStatusSucceeded,
+		CreatedAt:  job.createdAt,
+		FinishedAt: job.finishedAt,
+		URLs:       append([]models.DeleteJobURL(nil), job.urls...),
+	}
+	for _, url := range job.urls {
+		switch url.Status {
+		case models.DeleteStatusPending:
+			result.Pending++
+		case models.DeleteStatusSucceeded:
+			result.Succeeded++
+		case models.DeleteStatusFailed:
+			result.Failed++
+		}
+	}
+	switch {
+	case result.Pending > 0:
+		result.Status = models.DeleteStatusPending
+	case result.Failed > 0:
+		result.Status = models.DeleteStatusFailed
+	}
+	return result, nil
+}
+
+// prune удаляет задания, завершённые раньше чем ttl назад. Вызывается под j.mu.
+func (j *DeleteJobs) prune(now time.Time) {
+	for id, job := range j.jobs {
+		if job.finishedAt != nil && now.Sub(*job.finishedAt) > j.ttl {
+			delete(j.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// CreateOrg создаёт организацию, владельцем которой становится пользователь userID.
+func CreateOrg(ctx context.Context, s storage.URLStorage, userID int, name string) (models.Organization, error) {
+	return s.CreateOrg(ctx, models.Organization{Name: name, CreatedAt: time.Now().UTC()}, userID)
+}
+
+// OrgMembers возвращает участников организации. Их видит любой участник.
+func OrgMembers(ctx context.Context, s storage.URLStorage, userID int, orgID int64) ([]models.OrgMember, error) {
+	if err := RequireOrgRole(ctx, s, userID, orgID, models.RoleViewer); err != nil {
+		return nil, err
+	}
+	return s.GetOrgMembers(ctx, orgID)
+}
+
+// SetOrgMember добавляет участника в организацию или меняет его роль. Управлять участниками
+// могут только владельцы, при этом у организации должен остаться хотя бы один владелец.
+func SetOrgMember(ctx context.Context, s storage.URLStorage, userID int, member models.OrgMember) error {
+	if models.RoleLevel(member.Role) == 0 {
+		return errors2.ErrInvalidRole
+	}
+	if err := RequireOrgRole(ctx, s, userID, member.OrgID, models.RoleOwner); err != nil {
+		return err
+	}
+	return s.SetOrgMember(ctx, member)
+}
+
+// RemoveOrgMember исключает участника из организации. Владелец может исключить любого,
+// остальные участники — только себя. Последнего владельца исключить нельзя.
+func RemoveOrgMember(ctx context.Context, s storage.URLStorage, userID int, orgID int64, memberID int) error {
+	minRole := models.RoleOwner
+	if memberID == userID {
+		minRole = models.RoleViewer
+	}
+	if err := RequireOrgRole(ctx, s, userID, orgID, minRole); err != nil {
+		return err
+	}
+	ok, err := s.RemoveOrgMember(ctx, orgID, memberID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors2.ErrMemberNotFound
+	}
+	return nil
+}
+
+// UserURLs возвращает неудалённые личные ссылки пользователя или, если orgID не 0,
+// ссылки организации, в которой он состоит.
+func UserURLs(ctx context.Context, s storage.URLStorage, userID int, orgID int64) (models.BatchUserURLs, error) {
+	if orgID == 0 {
+		return s.GetUserURLs(ctx, userID)
+	}
+	if err := RequireOrgRole(ctx, s, userID, orgID, models.RoleViewer); err != nil {
+		return nil, err
+	}
+	return s.GetOrgURLs(ctx, orgID)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// Названия квот в QuotaExceededError.
+const (
+	QuotaLinks     = "links"
+	QuotaBatchSize = "batch_size"
+)
+
+// Quota — квоты по умолчанию: сколько активных ссылок может быть у пользователя
+// и сколько ссылок можно сократить одним пакетом. Нулевое значение — без ограничения.
+type Quota struct {
+	MaxLinks     int
+	MaxBatchSize int
+}
+
+// QuotaExceededError возвращается, если запрос превысил бы квоту пользователя.
+type QuotaExceededError struct {
+	Quota string
+	Limit int
+	// Used — число активных ссылок пользователя для квоты links.
+	Used      int
+	Requested int
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Quota == QuotaBatchSize {
+		return fmt.Sprintf("batch of %d URLs exceeds the limit of %d", e.Requested, e.Limit)
+	}
+	return fmt.Sprintf("link quota exceeded: %d of %d links used, %d more requested", e.Used, e.Limit, e.Requested)
+}
+
+// Quota возвращает действующие квоты пользователя и число его активных ссылок.
+func (s Shortener) Quota(ctx context.Context, userID int) (models.Quota, error) {
+	quota, err := s.userQuota(ctx, userID)
+	if err != nil {
+		return models.Quota{}, err
+	}
+	used, err := s.storage.CountUserURLs(ctx, userID, time.Now())
+	if err != nil {
+		return models.Quota{}, err
+	}
+	return models.Quota{MaxLinks: quota.MaxLinks, UsedLinks: used, MaxBatchSize: quota.MaxBatchSize}, nil
+}
+
+// userQuota возвращает квоты по умолчанию, заменённые индивидуальными квотами пользователя.
+func (s Shortener) userQuota(ctx context.Context, userID int) (Quota, error) {
+	quota := s.quota
+	override, err := s.storage.GetUserQuota(ctx, userID)
+	if err != nil {
+		return Quota{}, err
+	}
+	if override.MaxLinks != nil {
+		quota.MaxLinks = *override.MaxLinks
+	}
+	if override.MaxBatchSize != nil {
+		quota.MaxBatchSize = *override.MaxBatchSize
+	}
+	return quota, nil
+}
+
+// checkQuota проверяет, что пользователь может создать ещё requested ссылок одним запросом.
+// Проверка выполняется до записи, поэтому одновременные запросы одного пользователя
+// могут превысить квоту на число ссылок в них.
+func (s Shortener) checkQuota(ctx context.Context, userID int, requested int) error {
+	quota, err := s.userQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if quota.MaxBatchSize > 0 && requested > quota.MaxBatchSize {
+		return &QuotaExceededError{Quota: QuotaBatchSize, Limit: quota.MaxBatchSize, Requested: requested}
+	}
+	if quota.MaxLinks == 0 {
+		return nil
+	}
+	used, err := s.storage.CountUserURLs(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if used+requested > quota.MaxLinks {
+		return &QuotaExceededError{Quota: QuotaLinks, Limit: quota.MaxLinks, Used: used, Requested: requested}
+	}
+	return nil
+}
+
+// SetUserQuotas записывает индивидуальные квоты пользователей из конфигурации.
+// Квоты пользователей, которых ещё нет в хранилище, пропускаются с предупреждением.
+func SetUserQuotas(ctx context.Context, s storage.QuotaStore, quotas map[int]models.UserQuota, log zerolog.Logger) error {
+	for userID, quota := range quotas {
+		err := s.SetUserQuota(ctx, userID, quota)
+		if errors.Is(err, errors2.ErrUserNotFound) {
+			log.Warn().Msgf("Cannot set quota of user %d: user not found", userID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set quota of user %d: %w", userID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+func TestSetUserQuotas(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemoryURLStorage()
+	userID, err := s.GenerateUserID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxLinks := 3
+	quotas := map[int]models.UserQuota{
+		userID:     {MaxLinks: &maxLinks},
+		userID + 1: {MaxLinks: &maxLinks},
+	}
+	if err = SetUserQuotas(ctx, s, quotas, zerolog.Nop()); err != nil {
+		t.Fatalf("Expected unknown users to be skipped, got %v", err)
+	}
+
+	shortener := NewShortener(s, "http://localhost:8080", Quota{MaxLinks: 10, MaxBatchSize: 5})
+	quota, err := shortener.Quota(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.MaxLinks != maxLinks || quota.MaxBatchSize != 5 {
+		t.Errorf("Expected max_links %d and default max_batch_size, got %+v", maxLinks, quota)
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -12,45 +13,43 @@ import (
 	"github.com/vook88/go-url-shortener/internal/storage"
 )
 
-var urlsToBeDeletedChan = make(chan string)
+// CompactStorage периодически сжимает данные хранилища, если оно это поддерживает.
+func CompactStorage(ctx context.Context, s storage.URLStorage, log zerolog.Logger, interval time.Duration) {
+	compactor, ok := s.(storage.Compactor)
+	if !ok || interval <= 0 {
+		return
+	}
 
-func BatchDeleteURLs(ctx context.Context, storage storage.URLStorage, log zerolog.Logger, batchSize int) {
-	var urls []string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case url, ok := <-urlsToBeDeletedChan:
-			if !ok {
-				log.Info().Msg("Channel urlsToBeDeletedChan closed.")
-
-				if len(urls) > 0 {
-					if err := storage.BatchDeleteURLs(ctx, urls); err != nil {
-						log.Error().Msgf("Cannot batch delete URLs: %s", err.Error())
-					}
-				}
-				return
+		case <-ticker.C:
+			if err := compactor.Compact(ctx); err != nil {
+				log.Error().Msgf("Cannot compact storage: %s", err.Error())
 			}
-			urls = append(urls, url)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			if len(urls) >= batchSize {
-				if err := storage.BatchDeleteURLs(ctx, urls); err != nil {
-					log.Error().Msgf("Cannot batch delete URLs: %s", err.Error())
-				}
-				urls = urls[:0]
+// DeleteExpiredURLs периодически помечает удалёнными ссылки с истёкшим сроком действия.
+func DeleteExpiredURLs(ctx context.Context, storage storage.URLStorage, log zerolog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := storage.DeleteExpiredURLs(ctx)
+			if err != nil {
+				log.Error().Msgf("Cannot delete expired URLs: %s", err.Error())
+				continue
 			}
-		case <-time.After(time.Millisecond * 1000):
-			if len(urls) > 0 {
-				if err := storage.BatchDeleteURLs(ctx, urls); err != nil {
-					log.Error().Msgf("Cannot batch delete URLs: %s", err.Error())
-				}
-				urls = urls[:0]
+			if n > 0 {
+				log.Info().Msgf("Deleted %d expired URLs", n)
 			}
 		case <-ctx.Done():
-			log.Error().Msg("Context cancelled, stopping the batch delete operation.")
-			return // Выход из функции при отмене контекста
-		}
-
-		if ctx.Err() != nil {
-			log.Error().Msg("Context cancelled, stopping the batch delete operation.")
 			return
 		}
 	}
@@ -59,19 +58,37 @@ func BatchDeleteURLs(ctx context.Context, storage storage.URLStorage, log zerolo
 type Shortener struct {
 	storage storage.URLStorage
 	baseURL string
+	quota   Quota
 }
 
-func NewShortener(storage storage.URLStorage, baseURL string) *Shortener {
-	return &Shortener{storage: storage, baseURL: baseURL}
+func NewShortener(storage storage.URLStorage, baseURL string, quota Quota) *Shortener {
+	return &Shortener{storage: storage, baseURL: baseURL, quota: quota}
 }
 
-func (s Shortener) GenerateShortURL(ctx context.Context, userID int, URL string) (string, error) {
-	shortID, err := id.New()
+// GenerateShortURL сокращает URL. Ссылку организации может создать её участник с ролью не ниже editor.
+// Если у пользователя не осталось квоты на ссылки, возвращается QuotaExceededError.
+func (s Shortener) GenerateShortURL(ctx context.Context, userID int, URL string, opts models.LinkOptions) (string, error) {
+	if err := s.checkOrg(ctx, userID, opts.OrgID); err != nil {
+		return "", err
+	}
+	if err := s.checkQuota(ctx, userID, 1); err != nil {
+		return "", err
+	}
+	expiresAt, err := linkExpiresAt(opts, time.Now())
+	if err != nil {
+		return "", err
+	}
+	shortID, err := newShortID(opts)
 	if err != nil {
 		return "", err
 	}
 
-	err = s.storage.AddURL(ctx, userID, shortID, URL)
+	err = s.storage.AddURL(ctx, userID, database.InsertURL{
+		ShortURL:    shortID,
+		OriginalURL: URL,
+		ExpiresAt:   expiresAt,
+		OrgID:       opts.OrgID,
+	})
 	if err != nil {
 		return "", err
 	}
@@ -79,12 +96,29 @@ func (s Shortener) GenerateShortURL(ctx context.Context, userID int, URL string)
 	return s.baseURL + "/" + shortID, nil
 }
 
+// BatchGenerateShortURL сокращает все URL или ни одного. Если пакет больше разрешённого
+// или в квоте пользователя не хватает места для всех ссылок, возвращается QuotaExceededError.
 func (s Shortener) BatchGenerateShortURL(ctx context.Context, userID int, URLs []models.BatchLongURL) ([]models.BatchShortURL, error) {
+	if err := s.checkQuota(ctx, userID, len(URLs)); err != nil {
+		return nil, err
+	}
 	var shortURLs = make([]models.BatchShortURL, 0, len(URLs))
 	var insertURLs = make([]database.InsertURL, 0, len(URLs))
 
+	now := time.Now()
+	checked := make(map[int64]struct{})
 	for _, URL := range URLs {
-		shortID, err := id.New()
+		if _, ok := checked[URL.OrgID]; !ok {
+			if err := s.checkOrg(ctx, userID, URL.OrgID); err != nil {
+				return nil, err
+			}
+			checked[URL.OrgID] = struct{}{}
+		}
+		expiresAt, err := linkExpiresAt(URL.LinkOptions, now)
+		if err != nil {
+			return nil, err
+		}
+		shortID, err := newShortID(URL.LinkOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -95,6 +129,8 @@ func (s Shortener) BatchGenerateShortURL(ctx context.Context, userID int, URLs [
 		insertURLs = append(insertURLs, database.InsertURL{
 			ShortURL:    shortID,
 			OriginalURL: URL.OriginalURL,
+			ExpiresAt:   expiresAt,
+			OrgID:       URL.OrgID,
 		})
 	}
 	err := s.storage.BatchAddURL(ctx, userID, insertURLs)
@@ -104,8 +140,48 @@ func (s Shortener) BatchGenerateShortURL(ctx context.Context, userID int, URLs [
 	return shortURLs, nil
 }
 
-func (s Shortener) BatchDeleteShortURL(_ context.Context, shortURLs []string) {
-	for _, url := range shortURLs {
-		urlsToBeDeletedChan <- url
+// checkOrg проверяет, что пользователь может создавать ссылки организации orgID; 0 — личная ссылка.
+func (s Shortener) checkOrg(ctx context.Context, userID int, orgID int64) error {
+	if orgID == 0 {
+		return nil
+	}
+	return RequireOrgRole(ctx, s.storage, userID, orgID, models.RoleEditor)
+}
+
+// newShortID возвращает alias из запроса, если он задан, иначе случайный идентификатор.
+func newShortID(opts models.LinkOptions) (string, error) {
+	if opts.Alias == "" {
+		return id.New()
+	}
+	if err := id.ValidateAlias(opts.Alias); err != nil {
+		return "", err
+	}
+	return opts.Alias, nil
+}
+
+var (
+	ErrExpiryConflict = errors.New("only one of expires_at and ttl can be set")
+	ErrInvalidTTL     = errors.New("ttl must be positive")
+	ErrExpiryInPast   = errors.New("expires_at must be in the future")
+)
+
+// linkExpiresAt вычисляет срок действия ссылки из expires_at или ttl.
+func linkExpiresAt(opts models.LinkOptions, now time.Time) (*time.Time, error) {
+	switch {
+	case opts.ExpiresAt != nil && opts.TTL != 0:
+		return nil, ErrExpiryConflict
+	case opts.TTL < 0:
+		return nil, ErrInvalidTTL
+	case opts.TTL > 0:
+		expiresAt := now.Add(time.Duration(opts.TTL) * time.Second).UTC()
+		return &expiresAt, nil
+	case opts.ExpiresAt != nil:
+		if !opts.ExpiresAt.After(now) {
+			return nil, ErrExpiryInPast
+		}
+		expiresAt := opts.ExpiresAt.UTC()
+		return &expiresAt, nil
+	default:
+		return nil, nil
 	}
 }
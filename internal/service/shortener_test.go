@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/models"
+)
+
+func TestLinkExpiresAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC+3", 3*60*60))
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Second)
+
+	tests := []struct {
+		name    string
+		opts    models.LinkOptions
+		want    *time.Time
+		wantErr error
+	}{
+		{name: "no expiry", opts: models.LinkOptions{}},
+		{name: "ttl", opts: models.LinkOptions{TTL: 90}, want: timePtr(now.Add(90 * time.Second))},
+		{name: "expires_at", opts: models.LinkOptions{ExpiresAt: &future}, want: &future},
+		{name: "both", opts: models.LinkOptions{ExpiresAt: &future, TTL: 90}, wantErr: ErrExpiryConflict},
+		{name: "negative ttl", opts: models.LinkOptions{TTL: -1}, wantErr: ErrInvalidTTL},
+		{name: "expires_at in past", opts: models.LinkOptions{ExpiresAt: &past}, wantErr: ErrExpiryInPast},
+		{name: "expires_at now", opts: models.LinkOptions{ExpiresAt: &now}, wantErr: ErrExpiryInPast},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := linkExpiresAt(tt.opts, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && !got.Equal(*tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if got != nil && got.Location() != time.UTC {
+				t.Errorf("Expected UTC time, got %v", got.Location())
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
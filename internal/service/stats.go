@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// topLimit — сколько самых частых источников и браузеров попадает в статистику.
+const topLimit = 10
+
+// LinkStats считает статистику переходов по ссылке shortID за период [from, to).
+// Статистику видит владелец ссылки, а для ссылки организации — любой её участник.
+func LinkStats(ctx context.Context, s storage.URLStorage, userID int, shortID string, from, to time.Time) (*models.LinkStats, error) {
+	if _, err := AuthorizeURL(ctx, s, userID, shortID, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	clicks, err := s.GetClickStats(ctx, shortID, from, to, topLimit)
+	if err != nil {
+		return nil, err
+	}
+	from, to = from.UTC(), to.UTC()
+	return &models.LinkStats{
+		ShortURL:       shortID,
+		From:           from,
+		To:             to,
+		TotalClicks:    clicks.TotalClicks,
+		UniqueVisitors: clicks.UniqueVisitors,
+		ClicksPerDay:   fillBuckets(from, to, 24*time.Hour, clicks.ClicksPerDay),
+		ClicksPerHour:  fillBuckets(from, to, time.Hour, clicks.ClicksPerHour),
+		TopReferrers:   nonNil(clicks.TopReferrers),
+		TopUserAgents:  nonNil(clicks.TopUserAgents),
+	}, nil
+}
+
+// fillBuckets раскладывает интервалы из хранилища по сплошной сетке [from, to) с шагом step,
+// чтобы интервалы без переходов тоже попали в ответ.
+func fillBuckets(from, to time.Time, step time.Duration, counts []models.TimeBucket) []models.TimeBucket {
+	buckets := emptyBuckets(from, to, step)
+	for _, b := range counts {
+		addToBucket(buckets, b.Start, b.Clicks, step)
+	}
+	return buckets
+}
+
+// emptyBuckets возвращает нулевые интервалы длины step, покрывающие [from, to).
+func emptyBuckets(from, to time.Time, step time.Duration) []models.TimeBucket {
+	buckets := []models.TimeBucket{}
+	for start := from.Truncate(step); start.Before(to); start = start.Add(step) {
+		buckets = append(buckets, models.TimeBucket{Start: start})
+	}
+	return buckets
+}
+
+func addToBucket(buckets []models.TimeBucket, t time.Time, clicks int, step time.Duration) {
+	if len(buckets) == 0 {
+		return
+	}
+	i := int(t.UTC().Truncate(step).Sub(buckets[0].Start) / step)
+	if i >= 0 && i < len(buckets) {
+		buckets[i].Clicks += clicks
+	}
+}
+
+// nonNil возвращает пустой список вместо nil, чтобы в JSON был [], а не null.
+func nonNil(items []models.TopItem) []models.TopItem {
+	if items == nil {
+		return []models.TopItem{}
+	}
+	return items
+}
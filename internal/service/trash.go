@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// PurgeDeletedURLs периодически окончательно удаляет ссылки, пролежавшие в корзине дольше retention.
+func PurgeDeletedURLs(ctx context.Context, storage storage.URLStorage, log zerolog.Logger, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := storage.PurgeDeletedURLs(ctx, time.Now().Add(-retention))
+			if err != nil {
+				log.Error().Msgf("Cannot purge deleted URLs: %s", err.Error())
+				continue
+			}
+			if n > 0 {
+				log.Info().Msgf("Purged %d deleted URLs", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Trash возвращает удалённые личные ссылки пользователя или, если orgID не 0, ссылки
+// организации, в которой он состоит, и срок, до которого каждую можно восстановить.
+func Trash(ctx context.Context, storage storage.URLStorage, userID int, orgID int64, retention time.Duration) ([]models.DeletedURL, error) {
+	var urls []models.DeletedURL
+	var err error
+	if orgID == 0 {
+		urls, err = storage.GetDeletedURLs(ctx, userID)
+	} else if err = RequireOrgRole(ctx, storage, userID, orgID, models.RoleViewer); err == nil {
+		urls, err = storage.GetDeletedOrgURLs(ctx, orgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range urls {
+		urls[i].RestoreBefore = urls[i].DeletedAt.Add(retention)
+	}
+	return urls, nil
+}
+
+// RestoreURLs восстанавливает ссылки пользователя и ссылки организаций, где он не ниже editor,
+// удалённые не раньше чем retention назад. Чужие, неудалённые и слишком давно удалённые
+// ссылки попадают в NotRestored.
+func RestoreURLs(ctx context.Context, storage storage.URLStorage, userID int, shortURLs []string, retention time.Duration) (*models.ResponseRestoreShortURL, error) {
+	// Недоступные ссылки попадут в NotRestored вместе с теми, что хранилище не восстановит
+	urls, _, err := authorizeURLs(ctx, storage, userID, shortURLs, models.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := storage.RestoreURLs(ctx, urls, time.Now().Add(-retention))
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]struct{}, len(restored))
+	for _, url := range restored {
+		done[url.ShortURL] = struct{}{}
+	}
+	result := &models.ResponseRestoreShortURL{Restored: []string{}, NotRestored: []string{}}
+	for _, url := range shortURLs {
+		if _, ok := done[url]; ok {
+			result.Restored = append(result.Restored, url)
+		} else {
+			result.NotRestored = append(result.NotRestored, url)
+		}
+	}
+	return result, nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/vook88/go-url-shortener/internal/database"
 	"github.com/vook88/go-url-shortener/internal/models"
@@ -13,8 +14,8 @@ type DBURLStorage struct {
 	db *database.DB
 }
 
-func (s *DBURLStorage) AddURL(ctx context.Context, userID int, id string, url string) error {
-	err := s.db.AddURL(ctx, userID, id, url)
+func (s *DBURLStorage) AddURL(ctx context.Context, userID int, url database.InsertURL) error {
+	err := s.db.AddURL(ctx, userID, url)
 	if err != nil {
 		return err
 	}
@@ -49,6 +50,154 @@ func (s *DBURLStorage) GenerateUserID(ctx context.Context) (int, error) {
 	return s.db.AddUser(ctx)
 }
 
-func (s *DBURLStorage) BatchDeleteURLs(ctx context.Context, urls []string) error {
+func (s *DBURLStorage) BatchDeleteURLs(ctx context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
 	return s.db.BatchDeleteURLs(ctx, urls)
 }
+
+func (s *DBURLStorage) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	return s.db.DeleteExpiredURLs(ctx)
+}
+
+func (s *DBURLStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	return s.db.AddClicks(ctx, clicks)
+}
+
+func (s *DBURLStorage) GetClicks(ctx context.Context, id string, from, to time.Time) ([]models.Click, error) {
+	return s.db.GetClicks(ctx, id, from, to)
+}
+
+func (s *DBURLStorage) GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	return s.db.GetClickStats(ctx, id, from, to, top)
+}
+
+func (s *DBURLStorage) GetURLOwner(ctx context.Context, id string) (models.URLOwner, bool, error) {
+	return s.db.GetURLOwner(ctx, id)
+}
+
+func (s *DBURLStorage) UpdateURL(ctx context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	return s.db.UpdateURL(ctx, id, originalURL, changedBy, changedAt)
+}
+
+func (s *DBURLStorage) GetURLHistory(ctx context.Context, id string) ([]models.URLChange, error) {
+	return s.db.GetURLHistory(ctx, id)
+}
+
+func (s *DBURLStorage) EnqueueDeletes(ctx context.Context, tasks []models.DeleteTask) error {
+	return s.db.EnqueueDeletes(ctx, tasks)
+}
+
+func (s *DBURLStorage) DueDeletes(ctx context.Context, now time.Time, limit int) ([]models.DeleteTask, error) {
+	return s.db.DueDeletes(ctx, now, limit)
+}
+
+func (s *DBURLStorage) UpdateDeleteTasks(ctx context.Context, tasks []models.DeleteTask) error {
+	return s.db.UpdateDeleteTasks(ctx, tasks)
+}
+
+func (s *DBURLStorage) FinishDeletes(ctx context.Context, ids []int64) error {
+	return s.db.FinishDeletes(ctx, ids)
+}
+
+func (s *DBURLStorage) DeadDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	return s.db.DeadDeletes(ctx)
+}
+
+func (s *DBURLStorage) ListDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	return s.db.ListDeletes(ctx)
+}
+
+func (s *DBURLStorage) PendingDeletes(ctx context.Context) (int, error) {
+	return s.db.PendingDeletes(ctx)
+}
+
+func (s *DBURLStorage) GetDeletedURLs(ctx context.Context, userID int) ([]models.DeletedURL, error) {
+	return s.db.GetDeletedURLs(ctx, userID)
+}
+
+func (s *DBURLStorage) RestoreURLs(ctx context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	return s.db.RestoreURLs(ctx, urls, deletedAfter)
+}
+
+func (s *DBURLStorage) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return s.db.PurgeDeletedURLs(ctx, deletedBefore)
+}
+
+func (s *DBURLStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *DBURLStorage) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	return s.db.AddAPIKey(ctx, key)
+}
+
+func (s *DBURLStorage) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	return s.db.GetAPIKeys(ctx, userID)
+}
+
+func (s *DBURLStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	return s.db.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *DBURLStorage) RevokeAPIKey(ctx context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	return s.db.RevokeAPIKey(ctx, userID, id, revokedAt)
+}
+
+func (s *DBURLStorage) RegisterUser(ctx context.Context, account models.Account) error {
+	return s.db.RegisterUser(ctx, account)
+}
+
+func (s *DBURLStorage) GetAccount(ctx context.Context, login string) (models.Account, bool, error) {
+	return s.db.GetAccount(ctx, login)
+}
+
+func (s *DBURLStorage) GetAccountByUserID(ctx context.Context, userID int) (models.Account, bool, error) {
+	return s.db.GetAccountByUserID(ctx, userID)
+}
+
+func (s *DBURLStorage) MergeUsers(ctx context.Context, from, to int, mergedAt time.Time) (int, error) {
+	return s.db.MergeUsers(ctx, from, to, mergedAt)
+}
+
+func (s *DBURLStorage) CreateOrg(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	return s.db.CreateOrg(ctx, org, ownerID)
+}
+
+func (s *DBURLStorage) GetUserOrgs(ctx context.Context, userID int) ([]models.OrgMembership, error) {
+	return s.db.GetUserOrgs(ctx, userID)
+}
+
+func (s *DBURLStorage) GetOrgMembers(ctx context.Context, orgID int64) ([]models.OrgMember, error) {
+	return s.db.GetOrgMembers(ctx, orgID)
+}
+
+func (s *DBURLStorage) GetOrgRole(ctx context.Context, orgID int64, userID int) (string, bool, error) {
+	return s.db.GetOrgRole(ctx, orgID, userID)
+}
+
+func (s *DBURLStorage) SetOrgMember(ctx context.Context, member models.OrgMember) error {
+	return s.db.SetOrgMember(ctx, member)
+}
+
+func (s *DBURLStorage) RemoveOrgMember(ctx context.Context, orgID int64, userID int) (bool, error) {
+	return s.db.RemoveOrgMember(ctx, orgID, userID)
+}
+
+func (s *DBURLStorage) GetOrgURLs(ctx context.Context, orgID int64) (models.BatchUserURLs, error) {
+	return s.db.GetOrgURLs(ctx, orgID)
+}
+
+func (s *DBURLStorage) GetDeletedOrgURLs(ctx context.Context, orgID int64) ([]models.DeletedURL, error) {
+	return s.db.GetDeletedOrgURLs(ctx, orgID)
+}
+
+func (s *DBURLStorage) GetUserQuota(ctx context.Context, userID int) (models.UserQuota, error) {
+	return s.db.GetUserQuota(ctx, userID)
+}
+
+func (s *DBURLStorage) SetUserQuota(ctx context.Context, userID int, quota models.UserQuota) error {
+	return s.db.SetUserQuota(ctx, userID, quota)
+}
+
+func (s *DBURLStorage) CountUserURLs(ctx context.Context, userID int, now time.Time) (int, error) {
+	return s.db.CountUserURLs(ctx, userID, now)
+}
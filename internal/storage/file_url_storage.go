@@ -3,50 +3,653 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/vook88/go-url-shortener/internal/database"
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
+)
+
+// Типы записей в журнале файлового хранилища.
+// Записи без поля op считаются EventOpCreate — так писали журнал старые версии.
+const (
+	EventOpCreate      = "create"
+	EventOpBatchCreate = "batch_create"
+	EventOpDelete      = "delete"
+	EventOpSoftDelete  = "soft_delete"
+	EventOpUserCreate  = "user_create"
+	EventOpClicks      = "clicks"
+	EventOpRestore     = "restore"
+	EventOpPurge       = "purge"
+	EventOpURLUpdate   = "url_update"
+
+	// Изменения очереди на удаление.
+	EventOpDeleteEnqueue = "delete_enqueue"
+	EventOpDeleteUpdate  = "delete_update"
+	EventOpDeleteFinish  = "delete_finish"
+
+	// Изменения API-ключей.
+	EventOpAPIKeyCreate = "api_key_create"
+	EventOpAPIKeyRevoke = "api_key_revoke"
+
+	// Регистрация пользователей и перенос данных анонимного пользователя.
+	EventOpUserRegister = "user_register"
+	EventOpUserMerge    = "user_merge"
+
+	// Изменения организаций и их участников.
+	EventOpOrgCreate       = "org_create"
+	EventOpOrgMemberSet    = "org_member_set"
+	EventOpOrgMemberRemove = "org_member_remove"
+
+	// Изменение индивидуальных квот пользователя.
+	EventOpUserQuotaSet = "user_quota_set"
 )
 
+type EventURL struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	OrgID       int64      `json:"org_id,omitempty"`
+}
+
+// FileURLStorage хранит данные в памяти и записывает каждое изменение в журнал.
+// При старте загружается последний снимок (filepath + ".snapshot"),
+// а затем воспроизводятся записи журнала, сделанные после него.
 type FileURLStorage struct {
 	*MemoryURLStorage
 	filepath string
+
+	// mu упорядочивает запись в журнал и применение изменений в памяти.
+	mu   sync.Mutex
+	file *os.File
+	// lastSeq — номер последней записи журнала.
+	lastSeq int64
+	// pending — число записей журнала, не вошедших в снимок.
+	pending int
 }
 
 var _ URLStorage = (*FileURLStorage)(nil)
 
-func (f *FileURLStorage) AddURL(ctx context.Context, userID int, id string, url string) error {
-	err := f.MemoryURLStorage.AddURL(ctx, userID, id, url)
+func NewFileURLStorage(filepath string) (*FileURLStorage, error) {
+	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	newUUID, err := uuid.NewRandom()
+	memoryStorage := NewMemoryURLStorage()
+	snap, err := readSnapshot(snapshotPath(filepath))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot read snapshot of %s: %w", filepath, err)
+	}
+	var lastSeq int64
+	if snap != nil {
+		memoryStorage.restore(snap)
+		lastSeq = snap.LastSeq
+	}
+
+	lastSeq, pending, err := replayEvents(file, memoryStorage, lastSeq)
 	if err != nil {
-		panic(err)
+		file.Close()
+		return nil, fmt.Errorf("cannot replay %s: %w", filepath, err)
 	}
 
-	event := Event{
-		UUID:        newUUID,
+	return &FileURLStorage{
+		MemoryURLStorage: memoryStorage,
+		filepath:         filepath,
+		file:             file,
+		lastSeq:          lastSeq,
+		pending:          pending,
+	}, nil
+}
+
+func snapshotPath(filepath string) string {
+	return filepath + ".snapshot"
+}
+
+func (f *FileURLStorage) AddURL(ctx context.Context, userID int, url database.InsertURL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.MemoryURLStorage.canAddURLs(userID, []database.InsertURL{url}); err != nil {
+		return err
+	}
+	err := f.writeEvent(&Event{
+		Op:          EventOpCreate,
 		UserID:      userID,
-		ShortURL:    id,
-		OriginalURL: url,
+		ShortURL:    url.ShortURL,
+		OriginalURL: url.OriginalURL,
+		ExpiresAt:   url.ExpiresAt,
+		OrgID:       url.OrgID,
+	})
+	if err != nil {
+		return err
+	}
+	return f.MemoryURLStorage.AddURL(ctx, userID, url)
+}
+
+func (f *FileURLStorage) BatchAddURL(ctx context.Context, userID int, urls []database.InsertURL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.MemoryURLStorage.canAddURLs(userID, urls); err != nil {
+		return err
+	}
+	eventURLs := make([]EventURL, 0, len(urls))
+	for _, url := range urls {
+		eventURLs = append(eventURLs, EventURL{ShortURL: url.ShortURL, OriginalURL: url.OriginalURL, ExpiresAt: url.ExpiresAt, OrgID: url.OrgID})
+	}
+	if err := f.writeEvent(&Event{Op: EventOpBatchCreate, UserID: userID, URLs: eventURLs}); err != nil {
+		return err
+	}
+	return f.MemoryURLStorage.BatchAddURL(ctx, userID, urls)
+}
+
+func (f *FileURLStorage) DeleteURL(ctx context.Context, userID int, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writeEvent(&Event{Op: EventOpDelete, UserID: userID, ShortURL: id}); err != nil {
+		return err
+	}
+	return f.MemoryURLStorage.DeleteURL(ctx, userID, id)
+}
+
+func (f *FileURLStorage) BatchDeleteURLs(_ context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deletedAt := time.Now().UTC()
+	if err := f.writeEvent(&Event{Op: EventOpSoftDelete, Deletions: urls, DeletedAt: &deletedAt}); err != nil {
+		return nil, err
+	}
+	return f.MemoryURLStorage.softDeleteURLs(urls, deletedAt), nil
+}
+
+func (f *FileURLStorage) DeleteExpiredURLs(_ context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.MemoryURLStorage
+	deletedAt := time.Now().UTC()
+	m.mu.RLock()
+	urls := m.expiredURLs(deletedAt)
+	m.mu.RUnlock()
+	if len(urls) == 0 {
+		return 0, nil
+	}
+	if err := f.writeEvent(&Event{Op: EventOpSoftDelete, Deletions: urls, DeletedAt: &deletedAt}); err != nil {
+		return 0, err
+	}
+	m.softDeleteURLs(urls, deletedAt)
+	return len(urls), nil
+}
+
+func (f *FileURLStorage) RestoreURLs(_ context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	deletedAfter = deletedAfter.UTC()
+	if err := f.writeEvent(&Event{Op: EventOpRestore, Deletions: urls, DeletedAt: &deletedAfter}); err != nil {
+		return nil, err
+	}
+	return f.MemoryURLStorage.restoreURLs(urls, deletedAfter), nil
+}
+
+func (f *FileURLStorage) PurgeDeletedURLs(_ context.Context, deletedBefore time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Ссылки меняются только под f.mu, поэтому до удаления их никто не восстановит
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	urls := m.deletedURLsBefore(deletedBefore)
+	m.mu.RUnlock()
+	if len(urls) == 0 {
+		return 0, nil
+	}
+	if err := f.writeEvent(&Event{Op: EventOpPurge, ShortURLs: urls}); err != nil {
+		return 0, err
+	}
+	m.purgeURLs(urls)
+	return len(urls), nil
+}
+
+func (f *FileURLStorage) UpdateURL(_ context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	err := m.checkUpdateURL(id, originalURL)
+	unchanged := err == nil && m.records[id].originalURL == originalURL
+	m.mu.RUnlock()
+	if err != nil || unchanged {
+		return err
+	}
+	changedAt = changedAt.UTC()
+	if err = f.writeEvent(&Event{Op: EventOpURLUpdate, UserID: changedBy, ShortURL: id, OriginalURL: originalURL, ChangedAt: &changedAt}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateURL(id, originalURL, changedBy, changedAt)
+	return nil
+}
+
+func (f *FileURLStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.writeEvent(&Event{Op: EventOpClicks, Clicks: clicks}); err != nil {
+		return err
+	}
+	return f.MemoryURLStorage.AddClicks(ctx, clicks)
+}
+
+func (f *FileURLStorage) EnqueueDeletes(_ context.Context, tasks []models.DeleteTask) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(tasks) == 0 {
+		return nil
+	}
+	// Номера назначаются до записи в журнал, чтобы при восстановлении задачи получили те же.
+	// Между назначением и вставкой их не может занять другой вызов: все изменения идут под f.mu.
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	tasks = m.numberDeleteTasks(tasks)
+	m.mu.RUnlock()
+	if err := f.writeEvent(&Event{Op: EventOpDeleteEnqueue, Tasks: tasks}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addDeleteTasks(tasks)
+	return nil
+}
+
+func (f *FileURLStorage) UpdateDeleteTasks(_ context.Context, tasks []models.DeleteTask) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(tasks) == 0 {
+		return nil
+	}
+	if err := f.writeEvent(&Event{Op: EventOpDeleteUpdate, Tasks: tasks}); err != nil {
+		return err
+	}
+	f.MemoryURLStorage.updateDeleteTasks(tasks)
+	return nil
+}
+
+func (f *FileURLStorage) FinishDeletes(_ context.Context, ids []int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := f.writeEvent(&Event{Op: EventOpDeleteFinish, TaskIDs: ids}); err != nil {
+		return err
+	}
+	f.MemoryURLStorage.finishDeleteTasks(ids)
+	return nil
+}
+
+func (f *FileURLStorage) AddAPIKey(_ context.Context, key models.APIKey) (models.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Номер, назначенный до записи в журнал, не займёт другой вызов: все изменения идут под f.mu
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	key, err := m.numberAPIKey(key)
+	m.mu.RUnlock()
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if err = f.writeEvent(&Event{Op: EventOpAPIKeyCreate, UserID: key.UserID, APIKey: &key}); err != nil {
+		return models.APIKey{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insertAPIKey(key)
+	return key, nil
+}
+
+func (f *FileURLStorage) RevokeAPIKey(_ context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.MemoryURLStorage.canRevokeAPIKey(userID, id) {
+		return false, nil
+	}
+	revokedAt = revokedAt.UTC()
+	if err := f.writeEvent(&Event{Op: EventOpAPIKeyRevoke, UserID: userID, APIKey: &models.APIKey{ID: id, RevokedAt: &revokedAt}}); err != nil {
+		return false, err
+	}
+	return f.MemoryURLStorage.revokeAPIKey(userID, id, revokedAt), nil
+}
+
+func (f *FileURLStorage) RegisterUser(_ context.Context, account models.Account) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	err := m.checkAccount(account)
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err = f.writeEvent(&Event{Op: EventOpUserRegister, UserID: account.UserID, Account: &account}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.addAccount(account)
+	m.mu.Unlock()
+	return nil
+}
+
+func (f *FileURLStorage) MergeUsers(_ context.Context, from, to int, mergedAt time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if from == to {
+		return 0, nil
+	}
+	mergedAt = mergedAt.UTC()
+	if err := f.writeEvent(&Event{Op: EventOpUserMerge, UserID: to, FromUserID: from, ChangedAt: &mergedAt}); err != nil {
+		return 0, err
+	}
+	m := f.MemoryURLStorage
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mergeUsers(from, to, mergedAt), nil
+}
+
+func (f *FileURLStorage) CreateOrg(_ context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Номер, назначенный до записи в журнал, не займёт другой вызов: все изменения идут под f.mu
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	org = m.numberOrg(org)
+	m.mu.RUnlock()
+	org.CreatedAt = org.CreatedAt.UTC()
+	if err := f.writeEvent(&Event{Op: EventOpOrgCreate, UserID: ownerID, Org: &org}); err != nil {
+		return models.Organization{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insertOrg(org, ownerID)
+	return org, nil
+}
+
+func (f *FileURLStorage) SetOrgMember(_ context.Context, member models.OrgMember) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	err := m.checkSetOrgMember(member)
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err = f.writeEvent(&Event{Op: EventOpOrgMemberSet, UserID: member.UserID, OrgID: member.OrgID, Role: member.Role}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setOrgMember(member)
+	return nil
+}
+
+func (f *FileURLStorage) RemoveOrgMember(_ context.Context, orgID int64, userID int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	_, ok := m.orgMembers[orgID][userID]
+	lastOwner := m.isLastOwner(orgID, userID)
+	m.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	if lastOwner {
+		return false, errors2.ErrLastOwner
+	}
+	if err := f.writeEvent(&Event{Op: EventOpOrgMemberRemove, UserID: userID, OrgID: orgID}); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.removeOrgMember(orgID, userID), nil
+}
+
+func (f *FileURLStorage) SetUserQuota(_ context.Context, userID int, quota models.UserQuota) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.MemoryURLStorage
+	m.mu.RLock()
+	err := m.checkUser(userID)
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err = f.writeEvent(&Event{Op: EventOpUserQuotaSet, UserID: userID, Quota: &quota}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.setUserQuota(userID, quota)
+	m.mu.Unlock()
+	return nil
+}
+
+func (f *FileURLStorage) GenerateUserID(_ context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	userID := f.MemoryURLStorage.nextUserID()
+	if err := f.writeEvent(&Event{Op: EventOpUserCreate, UserID: userID}); err != nil {
+		return 0, err
+	}
+	f.MemoryURLStorage.registerUserID(userID)
+	return userID, nil
+}
+
+// Compact записывает снимок текущего состояния и очищает журнал.
+// Если процесс упадёт между записью снимка и очисткой журнала, при старте
+// записи, уже вошедшие в снимок, будут пропущены по номеру.
+func (f *FileURLStorage) Compact(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pending == 0 {
+		return nil
 	}
+	if err := writeSnapshot(snapshotPath(f.filepath), f.MemoryURLStorage.dump(f.lastSeq)); err != nil {
+		return err
+	}
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.pending = 0
+	return nil
+}
+
+// Close закрывает файл журнала.
+func (f *FileURLStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	file, err := os.OpenFile(f.filepath, os.O_WRONLY|os.O_APPEND, 0666)
+	return f.file.Close()
+}
+
+// writeEvent дописывает запись в журнал и сбрасывает её на диск. Вызывается под f.mu.
+func (f *FileURLStorage) writeEvent(event *Event) error {
+	newUUID, err := uuid.NewRandom()
 	if err != nil {
 		return err
 	}
+	event.UUID = newUUID
+	event.Seq = f.lastSeq + 1
 
-	defer file.Close()
+	if err = json.NewEncoder(f.file).Encode(event); err != nil {
+		return err
+	}
+	if err = f.file.Sync(); err != nil {
+		return err
+	}
+	f.lastSeq = event.Seq
+	f.pending++
+	return nil
+}
 
-	if err2 := json.NewEncoder(file).Encode(&event); err2 != nil {
-		err3 := f.MemoryURLStorage.DeleteURL(ctx, userID, id)
-		if err3 != nil {
-			return err3
+// replayEvents применяет записи журнала с номером больше snapshotSeq
+// и возвращает номер последней записи и число применённых записей.
+// Записям старого формата без номера присваивается следующий номер по порядку.
+func replayEvents(r io.Reader, m *MemoryURLStorage, snapshotSeq int64) (int64, int, error) {
+	lastSeq := snapshotSeq
+	var seq int64
+	applied := 0
+
+	dec := json.NewDecoder(r)
+	for {
+		event := &Event{}
+		err := dec.Decode(event)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return lastSeq, applied, nil
+			}
+			return 0, 0, err
+		}
+		if event.Seq == 0 {
+			event.Seq = seq + 1
+		}
+		seq = event.Seq
+		if event.Seq <= snapshotSeq {
+			continue
 		}
-		return err2
+		if err = applyEvent(m, event); err != nil {
+			return 0, 0, err
+		}
+		lastSeq = event.Seq
+		applied++
 	}
+}
+
+func applyEvent(m *MemoryURLStorage, event *Event) error {
+	m.registerUserID(event.UserID)
 
+	switch event.Op {
+	case "", EventOpCreate:
+		m.mu.Lock()
+		m.addURL(event.UserID, database.InsertURL{ShortURL: event.ShortURL, OriginalURL: event.OriginalURL, ExpiresAt: event.ExpiresAt, OrgID: event.OrgID})
+		m.mu.Unlock()
+	case EventOpBatchCreate:
+		m.mu.Lock()
+		for _, url := range event.URLs {
+			m.addURL(event.UserID, database.InsertURL{ShortURL: url.ShortURL, OriginalURL: url.OriginalURL, ExpiresAt: url.ExpiresAt, OrgID: url.OrgID})
+		}
+		m.mu.Unlock()
+	case EventOpDelete:
+		return m.DeleteURL(context.Background(), event.UserID, event.ShortURL)
+	case EventOpSoftDelete:
+		if event.DeletedAt == nil {
+			return fmt.Errorf("event %s: deleted_at is missing", event.UUID)
+		}
+		m.softDeleteAnyURLs(event.ShortURLs, *event.DeletedAt)
+		m.softDeleteURLs(event.Deletions, *event.DeletedAt)
+	case EventOpRestore:
+		if event.DeletedAt == nil {
+			return fmt.Errorf("event %s: deleted_at is missing", event.UUID)
+		}
+		m.restoreURLs(event.Deletions, *event.DeletedAt)
+	case EventOpPurge:
+		m.purgeURLs(event.ShortURLs)
+	case EventOpURLUpdate:
+		if event.ChangedAt == nil {
+			return fmt.Errorf("event %s: changed_at is missing", event.UUID)
+		}
+		m.mu.Lock()
+		m.updateURL(event.ShortURL, event.OriginalURL, event.UserID, *event.ChangedAt)
+		m.mu.Unlock()
+	case EventOpClicks:
+		return m.AddClicks(context.Background(), event.Clicks)
+	case EventOpDeleteEnqueue:
+		m.enqueueDeleteTasks(event.Tasks)
+	case EventOpDeleteUpdate:
+		m.updateDeleteTasks(event.Tasks)
+	case EventOpDeleteFinish:
+		m.finishDeleteTasks(event.TaskIDs)
+	case EventOpAPIKeyCreate, EventOpAPIKeyRevoke:
+		if event.APIKey == nil {
+			return fmt.Errorf("event %s: api_key is missing", event.UUID)
+		}
+		if event.Op == EventOpAPIKeyCreate {
+			m.addAPIKey(*event.APIKey)
+		} else if event.APIKey.RevokedAt != nil {
+			m.revokeAPIKey(event.UserID, event.APIKey.ID, *event.APIKey.RevokedAt)
+		}
+	case EventOpUserRegister:
+		if event.Account == nil {
+			return fmt.Errorf("event %s: account is missing", event.UUID)
+		}
+		m.mu.Lock()
+		m.addAccount(*event.Account)
+		m.mu.Unlock()
+	case EventOpUserMerge:
+		m.registerUserID(event.FromUserID)
+		// В записях старого формата времени нет: ключи, которые тогда переносились, отзываются сейчас
+		mergedAt := time.Now().UTC()
+		if event.ChangedAt != nil {
+			mergedAt = *event.ChangedAt
+		}
+		m.mu.Lock()
+		m.mergeUsers(event.FromUserID, event.UserID, mergedAt)
+		m.mu.Unlock()
+	case EventOpOrgCreate:
+		if event.Org == nil {
+			return fmt.Errorf("event %s: org is missing", event.UUID)
+		}
+		m.addOrg(*event.Org, event.UserID)
+	case EventOpOrgMemberSet:
+		m.mu.Lock()
+		m.setOrgMember(models.OrgMember{OrgID: event.OrgID, UserID: event.UserID, Role: event.Role})
+		m.mu.Unlock()
+	case EventOpOrgMemberRemove:
+		m.mu.Lock()
+		m.removeOrgMember(event.OrgID, event.UserID)
+		m.mu.Unlock()
+	case EventOpUserQuotaSet:
+		if event.Quota == nil {
+			return fmt.Errorf("event %s: quota is missing", event.UUID)
+		}
+		m.mu.Lock()
+		m.setUserQuota(event.UserID, *event.Quota)
+		m.mu.Unlock()
+	case EventOpUserCreate:
+	default:
+		return fmt.Errorf("event %s: unknown op %q", event.UUID, event.Op)
+	}
 	return nil
 }
@@ -3,96 +3,1086 @@ package storage
 import (
 	"context"
 	"errors"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/vook88/go-url-shortener/internal/database"
 	errors2 "github.com/vook88/go-url-shortener/internal/errors"
 	"github.com/vook88/go-url-shortener/internal/models"
 )
 
+// urlRecord хранит одну сокращённую ссылку.
+type urlRecord struct {
+	userID int
+	// orgID — организация, которой принадлежит ссылка; 0 — личная ссылка.
+	orgID       int64
+	originalURL string
+	deletedAt   *time.Time
+	expiresAt   *time.Time
+}
+
+// MemoryURLStorage хранит ссылки в памяти процесса.
+// Все методы безопасны для конкурентного использования.
 type MemoryURLStorage struct {
-	urls                map[int]map[string]string
+	mu sync.RWMutex
+	// records — глобальный индекс shortID -> запись, используется при редиректе.
+	records map[string]*urlRecord
+	// userURLs — обратный индекс личных ссылок пользователя originalURL -> shortID для поиска дубликатов.
+	userURLs map[int]map[string]string
+	// orgURLs — такой же индекс для ссылок организаций.
+	orgURLs map[int64]map[string]string
+	// clicks — переходы по коротким ссылкам в порядке записи.
+	clicks map[string][]models.Click
+	// history — прежние адреса ссылок в порядке смены.
+	history             map[string][]models.URLChange
 	lastGeneratedUserID int
+	// deleteQueue — очередь на удаление по идентификатору задачи.
+	deleteQueue      map[int64]models.DeleteTask
+	lastDeleteTaskID int64
+	// apiKeys — API-ключи по идентификатору, apiKeyHashes — индекс хэш -> идентификатор.
+	apiKeys      map[int64]models.APIKey
+	apiKeyHashes map[string]int64
+	lastAPIKeyID int64
+	// accounts — учётные данные по ID пользователя, accountLogins — индекс имя или email -> ID.
+	accounts      map[int]models.Account
+	accountLogins map[string]int
+	// orgs — организации по идентификатору, orgMembers — роли участников организации по ID пользователя.
+	orgs       map[int64]models.Organization
+	orgMembers map[int64]map[int]string
+	lastOrgID  int64
+	// quotas — индивидуальные квоты по ID пользователя.
+	quotas map[int]models.UserQuota
 }
 
 var _ URLStorage = (*MemoryURLStorage)(nil)
 
+func NewMemoryURLStorage() *MemoryURLStorage {
+	return &MemoryURLStorage{
+		records:  make(map[string]*urlRecord),
+		userURLs: make(map[int]map[string]string),
+		orgURLs:  make(map[int64]map[string]string),
+		clicks:   make(map[string][]models.Click),
+		history:  make(map[string][]models.URLChange),
+
+		deleteQueue: make(map[int64]models.DeleteTask),
+
+		apiKeys:      make(map[int64]models.APIKey),
+		apiKeyHashes: make(map[string]int64),
+
+		accounts:      make(map[int]models.Account),
+		accountLogins: make(map[string]int),
+
+		orgs:       make(map[int64]models.Organization),
+		orgMembers: make(map[int64]map[int]string),
+
+		quotas: make(map[int]models.UserQuota),
+	}
+}
+
 func (s *MemoryURLStorage) GenerateUserID(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.lastGeneratedUserID++
 	return s.lastGeneratedUserID, nil
 }
 
-func (s *MemoryURLStorage) HasValue(_ context.Context, userID int, value string) (bool, string, error) {
-	for k, v := range s.urls[userID] {
-		if v == value {
-			return true, k, nil
-		}
-	}
-	return false, "", nil
+// nextUserID возвращает идентификатор, который выдаст следующий вызов GenerateUserID.
+func (s *MemoryURLStorage) nextUserID() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastGeneratedUserID + 1
 }
 
-func (s *MemoryURLStorage) AddURL(ctx context.Context, userID int, id string, url string) error {
-	if id == "" {
-		return errors.New("short URL can't be empty")
+// registerUserID учитывает уже выданный идентификатор пользователя.
+func (s *MemoryURLStorage) registerUserID(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if userID > s.lastGeneratedUserID {
+		s.lastGeneratedUserID = userID
 	}
-	yes, key, err := s.HasValue(ctx, userID, url)
-	if err != nil {
+}
+
+func (s *MemoryURLStorage) HasValue(_ context.Context, userID int, value string) (bool, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.userURLs[userID][value]
+	return ok, key, nil
+}
+
+func (s *MemoryURLStorage) AddURL(_ context.Context, userID int, url database.InsertURL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkURL(userID, url); err != nil {
 		return err
 	}
-	if yes {
-		return errors2.NewDuplicateURLError(key)
-	}
-	if s.urls[userID] == nil {
-		s.urls[userID] = make(map[string]string)
-	}
-	s.urls[userID][id] = url
+	s.addURL(userID, url)
 	return nil
 }
 
+// BatchAddURL добавляет все ссылки или ни одной, если хотя бы одна из них не прошла проверку.
 func (s *MemoryURLStorage) BatchAddURL(_ context.Context, userID int, urls []database.InsertURL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkURLs(userID, urls); err != nil {
+		return err
+	}
 	for _, url := range urls {
-		s.urls[userID][url.ShortURL] = url.OriginalURL
+		s.addURL(userID, url)
 	}
 	return nil
 }
 
 func (s *MemoryURLStorage) GetURL(_ context.Context, id string) (string, bool, error) {
-	for _, v := range s.urls {
-		for k, v := range v {
-			if k == id {
-				return v, true, nil
-			}
-		}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	r, ok := s.records[id]
+	if !ok {
+		return "", false, nil
+	}
+	if r.deletedAt != nil {
+		return "", false, errors2.ErrURLDeleted
+	}
+	if r.expiresAt != nil && !r.expiresAt.After(time.Now()) {
+		return "", false, errors2.ErrURLExpired
 	}
-	return "", false, nil
+	return r.originalURL, true, nil
 }
 
 func (s *MemoryURLStorage) GetUserURLs(_ context.Context, userID int) (models.BatchUserURLs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.liveURLs(s.userURLs[userID]), nil
+}
+
+// liveURLs возвращает неудалённые ссылки из обратного индекса. Вызывается под блокировкой.
+func (s *MemoryURLStorage) liveURLs(index map[string]string) models.BatchUserURLs {
 	var urls models.BatchUserURLs
-	for k, v := range s.urls[userID] {
+	for originalURL, shortURL := range index {
+		if s.records[shortURL].deletedAt != nil {
+			continue
+		}
 		urls = append(urls, models.UserURL{
-			ShortURL:    k,
-			OriginalURL: v,
+			ShortURL:    shortURL,
+			OriginalURL: originalURL,
+			ExpiresAt:   s.records[shortURL].expiresAt,
 		})
 	}
-	return urls, nil
-
+	return urls
 }
 
 func (s *MemoryURLStorage) Ping(_ context.Context) error {
 	return errors.New("MemoryURLStorage doesn't support ping")
 }
 
+func (s *MemoryURLStorage) Close() error {
+	return nil
+}
+
 func (s *MemoryURLStorage) DeleteURL(_ context.Context, userID int, id string) error {
-	delete(s.urls[userID], id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[id]; ok && r.userID == userID {
+		s.deleteURL(id)
+	}
+	return nil
+}
+
+// BatchDeleteURLs помечает ссылки удалёнными, как это делает DBURLStorage.
+func (s *MemoryURLStorage) BatchDeleteURLs(_ context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
+	return s.softDeleteURLs(urls, time.Now()), nil
+}
+
+func (s *MemoryURLStorage) GetDeletedURLs(_ context.Context, userID int) ([]models.DeletedURL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.deletedURLs(s.userURLs[userID]), nil
+}
+
+// deletedURLs возвращает удалённые ссылки из обратного индекса, начиная с последних.
+// Вызывается под блокировкой.
+func (s *MemoryURLStorage) deletedURLs(index map[string]string) []models.DeletedURL {
+	var urls []models.DeletedURL
+	for originalURL, shortURL := range index {
+		if r := s.records[shortURL]; r.deletedAt != nil {
+			urls = append(urls, models.DeletedURL{ShortURL: shortURL, OriginalURL: originalURL, DeletedAt: *r.deletedAt})
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].DeletedAt.After(urls[j].DeletedAt) })
+	return urls
+}
+
+func (s *MemoryURLStorage) RestoreURLs(_ context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	return s.restoreURLs(urls, deletedAfter), nil
+}
+
+// restoreURLs снимает пометку об удалении со ссылок владельцев, удалённых не раньше deletedAfter.
+func (s *MemoryURLStorage) restoreURLs(urls []models.DeleteURL, deletedAfter time.Time) []models.DeleteURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var restored []models.DeleteURL
+	for _, url := range urls {
+		r, ok := s.records[url.ShortURL]
+		if ok && r.userID == url.UserID && r.deletedAt != nil && !r.deletedAt.Before(deletedAfter) {
+			r.deletedAt = nil
+			restored = append(restored, url)
+		}
+	}
+	return restored
+}
+
+func (s *MemoryURLStorage) PurgeDeletedURLs(_ context.Context, deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Поиск и удаление под одной блокировкой, иначе восстановленная между ними ссылка будет удалена
+	urls := s.deletedURLsBefore(deletedBefore)
+	s.removeURLs(urls)
+	return len(urls), nil
+}
+
+// deletedURLsBefore возвращает ссылки, удалённые раньше deletedBefore. Вызывается под блокировкой.
+func (s *MemoryURLStorage) deletedURLsBefore(deletedBefore time.Time) []string {
+	var urls []string
+	for id, r := range s.records {
+		if r.deletedAt != nil && r.deletedAt.Before(deletedBefore) {
+			urls = append(urls, id)
+		}
+	}
+	return urls
+}
+
+// purgeURLs удаляет ссылки вместе с их переходами и историей.
+func (s *MemoryURLStorage) purgeURLs(urls []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeURLs(urls)
+}
+
+// removeURLs удаляет ссылки вместе с их переходами и историей. Вызывается под блокировкой.
+func (s *MemoryURLStorage) removeURLs(urls []string) {
+	for _, id := range urls {
+		s.deleteURL(id)
+		delete(s.clicks, id)
+		delete(s.history, id)
+	}
+}
+
+// DeleteExpiredURLs помечает удалёнными ссылки с истёкшим сроком действия.
+func (s *MemoryURLStorage) DeleteExpiredURLs(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	urls := s.expiredURLs(now)
+	s.markURLsDeleted(urls, now)
+	return len(urls), nil
+}
+
+// expiredURLs возвращает ещё не удалённые ссылки, срок действия которых истёк к моменту now.
+// Вызывается под блокировкой.
+func (s *MemoryURLStorage) expiredURLs(now time.Time) []models.DeleteURL {
+	var urls []models.DeleteURL
+	for id, r := range s.records {
+		if r.deletedAt == nil && r.expiresAt != nil && !r.expiresAt.After(now) {
+			urls = append(urls, models.DeleteURL{UserID: r.userID, ShortURL: id})
+		}
+	}
+	return urls
+}
+
+// softDeleteURLs помечает удалёнными ссылки, владелец которых совпадает с указанным,
+// и возвращает их. У ранее удалённых ссылок время удаления не меняется.
+func (s *MemoryURLStorage) softDeleteURLs(urls []models.DeleteURL, deletedAt time.Time) []models.DeleteURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.markURLsDeleted(urls, deletedAt)
+}
+
+// markURLsDeleted — softDeleteURLs без блокировки. Вызывается под блокировкой.
+func (s *MemoryURLStorage) markURLsDeleted(urls []models.DeleteURL, deletedAt time.Time) []models.DeleteURL {
+	var deleted []models.DeleteURL
+	for _, url := range urls {
+		if r, ok := s.records[url.ShortURL]; ok && r.userID == url.UserID {
+			if r.deletedAt == nil {
+				t := deletedAt
+				r.deletedAt = &t
+			}
+			deleted = append(deleted, url)
+		}
+	}
+	return deleted
+}
+
+// softDeleteAnyURLs помечает ссылки удалёнными без проверки владельца.
+// Нужна только для воспроизведения журнала старого формата.
+func (s *MemoryURLStorage) softDeleteAnyURLs(urls []string, deletedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, url := range urls {
+		if r, ok := s.records[url]; ok {
+			t := deletedAt
+			r.deletedAt = &t
+		}
+	}
+}
+
+func (s *MemoryURLStorage) AddClicks(_ context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, click := range clicks {
+		s.clicks[click.ShortURL] = append(s.clicks[click.ShortURL], click)
+	}
+	return nil
+}
+
+func (s *MemoryURLStorage) GetClicks(_ context.Context, id string, from, to time.Time) ([]models.Click, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var clicks []models.Click
+	for _, click := range s.clicks[id] {
+		if !click.ClickedAt.Before(from) && click.ClickedAt.Before(to) {
+			clicks = append(clicks, click)
+		}
+	}
+	return clicks, nil
+}
+
+func (s *MemoryURLStorage) GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	clicks, err := s.GetClicks(ctx, id, from, to)
+	if err != nil {
+		return models.ClickStats{}, err
+	}
+
+	stats := models.ClickStats{TotalClicks: len(clicks)}
+	visitors := make(map[string]struct{})
+	perDay := make(map[time.Time]int)
+	perHour := make(map[time.Time]int)
+	referrers := make(map[string]int)
+	userAgents := make(map[string]int)
+	for _, click := range clicks {
+		if click.IPHash != "" {
+			visitors[click.IPHash] = struct{}{}
+		}
+		if click.Referrer != "" {
+			referrers[click.Referrer]++
+		}
+		if click.UserAgent != "" {
+			userAgents[click.UserAgent]++
+		}
+		perDay[click.ClickedAt.UTC().Truncate(24*time.Hour)]++
+		perHour[click.ClickedAt.UTC().Truncate(time.Hour)]++
+	}
+	stats.UniqueVisitors = len(visitors)
+	stats.ClicksPerDay = timeBuckets(perDay)
+	stats.ClicksPerHour = timeBuckets(perHour)
+	stats.TopReferrers = topItems(referrers, top)
+	stats.TopUserAgents = topItems(userAgents, top)
+	return stats, nil
+}
+
+func timeBuckets(counts map[time.Time]int) []models.TimeBucket {
+	buckets := make([]models.TimeBucket, 0, len(counts))
+	for start, clicks := range counts {
+		buckets = append(buckets, models.TimeBucket{Start: start, Clicks: clicks})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
+
+// topItems возвращает limit самых частых значений; при равенстве — в алфавитном порядке, как ORDER BY в SQL.
+func topItems(counts map[string]int, limit int) []models.TopItem {
+	items := make([]models.TopItem, 0, len(counts))
+	for value, clicks := range counts {
+		items = append(items, models.TopItem{Value: value, Clicks: clicks})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Clicks != items[j].Clicks {
+			return items[i].Clicks > items[j].Clicks
+		}
+		return items[i].Value < items[j].Value
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (s *MemoryURLStorage) GetURLOwner(_ context.Context, id string) (models.URLOwner, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return models.URLOwner{}, false, nil
+	}
+	return models.URLOwner{UserID: r.userID, OrgID: r.orgID}, true, nil
+}
+
+func (s *MemoryURLStorage) UpdateURL(_ context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUpdateURL(id, originalURL); err != nil {
+		return err
+	}
+	s.updateURL(id, originalURL, changedBy, changedAt)
+	return nil
+}
+
+func (s *MemoryURLStorage) GetURLHistory(_ context.Context, id string) ([]models.URLChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]models.URLChange(nil), s.history[id]...), nil
+}
+
+// checkUpdateURL проверяет, что адрес ссылки можно заменить на originalURL. Вызывается под блокировкой.
+func (s *MemoryURLStorage) checkUpdateURL(id, originalURL string) error {
+	r, ok := s.records[id]
+	if !ok {
+		return errors2.ErrURLNotFound
+	}
+	if r.deletedAt != nil {
+		return errors2.ErrURLDeleted
+	}
+	if key, ok := s.ownerURLs(r.userID, r.orgID)[originalURL]; ok && key != id {
+		return errors2.NewDuplicateURLError(key)
+	}
 	return nil
 }
 
-func (s *MemoryURLStorage) BatchDeleteURLs(_ context.Context, urls []string) error {
+// updateURL меняет адрес ссылки в записи и обратном индексе и сохраняет прежний в истории.
+// Если адрес не меняется, ничего не делает. Вызывается под блокировкой.
+func (s *MemoryURLStorage) updateURL(id, originalURL string, changedBy int, changedAt time.Time) {
+	r, ok := s.records[id]
+	if !ok || r.originalURL == originalURL {
+		return
+	}
+	s.history[id] = append(s.history[id], models.URLChange{
+		ShortURL:    id,
+		PreviousURL: r.originalURL,
+		ChangedAt:   changedAt.UTC(),
+		ChangedBy:   changedBy,
+	})
+	index := s.ownerURLs(r.userID, r.orgID)
+	delete(index, r.originalURL)
+	index[originalURL] = id
+	r.originalURL = originalURL
+}
+
+// checkURLs проверяет, что пакет ссылок можно добавить целиком.
+func (s *MemoryURLStorage) checkURLs(userID int, urls []database.InsertURL) error {
+	seenURLs := make(map[string]struct{}, len(urls))
+	seenIDs := make(map[string]struct{}, len(urls))
 	for _, url := range urls {
-		for _, v := range s.urls {
-			delete(v, url)
+		if err := s.checkURL(userID, url); err != nil {
+			return err
+		}
+		if _, ok := seenURLs[url.OriginalURL]; ok {
+			return errors2.NewDuplicateURLError(url.ShortURL)
+		}
+		if _, ok := seenIDs[url.ShortURL]; ok {
+			return errors2.ErrShortURLTaken
+		}
+		seenURLs[url.OriginalURL] = struct{}{}
+		seenIDs[url.ShortURL] = struct{}{}
+	}
+	return nil
+}
+
+// canAddURLs проверяет пакет ссылок, не изменяя хранилище.
+func (s *MemoryURLStorage) canAddURLs(userID int, urls []database.InsertURL) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.checkURLs(userID, urls)
+}
+
+// checkURL проверяет, что ссылку можно добавить. Вызывается под блокировкой.
+func (s *MemoryURLStorage) checkURL(userID int, url database.InsertURL) error {
+	if url.ShortURL == "" {
+		return errors.New("short URL can't be empty")
+	}
+	if url.OrgID != 0 {
+		if _, ok := s.orgs[url.OrgID]; !ok {
+			return errors2.ErrOrgNotFound
+		}
+	}
+	if key, ok := s.ownerURLs(userID, url.OrgID)[url.OriginalURL]; ok {
+		return errors2.NewDuplicateURLError(key)
+	}
+	if _, ok := s.records[url.ShortURL]; ok {
+		return errors2.ErrShortURLTaken
+	}
+	return nil
+}
+
+// ownerURLs возвращает обратный индекс, в котором ищутся дубликаты: личных ссылок
+// пользователя или ссылок организации. Вызывается под блокировкой.
+func (s *MemoryURLStorage) ownerURLs(userID int, orgID int64) map[string]string {
+	if orgID != 0 {
+		return s.orgURLs[orgID]
+	}
+	return s.userURLs[userID]
+}
+
+// addURL добавляет ссылку в глобальный и обратный индексы. Вызывается под блокировкой.
+func (s *MemoryURLStorage) addURL(userID int, url database.InsertURL) {
+	s.records[url.ShortURL] = &urlRecord{userID: userID, orgID: url.OrgID, originalURL: url.OriginalURL, expiresAt: url.ExpiresAt}
+	if url.OrgID != 0 {
+		if s.orgURLs[url.OrgID] == nil {
+			s.orgURLs[url.OrgID] = make(map[string]string)
+		}
+		s.orgURLs[url.OrgID][url.OriginalURL] = url.ShortURL
+		return
+	}
+	if s.userURLs[userID] == nil {
+		s.userURLs[userID] = make(map[string]string)
+	}
+	s.userURLs[userID][url.OriginalURL] = url.ShortURL
+}
+
+// deleteURL удаляет ссылку из глобального и обратного индексов. Вызывается под блокировкой.
+func (s *MemoryURLStorage) deleteURL(id string) {
+	r, ok := s.records[id]
+	if !ok {
+		return
+	}
+	delete(s.records, id)
+	if r.orgID != 0 {
+		delete(s.orgURLs[r.orgID], r.originalURL)
+		if len(s.orgURLs[r.orgID]) == 0 {
+			delete(s.orgURLs, r.orgID)
+		}
+		return
+	}
+	delete(s.userURLs[r.userID], r.originalURL)
+	if len(s.userURLs[r.userID]) == 0 {
+		delete(s.userURLs, r.userID)
+	}
+}
+
+func (s *MemoryURLStorage) EnqueueDeletes(_ context.Context, tasks []models.DeleteTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Номера назначаются под той же блокировкой, что и вставка, иначе параллельные вызовы получат одинаковые
+	s.addDeleteTasks(s.numberDeleteTasks(tasks))
+	return nil
+}
+
+func (s *MemoryURLStorage) DueDeletes(_ context.Context, now time.Time, limit int) ([]models.DeleteTask, error) {
+	return s.deleteTasks(limit, func(task models.DeleteTask) bool {
+		return task.DeadAt == nil && !task.NextAttemptAt.After(now)
+	}), nil
+}
+
+func (s *MemoryURLStorage) UpdateDeleteTasks(_ context.Context, tasks []models.DeleteTask) error {
+	s.updateDeleteTasks(tasks)
+	return nil
+}
+
+func (s *MemoryURLStorage) FinishDeletes(_ context.Context, ids []int64) error {
+	s.finishDeleteTasks(ids)
+	return nil
+}
+
+func (s *MemoryURLStorage) DeadDeletes(_ context.Context) ([]models.DeleteTask, error) {
+	return s.deleteTasks(0, func(task models.DeleteTask) bool {
+		return task.DeadAt != nil
+	}), nil
+}
+
+func (s *MemoryURLStorage) ListDeletes(_ context.Context) ([]models.DeleteTask, error) {
+	return s.deleteTasks(0, func(task models.DeleteTask) bool {
+		return task.DeadAt == nil
+	}), nil
+}
+
+func (s *MemoryURLStorage) PendingDeletes(_ context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, task := range s.deleteQueue {
+		if task.DeadAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// numberDeleteTasks возвращает копию задач с назначенными идентификаторами, не добавляя их в очередь.
+// Вызывается под блокировкой.
+func (s *MemoryURLStorage) numberDeleteTasks(tasks []models.DeleteTask) []models.DeleteTask {
+	numbered := make([]models.DeleteTask, len(tasks))
+	for i, task := range tasks {
+		task.ID = s.lastDeleteTaskID + int64(i) + 1
+		numbered[i] = task
+	}
+	return numbered
+}
+
+// enqueueDeleteTasks добавляет в очередь задачи с уже назначенными идентификаторами.
+func (s *MemoryURLStorage) enqueueDeleteTasks(tasks []models.DeleteTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addDeleteTasks(tasks)
+}
+
+// addDeleteTasks добавляет задачи в очередь. Вызывается под блокировкой.
+func (s *MemoryURLStorage) addDeleteTasks(tasks []models.DeleteTask) {
+	for _, task := range tasks {
+		s.deleteQueue[task.ID] = task
+		if task.ID > s.lastDeleteTaskID {
+			s.lastDeleteTaskID = task.ID
+		}
+	}
+}
+
+func (s *MemoryURLStorage) updateDeleteTasks(tasks []models.DeleteTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, task := range tasks {
+		if _, ok := s.deleteQueue[task.ID]; ok {
+			s.deleteQueue[task.ID] = task
+		}
+	}
+}
+
+func (s *MemoryURLStorage) finishDeleteTasks(ids []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.deleteQueue, id)
+	}
+}
+
+// deleteTasks возвращает до limit задач, подходящих под match, в порядке добавления.
+// Нулевой limit означает все задачи.
+func (s *MemoryURLStorage) deleteTasks(limit int, match func(models.DeleteTask) bool) []models.DeleteTask {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tasks []models.DeleteTask
+	for _, task := range s.deleteQueue {
+		if match(task) {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	if limit > 0 && len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks
+}
+
+func (s *MemoryURLStorage) AddAPIKey(_ context.Context, key models.APIKey) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Проверка, номер и вставка под одной блокировкой, иначе параллельные вызовы получат один номер
+	key, err := s.numberAPIKey(key)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	s.insertAPIKey(key)
+	return key, nil
+}
+
+func (s *MemoryURLStorage) GetAPIKeys(_ context.Context, userID int) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []models.APIKey
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *MemoryURLStorage) GetAPIKeyByHash(_ context.Context, hash string) (models.APIKey, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.apiKeyHashes[hash]
+	if !ok {
+		return models.APIKey{}, false, nil
+	}
+	return s.apiKeys[id], true, nil
+}
+
+func (s *MemoryURLStorage) RevokeAPIKey(_ context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	return s.revokeAPIKey(userID, id, revokedAt), nil
+}
+
+// numberAPIKey возвращает ключ с назначенным идентификатором, не сохраняя его. Вызывается под блокировкой.
+func (s *MemoryURLStorage) numberAPIKey(key models.APIKey) (models.APIKey, error) {
+	if _, ok := s.apiKeyHashes[key.Hash]; ok {
+		return models.APIKey{}, errors.New("API key already exists")
+	}
+	key.ID = s.lastAPIKeyID + 1
+	return key, nil
+}
+
+// addAPIKey сохраняет ключ с уже назначенным идентификатором.
+func (s *MemoryURLStorage) addAPIKey(key models.APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertAPIKey(key)
+}
+
+// insertAPIKey сохраняет ключ. Вызывается под блокировкой.
+func (s *MemoryURLStorage) insertAPIKey(key models.APIKey) {
+	s.apiKeys[key.ID] = key
+	s.apiKeyHashes[key.Hash] = key.ID
+	if key.ID > s.lastAPIKeyID {
+		s.lastAPIKeyID = key.ID
+	}
+}
+
+// canRevokeAPIKey сообщает, есть ли у пользователя неотозванный ключ id.
+func (s *MemoryURLStorage) canRevokeAPIKey(userID int, id int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.apiKeys[id]
+	return ok && key.UserID == userID && key.RevokedAt == nil
+}
+
+func (s *MemoryURLStorage) revokeAPIKey(userID int, id int64, revokedAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return false
+	}
+	revokedAt = revokedAt.UTC()
+	key.RevokedAt = &revokedAt
+	s.apiKeys[id] = key
+	return true
+}
+
+func (s *MemoryURLStorage) RegisterUser(_ context.Context, account models.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkAccount(account); err != nil {
+		return err
+	}
+	s.addAccount(account)
+	return nil
+}
+
+func (s *MemoryURLStorage) GetAccount(_ context.Context, login string) (models.Account, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.accountLogins[login]
+	if !ok {
+		return models.Account{}, false, nil
+	}
+	return s.accounts[userID], true, nil
+}
+
+func (s *MemoryURLStorage) GetAccountByUserID(_ context.Context, userID int) (models.Account, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[userID]
+	return account, ok, nil
+}
+
+func (s *MemoryURLStorage) MergeUsers(_ context.Context, from, to int, mergedAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mergeUsers(from, to, mergedAt), nil
+}
+
+// checkAccount проверяет, что учётные данные можно привязать. Вызывается под блокировкой.
+func (s *MemoryURLStorage) checkAccount(account models.Account) error {
+	if _, ok := s.accounts[account.UserID]; ok {
+		return errors2.ErrAlreadyRegistered
+	}
+	if _, ok := s.accountLogins[account.Username]; ok {
+		return errors2.ErrAccountExists
+	}
+	if _, ok := s.accountLogins[account.Email]; ok && account.Email != "" {
+		return errors2.ErrAccountExists
+	}
+	return nil
+}
+
+// addAccount сохраняет учётные данные. Вызывается под блокировкой.
+func (s *MemoryURLStorage) addAccount(account models.Account) {
+	s.accounts[account.UserID] = account
+	s.accountLogins[account.Username] = account.UserID
+	if account.Email != "" {
+		s.accountLogins[account.Email] = account.UserID
+	}
+}
+
+// mergeUsers переносит ссылки from к to и отзывает API-ключи from. Вызывается под блокировкой.
+func (s *MemoryURLStorage) mergeUsers(from, to int, mergedAt time.Time) int {
+	if from == to {
+		return 0
+	}
+	merged := 0
+	for originalURL, shortURL := range s.userURLs[from] {
+		if _, ok := s.userURLs[to][originalURL]; ok {
+			continue
+		}
+		s.records[shortURL].userID = to
+		if s.userURLs[to] == nil {
+			s.userURLs[to] = make(map[string]string)
+		}
+		s.userURLs[to][originalURL] = shortURL
+		delete(s.userURLs[from], originalURL)
+		merged++
+	}
+	if len(s.userURLs[from]) == 0 {
+		delete(s.userURLs, from)
+	}
+	for id, key := range s.apiKeys {
+		if key.UserID == from && key.RevokedAt == nil {
+			revokedAt := mergedAt.UTC()
+			key.RevokedAt = &revokedAt
+			s.apiKeys[id] = key
+		}
+	}
+	return merged
+}
+
+func (s *MemoryURLStorage) CreateOrg(_ context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Номер и вставка под одной блокировкой, иначе параллельные вызовы получат один номер
+	org = s.numberOrg(org)
+	org.CreatedAt = org.CreatedAt.UTC()
+	s.insertOrg(org, ownerID)
+	return org, nil
+}
+
+func (s *MemoryURLStorage) GetUserOrgs(_ context.Context, userID int) ([]models.OrgMembership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orgs []models.OrgMembership
+	for id, members := range s.orgMembers {
+		if role, ok := members[userID]; ok {
+			orgs = append(orgs, models.OrgMembership{Organization: s.orgs[id], Role: role})
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].ID < orgs[j].ID })
+	return orgs, nil
+}
+
+func (s *MemoryURLStorage) GetOrgMembers(_ context.Context, orgID int64) ([]models.OrgMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var members []models.OrgMember
+	for userID, role := range s.orgMembers[orgID] {
+		members = append(members, models.OrgMember{OrgID: orgID, UserID: userID, Role: role})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members, nil
+}
+
+func (s *MemoryURLStorage) GetOrgRole(_ context.Context, orgID int64, userID int) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	role, ok := s.orgMembers[orgID][userID]
+	return role, ok, nil
+}
+
+func (s *MemoryURLStorage) SetOrgMember(_ context.Context, member models.OrgMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkSetOrgMember(member); err != nil {
+		return err
+	}
+	s.setOrgMember(member)
+	return nil
+}
+
+func (s *MemoryURLStorage) RemoveOrgMember(_ context.Context, orgID int64, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isLastOwner(orgID, userID) {
+		return false, errors2.ErrLastOwner
+	}
+	return s.removeOrgMember(orgID, userID), nil
+}
+
+func (s *MemoryURLStorage) GetOrgURLs(_ context.Context, orgID int64) (models.BatchUserURLs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.liveURLs(s.orgURLs[orgID]), nil
+}
+
+func (s *MemoryURLStorage) GetDeletedOrgURLs(_ context.Context, orgID int64) ([]models.DeletedURL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.deletedURLs(s.orgURLs[orgID]), nil
+}
+
+// numberOrg возвращает организацию с назначенным идентификатором, не сохраняя её. Вызывается под блокировкой.
+func (s *MemoryURLStorage) numberOrg(org models.Organization) models.Organization {
+	org.ID = s.lastOrgID + 1
+	return org
+}
+
+// addOrg сохраняет организацию с уже назначенным идентификатором.
+func (s *MemoryURLStorage) addOrg(org models.Organization, ownerID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertOrg(org, ownerID)
+}
+
+// insertOrg сохраняет организацию и её владельца. Вызывается под блокировкой.
+func (s *MemoryURLStorage) insertOrg(org models.Organization, ownerID int) {
+	org.CreatedAt = org.CreatedAt.UTC()
+	s.orgs[org.ID] = org
+	if org.ID > s.lastOrgID {
+		s.lastOrgID = org.ID
+	}
+	s.setOrgMember(models.OrgMember{OrgID: org.ID, UserID: ownerID, Role: models.RoleOwner})
+}
+
+// checkSetOrgMember проверяет, что организация существует и не останется без владельца.
+// Вызывается под блокировкой.
+func (s *MemoryURLStorage) checkSetOrgMember(member models.OrgMember) error {
+	if _, ok := s.orgs[member.OrgID]; !ok {
+		return errors2.ErrOrgNotFound
+	}
+	if member.Role != models.RoleOwner && s.isLastOwner(member.OrgID, member.UserID) {
+		return errors2.ErrLastOwner
+	}
+	return nil
+}
+
+// isLastOwner сообщает, что userID — единственный владелец организации. Вызывается под блокировкой.
+func (s *MemoryURLStorage) isLastOwner(orgID int64, userID int) bool {
+	if s.orgMembers[orgID][userID] != models.RoleOwner {
+		return false
+	}
+	for id, role := range s.orgMembers[orgID] {
+		if id != userID && role == models.RoleOwner {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrgMember сохраняет роль участника. Вызывается под блокировкой.
+func (s *MemoryURLStorage) setOrgMember(member models.OrgMember) {
+	if s.orgMembers[member.OrgID] == nil {
+		s.orgMembers[member.OrgID] = make(map[int]string)
+	}
+	s.orgMembers[member.OrgID][member.UserID] = member.Role
+}
+
+// removeOrgMember исключает участника. Вызывается под блокировкой.
+func (s *MemoryURLStorage) removeOrgMember(orgID int64, userID int) bool {
+	if _, ok := s.orgMembers[orgID][userID]; !ok {
+		return false
+	}
+	delete(s.orgMembers[orgID], userID)
+	return true
+}
+
+func (s *MemoryURLStorage) GetUserQuota(_ context.Context, userID int) (models.UserQuota, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return copyUserQuota(s.quotas[userID]), nil
+}
+
+func (s *MemoryURLStorage) SetUserQuota(_ context.Context, userID int, quota models.UserQuota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUser(userID); err != nil {
+		return err
+	}
+	s.setUserQuota(userID, quota)
+	return nil
+}
+
+func (s *MemoryURLStorage) CountUserURLs(_ context.Context, userID int, now time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, r := range s.records {
+		if r.userID == userID && r.deletedAt == nil && (r.expiresAt == nil || r.expiresAt.After(now)) {
+			n++
 		}
 	}
+	return n, nil
+}
+
+// checkUser проверяет, что пользователь создавался. Вызывается под блокировкой.
+func (s *MemoryURLStorage) checkUser(userID int) error {
+	if userID <= 0 || userID > s.lastGeneratedUserID {
+		return errors2.ErrUserNotFound
+	}
 	return nil
 }
+
+// setUserQuota сохраняет квоты пользователя; пустые квоты удаляются. Вызывается под блокировкой.
+func (s *MemoryURLStorage) setUserQuota(userID int, quota models.UserQuota) {
+	if quota.MaxLinks == nil && quota.MaxBatchSize == nil {
+		delete(s.quotas, userID)
+		return
+	}
+	s.quotas[userID] = copyUserQuota(quota)
+}
+
+// copyUserQuota копирует значения квот, чтобы вызывающий код не менял сохранённые.
+func copyUserQuota(quota models.UserQuota) models.UserQuota {
+	var c models.UserQuota
+	if quota.MaxLinks != nil {
+		maxLinks := *quota.MaxLinks
+		c.MaxLinks = &maxLinks
+	}
+	if quota.MaxBatchSize != nil {
+		maxBatchSize := *quota.MaxBatchSize
+		c.MaxBatchSize = &maxBatchSize
+	}
+	return c
+}
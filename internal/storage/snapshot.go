@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/database"
+	"github.com/vook88/go-url-shortener/internal/models"
+)
+
+// snapshot — состояние MemoryURLStorage на момент записи события журнала LastSeq.
+type snapshot struct {
+	LastSeq    int64              `json:"last_seq"`
+	LastUserID int                `json:"last_user_id"`
+	URLs       []snapshotURL      `json:"urls"`
+	Clicks     []models.Click     `json:"clicks,omitempty"`
+	History    []models.URLChange `json:"history,omitempty"`
+
+	DeleteQueue      []models.DeleteTask `json:"delete_queue,omitempty"`
+	LastDeleteTaskID int64               `json:"last_delete_task_id,omitempty"`
+
+	APIKeys      []models.APIKey `json:"api_keys,omitempty"`
+	LastAPIKeyID int64           `json:"last_api_key_id,omitempty"`
+
+	Accounts []models.Account `json:"accounts,omitempty"`
+
+	Orgs       []models.Organization `json:"orgs,omitempty"`
+	OrgMembers []models.OrgMember    `json:"org_members,omitempty"`
+	LastOrgID  int64                 `json:"last_org_id,omitempty"`
+
+	Quotas map[int]models.UserQuota `json:"quotas,omitempty"`
+}
+
+type snapshotURL struct {
+	UserID      int        `json:"user_id"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	OrgID       int64      `json:"org_id,omitempty"`
+}
+
+func (s *MemoryURLStorage) dump(lastSeq int64) *snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := &snapshot{
+		LastSeq:    lastSeq,
+		LastUserID: s.lastGeneratedUserID,
+		URLs:       make([]snapshotURL, 0, len(s.records)),
+
+		LastDeleteTaskID: s.lastDeleteTaskID,
+		LastAPIKeyID:     s.lastAPIKeyID,
+		LastOrgID:        s.lastOrgID,
+	}
+	for _, key := range s.apiKeys {
+		snap.APIKeys = append(snap.APIKeys, key)
+	}
+	for _, account := range s.accounts {
+		snap.Accounts = append(snap.Accounts, account)
+	}
+	if len(s.quotas) > 0 {
+		snap.Quotas = make(map[int]models.UserQuota, len(s.quotas))
+		for userID, quota := range s.quotas {
+			snap.Quotas[userID] = copyUserQuota(quota)
+		}
+	}
+	for id, org := range s.orgs {
+		snap.Orgs = append(snap.Orgs, org)
+		for userID, role := range s.orgMembers[id] {
+			snap.OrgMembers = append(snap.OrgMembers, models.OrgMember{OrgID: id, UserID: userID, Role: role})
+		}
+	}
+	for _, task := range s.deleteQueue {
+		snap.DeleteQueue = append(snap.DeleteQueue, task)
+	}
+	for _, clicks := range s.clicks {
+		snap.Clicks = append(snap.Clicks, clicks...)
+	}
+	for _, changes := range s.history {
+		snap.History = append(snap.History, changes...)
+	}
+	for id, r := range s.records {
+		snap.URLs = append(snap.URLs, snapshotURL{
+			UserID:      r.userID,
+			ShortURL:    id,
+			OriginalURL: r.originalURL,
+			DeletedAt:   r.deletedAt,
+			ExpiresAt:   r.expiresAt,
+			OrgID:       r.orgID,
+		})
+	}
+	return snap
+}
+
+func (s *MemoryURLStorage) restore(snap *snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastGeneratedUserID = snap.LastUserID
+	s.lastOrgID = snap.LastOrgID
+	for _, org := range snap.Orgs {
+		s.orgs[org.ID] = org
+	}
+	for _, member := range snap.OrgMembers {
+		s.setOrgMember(member)
+	}
+	for _, url := range snap.URLs {
+		s.addURL(url.UserID, database.InsertURL{ShortURL: url.ShortURL, OriginalURL: url.OriginalURL, ExpiresAt: url.ExpiresAt, OrgID: url.OrgID})
+		s.records[url.ShortURL].deletedAt = url.DeletedAt
+	}
+	for _, click := range snap.Clicks {
+		s.clicks[click.ShortURL] = append(s.clicks[click.ShortURL], click)
+	}
+	for _, change := range snap.History {
+		s.history[change.ShortURL] = append(s.history[change.ShortURL], change)
+	}
+	s.lastDeleteTaskID = snap.LastDeleteTaskID
+	for _, task := range snap.DeleteQueue {
+		s.deleteQueue[task.ID] = task
+	}
+	s.lastAPIKeyID = snap.LastAPIKeyID
+	for _, key := range snap.APIKeys {
+		s.apiKeys[key.ID] = key
+		s.apiKeyHashes[key.Hash] = key.ID
+	}
+	for _, account := range snap.Accounts {
+		s.addAccount(account)
+	}
+	for userID, quota := range snap.Quotas {
+		s.setUserQuota(userID, quota)
+	}
+}
+
+// readSnapshot читает снимок. Если снимка нет, возвращает nil без ошибки.
+func readSnapshot(path string) (*snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	snap := &snapshot{}
+	if err = json.NewDecoder(file).Decode(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// writeSnapshot записывает снимок во временный файл и атомарно подменяет им старый,
+// так что при падении на диске остаётся либо старый, либо новый снимок целиком.
+func writeSnapshot(path string, snap *snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir сбрасывает на диск запись каталога, чтобы переименование пережило сбой питания.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
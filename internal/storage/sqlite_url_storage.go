@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/database"
+	"github.com/vook88/go-url-shortener/internal/models"
+)
+
+var _ URLStorage = (*SQLiteURLStorage)(nil)
+
+type SQLiteURLStorage struct {
+	db *database.SQLiteDB
+}
+
+func (s *SQLiteURLStorage) AddURL(ctx context.Context, userID int, url database.InsertURL) error {
+	return s.db.AddURL(ctx, userID, url)
+}
+
+func (s *SQLiteURLStorage) BatchAddURL(ctx context.Context, userID int, urls []database.InsertURL) error {
+	return s.db.BatchAddURL(ctx, userID, urls)
+}
+
+func (s *SQLiteURLStorage) GetURL(ctx context.Context, id string) (string, bool, error) {
+	return s.db.GetURL(ctx, id)
+}
+
+func (s *SQLiteURLStorage) GetUserURLs(ctx context.Context, userID int) (models.BatchUserURLs, error) {
+	return s.db.GetUserURLs(ctx, userID)
+}
+
+func (s *SQLiteURLStorage) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+func (s *SQLiteURLStorage) GenerateUserID(ctx context.Context) (int, error) {
+	return s.db.AddUser(ctx)
+}
+
+func (s *SQLiteURLStorage) BatchDeleteURLs(ctx context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error) {
+	return s.db.BatchDeleteURLs(ctx, urls)
+}
+
+func (s *SQLiteURLStorage) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	return s.db.DeleteExpiredURLs(ctx)
+}
+
+func (s *SQLiteURLStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteURLStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	return s.db.AddClicks(ctx, clicks)
+}
+
+func (s *SQLiteURLStorage) GetClicks(ctx context.Context, id string, from, to time.Time) ([]models.Click, error) {
+	return s.db.GetClicks(ctx, id, from, to)
+}
+
+func (s *SQLiteURLStorage) GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error) {
+	return s.db.GetClickStats(ctx, id, from, to, top)
+}
+
+func (s *SQLiteURLStorage) GetURLOwner(ctx context.Context, id string) (models.URLOwner, bool, error) {
+	return s.db.GetURLOwner(ctx, id)
+}
+
+func (s *SQLiteURLStorage) UpdateURL(ctx context.Context, id, originalURL string, changedBy int, changedAt time.Time) error {
+	return s.db.UpdateURL(ctx, id, originalURL, changedBy, changedAt)
+}
+
+func (s *SQLiteURLStorage) GetURLHistory(ctx context.Context, id string) ([]models.URLChange, error) {
+	return s.db.GetURLHistory(ctx, id)
+}
+
+func (s *SQLiteURLStorage) EnqueueDeletes(ctx context.Context, tasks []models.DeleteTask) error {
+	return s.db.EnqueueDeletes(ctx, tasks)
+}
+
+func (s *SQLiteURLStorage) DueDeletes(ctx context.Context, now time.Time, limit int) ([]models.DeleteTask, error) {
+	return s.db.DueDeletes(ctx, now, limit)
+}
+
+func (s *SQLiteURLStorage) UpdateDeleteTasks(ctx context.Context, tasks []models.DeleteTask) error {
+	return s.db.UpdateDeleteTasks(ctx, tasks)
+}
+
+func (s *SQLiteURLStorage) FinishDeletes(ctx context.Context, ids []int64) error {
+	return s.db.FinishDeletes(ctx, ids)
+}
+
+func (s *SQLiteURLStorage) DeadDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	return s.db.DeadDeletes(ctx)
+}
+
+func (s *SQLiteURLStorage) ListDeletes(ctx context.Context) ([]models.DeleteTask, error) {
+	return s.db.ListDeletes(ctx)
+}
+
+func (s *SQLiteURLStorage) PendingDeletes(ctx context.Context) (int, error) {
+	return s.db.PendingDeletes(ctx)
+}
+
+func (s *SQLiteURLStorage) GetDeletedURLs(ctx context.Context, userID int) ([]models.DeletedURL, error) {
+	return s.db.GetDeletedURLs(ctx, userID)
+}
+
+func (s *SQLiteURLStorage) RestoreURLs(ctx context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error) {
+	return s.db.RestoreURLs(ctx, urls, deletedAfter)
+}
+
+func (s *SQLiteURLStorage) PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return s.db.PurgeDeletedURLs(ctx, deletedBefore)
+}
+
+func (s *SQLiteURLStorage) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	return s.db.AddAPIKey(ctx, key)
+}
+
+func (s *SQLiteURLStorage) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	return s.db.GetAPIKeys(ctx, userID)
+}
+
+func (s *SQLiteURLStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	return s.db.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *SQLiteURLStorage) RevokeAPIKey(ctx context.Context, userID int, id int64, revokedAt time.Time) (bool, error) {
+	return s.db.RevokeAPIKey(ctx, userID, id, revokedAt)
+}
+
+func (s *SQLiteURLStorage) RegisterUser(ctx context.Context, account models.Account) error {
+	return s.db.RegisterUser(ctx, account)
+}
+
+func (s *SQLiteURLStorage) GetAccount(ctx context.Context, login string) (models.Account, bool, error) {
+	return s.db.GetAccount(ctx, login)
+}
+
+func (s *SQLiteURLStorage) GetAccountByUserID(ctx context.Context, userID int) (models.Account, bool, error) {
+	return s.db.GetAccountByUserID(ctx, userID)
+}
+
+func (s *SQLiteURLStorage) MergeUsers(ctx context.Context, from, to int, mergedAt time.Time) (int, error) {
+	return s.db.MergeUsers(ctx, from, to, mergedAt)
+}
+
+func (s *SQLiteURLStorage) CreateOrg(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error) {
+	return s.db.CreateOrg(ctx, org, ownerID)
+}
+
+func (s *SQLiteURLStorage) GetUserOrgs(ctx context.Context, userID int) ([]models.OrgMembership, error) {
+	return s.db.GetUserOrgs(ctx, userID)
+}
+
+func (s *SQLiteURLStorage) GetOrgMembers(ctx context.Context, orgID int64) ([]models.OrgMember, error) {
+	return s.db.GetOrgMembers(ctx, orgID)
+}
+
+func (s *SQLiteURLStorage) GetOrgRole(ctx context.Context, orgID int64, userID int) (string, bool, error) {
+	return s.db.GetOrgRole(ctx, orgID, userID)
+}
+
+func (s *SQLiteURLStorage) SetOrgMember(ctx context.Context, member models.OrgMember) error {
+	return s.db.SetOrgMember(ctx, member)
+}
+
+func (s *SQLiteURLStorage) RemoveOrgMember(ctx context.Context, orgID int64, userID int) (bool, error) {
+	return s.db.RemoveOrgMember(ctx, orgID, userID)
+}
+
+func (s *SQLiteURLStorage) GetOrgURLs(ctx context.Context, orgID int64) (models.BatchUserURLs, error) {
+	return s.db.GetOrgURLs(ctx, orgID)
+}
+
+func (s *SQLiteURLStorage) GetDeletedOrgURLs(ctx context.Context, orgID int64) ([]models.DeletedURL, error) {
+	return s.db.GetDeletedOrgURLs(ctx, orgID)
+}
+
+func (s *SQLiteURLStorage) GetUserQuota(ctx context.Context, userID int) (models.UserQuota, error) {
+	return s.db.GetUserQuota(ctx, userID)
+}
+
+func (s *SQLiteURLStorage) SetUserQuota(ctx context.Context, userID int, quota models.UserQuota) error {
+	return s.db.SetUserQuota(ctx, userID, quota)
+}
+
+func (s *SQLiteURLStorage) CountUserURLs(ctx context.Context, userID int, now time.Time) (int, error) {
+	return s.db.CountUserURLs(ctx, userID, now)
+}
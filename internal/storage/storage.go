@@ -2,74 +2,314 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 
 	"github.com/vook88/go-url-shortener/internal/config"
 	"github.com/vook88/go-url-shortener/internal/database"
 	"github.com/vook88/go-url-shortener/internal/models"
 )
 
+// Event — запись журнала FileURLStorage. Набор заполненных полей зависит от Op.
 type Event struct {
-	UUID        uuid.UUID `json:"uuid"`
-	UserID      int       `json:"user_id"`
-	ShortURL    string    `json:"short_url"`
-	OriginalURL string    `json:"original_url"`
+	UUID        uuid.UUID  `json:"uuid"`
+	Seq         int64      `json:"seq,omitempty"`
+	Op          string     `json:"op,omitempty"`
+	UserID      int        `json:"user_id"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	URLs        []EventURL `json:"urls,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	// ShortURLs — окончательно удаляемые ссылки для purge и удаляемые без проверки
+	// владельца ссылки в soft_delete старого формата.
+	ShortURLs []string           `json:"short_urls,omitempty"`
+	Deletions []models.DeleteURL `json:"deletions,omitempty"`
+	// DeletedAt — время удаления для soft_delete и нижняя граница времени удаления для restore.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	Clicks  []models.Click      `json:"clicks,omitempty"`
+	Tasks   []models.DeleteTask `json:"tasks,omitempty"`
+	TaskIDs []int64             `json:"task_ids,omitempty"`
+	// APIKey — созданный ключ для api_key_create, идентификатор и время отзыва для api_key_revoke.
+	APIKey *models.APIKey `json:"api_key,omitempty"`
+	// Account — учётные данные для user_register.
+	Account *models.Account `json:"account,omitempty"`
+	// FromUserID — пользователь, данные которого user_merge переносит к UserID.
+	FromUserID int `json:"from_user_id,omitempty"`
+	// OrgID — организация ссылки для save, организация для org_member_set и org_member_remove.
+	OrgID int64 `json:"org_id,omitempty"`
+	// Org — созданная организация для org_create, владелец — UserID.
+	Org *models.Organization `json:"org,omitempty"`
+	// Role — роль участника UserID для org_member_set.
+	Role string `json:"role,omitempty"`
+	// ChangedAt — время смены адреса ссылки для url_update, сменивший адрес — UserID,
+	// и время отзыва API-ключей FromUserID для user_merge.
+	ChangedAt *time.Time `json:"changed_at,omitempty"`
+	// Quota — новые индивидуальные квоты пользователя UserID для user_quota_set.
+	Quota *models.UserQuota `json:"quota,omitempty"`
 }
 
 type URLStorage interface {
-	AddURL(ctx context.Context, userID int, id string, url string) error
+	AddURL(ctx context.Context, userID int, url database.InsertURL) error
 	BatchAddURL(ctx context.Context, userID int, insertURLs []database.InsertURL) error
 	GetURL(ctx context.Context, id string) (string, bool, error)
+	// GetUserURLs возвращает неудалённые личные ссылки пользователя.
 	GetUserURLs(ctx context.Context, userID int) (models.BatchUserURLs, error)
+	// GetDeletedURLs возвращает удалённые личные ссылки пользователя, начиная с последних.
+	GetDeletedURLs(ctx context.Context, userID int) ([]models.DeletedURL, error)
 	Ping(ctx context.Context) error
 	GenerateUserID(ctx context.Context) (int, error)
-	BatchDeleteURLs(ctx context.Context, urls []string) error
+	// BatchDeleteURLs помечает удалёнными ссылки, принадлежащие указанным пользователям,
+	// и возвращает те из них, что удалены. Чужие и несуществующие ссылки пропускаются.
+	BatchDeleteURLs(ctx context.Context, urls []models.DeleteURL) ([]models.DeleteURL, error)
+	// RestoreURLs снимает пометку об удалении со ссылок указанных пользователей,
+	// удалённых не раньше deletedAfter, и возвращает восстановленные.
+	RestoreURLs(ctx context.Context, urls []models.DeleteURL, deletedAfter time.Time) ([]models.DeleteURL, error)
+	// PurgeDeletedURLs окончательно удаляет ссылки, удалённые раньше deletedBefore,
+	// вместе с их переходами и историей и возвращает число удалённых ссылок.
+	PurgeDeletedURLs(ctx context.Context, deletedBefore time.Time) (int, error)
+	// DeleteExpiredURLs помечает удалёнными ссылки с истёкшим сроком действия и возвращает их число.
+	DeleteExpiredURLs(ctx context.Context) (int, error)
+	AddClicks(ctx context.Context, clicks []models.Click) error
+	// GetClicks возвращает переходы по ссылке за период [from, to).
+	GetClicks(ctx context.Context, id string, from, to time.Time) ([]models.Click, error)
+	// GetClickStats считает переходы по ссылке за период [from, to): всего, уникальных посетителей,
+	// по дням и часам UTC и top самых частых источников и браузеров.
+	GetClickStats(ctx context.Context, id string, from, to time.Time, top int) (models.ClickStats, error)
+	// GetURLOwner возвращает владельца ссылки, в том числе удалённой.
+	GetURLOwner(ctx context.Context, id string) (models.URLOwner, bool, error)
+	// UpdateURL меняет адрес неудалённой ссылки на originalURL и сохраняет прежний адрес в истории
+	// вместе с временем смены и пользователем changedBy. Возвращает ErrURLNotFound, ErrURLDeleted
+	// или DuplicateURLError, если у владельца ссылки уже есть другая ссылка на этот адрес.
+	// Если адрес не меняется, история не пополняется.
+	UpdateURL(ctx context.Context, id, originalURL string, changedBy int, changedAt time.Time) error
+	// GetURLHistory возвращает прежние адреса ссылки в порядке смены.
+	GetURLHistory(ctx context.Context, id string) ([]models.URLChange, error)
+	DeleteQueue
+	APIKeyStore
+	AccountStore
+	OrgStore
+	QuotaStore
+	// Close освобождает ресурсы хранилища: пул соединений или файл журнала.
+	Close() error
 }
 
-func New(ctx context.Context, config *config.Config) (URLStorage, error) {
-	if config.DatabaseDSN != "" {
-		db, _ := database.New(config.DatabaseDSN)
-		err := db.Ping(ctx)
-		err2 := db.RunMigrations()
-		if err == nil && err2 == nil {
-			return &DBURLStorage{db: db}, nil
-		}
+// DeleteQueue — очередь запросов на удаление, которая переживает перезапуск сервиса.
+type DeleteQueue interface {
+	// EnqueueDeletes добавляет задачи в очередь. Идентификаторы задач назначает хранилище.
+	EnqueueDeletes(ctx context.Context, tasks []models.DeleteTask) error
+	// DueDeletes возвращает до limit задач, время попытки которых наступило к моменту now,
+	// в порядке добавления. Задачи из списка недоставленных не возвращаются.
+	DueDeletes(ctx context.Context, now time.Time, limit int) ([]models.DeleteTask, error)
+	// UpdateDeleteTasks сохраняет число попыток, время следующей попытки, ошибку и DeadAt задач.
+	UpdateDeleteTasks(ctx context.Context, tasks []models.DeleteTask) error
+	// FinishDeletes убирает выполненные задачи из очереди.
+	FinishDeletes(ctx context.Context, ids []int64) error
+	// DeadDeletes возвращает список недоставленных задач, исчерпавших попытки.
+	DeadDeletes(ctx context.Context) ([]models.DeleteTask, error)
+	// ListDeletes возвращает задачи очереди, не считая недоставленных, в порядке добавления.
+	ListDeletes(ctx context.Context) ([]models.DeleteTask, error)
+	// PendingDeletes возвращает число задач в очереди, не считая недоставленных.
+	PendingDeletes(ctx context.Context) (int, error)
+}
+
+// APIKeyStore хранит API-ключи пользователей.
+type APIKeyStore interface {
+	// AddAPIKey сохраняет ключ и возвращает его с назначенным идентификатором.
+	AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error)
+	// GetAPIKeys возвращает ключи пользователя, в том числе отозванные, в порядке создания.
+	GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	// GetAPIKeyByHash возвращает ключ, в том числе отозванный, по хэшу.
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error)
+	// RevokeAPIKey отзывает ключ пользователя. Возвращает false, если у пользователя
+	// нет неотозванного ключа с таким идентификатором.
+	RevokeAPIKey(ctx context.Context, userID int, id int64, revokedAt time.Time) (bool, error)
+}
+
+// AccountStore хранит учётные данные зарегистрированных пользователей.
+type AccountStore interface {
+	// RegisterUser привязывает учётные данные к пользователю account.UserID. Возвращает
+	// ErrAccountExists, если имя или email заняты, и ErrAlreadyRegistered, если пользователь уже зарегистрирован.
+	RegisterUser(ctx context.Context, account models.Account) error
+	// GetAccount ищет аккаунт по имени пользователя или email в нижнем регистре.
+	GetAccount(ctx context.Context, login string) (models.Account, bool, error)
+	// GetAccountByUserID возвращает аккаунт пользователя; false — пользователь анонимный.
+	GetAccountByUserID(ctx context.Context, userID int) (models.Account, bool, error)
+	// MergeUsers переносит личные ссылки пользователя from к пользователю to и возвращает
+	// число перенесённых ссылок. Ссылки на адреса, которые уже есть у to, остаются у from.
+	// API-ключи from не переносятся, а отзываются временем mergedAt: ключ, выпущенный анонимной
+	// сессией, не должен получать доступ к аккаунту.
+	MergeUsers(ctx context.Context, from, to int, mergedAt time.Time) (int, error)
+}
+
+// OrgStore хранит организации и их участников. Ссылки организации добавляются через
+// AddURL и BatchAddURL с заполненным InsertURL.OrgID и остаются за создавшим их пользователем,
+// поэтому удаляются и восстанавливаются от его имени.
+type OrgStore interface {
+	// CreateOrg сохраняет организацию с владельцем ownerID и возвращает её с назначенным идентификатором.
+	CreateOrg(ctx context.Context, org models.Organization, ownerID int) (models.Organization, error)
+	// GetUserOrgs возвращает организации пользователя с его ролями в порядке создания.
+	GetUserOrgs(ctx context.Context, userID int) ([]models.OrgMembership, error)
+	// GetOrgMembers возвращает участников организации по возрастанию ID пользователя.
+	GetOrgMembers(ctx context.Context, orgID int64) ([]models.OrgMember, error)
+	// GetOrgRole возвращает роль пользователя в организации; false — он в ней не состоит.
+	GetOrgRole(ctx context.Context, orgID int64, userID int) (string, bool, error)
+	// SetOrgMember добавляет участника в существующую организацию или меняет его роль.
+	// Возвращает ErrLastOwner, если понижается последний владелец.
+	SetOrgMember(ctx context.Context, member models.OrgMember) error
+	// RemoveOrgMember исключает участника. Возвращает false, если он в организации не состоял,
+	// и ErrLastOwner, если это последний владелец.
+	RemoveOrgMember(ctx context.Context, orgID int64, userID int) (bool, error)
+	// GetOrgURLs возвращает неудалённые ссылки организации.
+	GetOrgURLs(ctx context.Context, orgID int64) (models.BatchUserURLs, error)
+	// GetDeletedOrgURLs возвращает удалённые ссылки организации, начиная с последних.
+	GetDeletedOrgURLs(ctx context.Context, orgID int64) ([]models.DeletedURL, error)
+}
+
+// QuotaStore хранит индивидуальные квоты пользователей и считает использование квот.
+type QuotaStore interface {
+	// GetUserQuota возвращает индивидуальные квоты пользователя; у неизвестного пользователя их нет.
+	GetUserQuota(ctx context.Context, userID int) (models.UserQuota, error)
+	// SetUserQuota заменяет индивидуальные квоты пользователя. Возвращает ErrUserNotFound,
+	// если такой пользователь не создавался.
+	SetUserQuota(ctx context.Context, userID int, quota models.UserQuota) error
+	// CountUserURLs возвращает число созданных пользователем ссылок, в том числе ссылок организаций,
+	// которые не удалены и не истекли к моменту now.
+	CountUserURLs(ctx context.Context, userID int, now time.Time) (int, error)
+}
+
+// Compactor реализуют хранилища, которым нужно периодически сжимать свои данные.
+type Compactor interface {
+	Compact(ctx context.Context) error
+}
+
+// Поддерживаемые значения STORAGE_BACKEND.
+const (
+	BackendMemory   = config.BackendMemory
+	BackendFile     = config.BackendFile
+	BackendPostgres = config.BackendPostgres
+	BackendSQLite   = config.BackendSQLite
+)
+
+// New открывает хранилище, выбранное в config.StorageBackend. Если бэкенд не задан,
+// он выводится из остальных настроек: DATABASE_DSN, затем FILE_STORAGE_PATH, иначе память.
+// Если выбранный бэкенд недоступен, по очереди пробуются бэкенды из config.StorageFallback;
+// без них New возвращает ошибку, а не подменяет хранилище молча.
+func New(ctx context.Context, config *config.Config, log zerolog.Logger) (URLStorage, error) {
+	backend := config.StorageBackend
+	if backend == "" {
+		backend = defaultBackend(config)
 	}
-	urls := make(map[int]map[string]string)
 
-	if config.FileStoragePath == "" {
+	var errs []error
+	for i, name := range append([]string{backend}, config.StorageFallback...) {
+		s, err := open(ctx, name, config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("storage backend %q is unavailable: %w", name, err))
+			continue
+		}
+		if i > 0 {
+			log.Warn().
+				Err(errors.Join(errs...)).
+				Str("backend", name).
+				Msgf("STORAGE FALLBACK: primary storage backend %q is unavailable, using %q instead", backend, name)
+		}
+		log.Info().Str("backend", name).Msg("storage backend selected")
+		return s, nil
+	}
+	return nil, errors.Join(errs...)
+}
 
-		return &MemoryURLStorage{urls: urls, lastGeneratedUserID: 0}, nil
+// Backend возвращает название бэкенда хранилища s; для других реализаций URLStorage — пустую строку.
+func Backend(s URLStorage) string {
+	switch s.(type) {
+	case *MemoryURLStorage:
+		return BackendMemory
+	case *FileURLStorage:
+		return BackendFile
+	case *DBURLStorage:
+		return BackendPostgres
+	case *SQLiteURLStorage:
+		return BackendSQLite
+	default:
+		return ""
 	}
+}
 
-	file, err := os.OpenFile(config.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, err
+func defaultBackend(config *config.Config) string {
+	switch {
+	case strings.HasPrefix(config.DatabaseDSN, database.SQLiteScheme):
+		return BackendSQLite
+	case config.DatabaseDSN != "":
+		return BackendPostgres
+	case config.FileStoragePath != "":
+		return BackendFile
+	default:
+		return BackendMemory
 	}
+}
 
-	defer file.Close()
-	lastGeneratedUserID := 0
-	for {
-		event := &Event{}
-		err2 := json.NewDecoder(file).Decode(event)
-		if err2 != nil {
-			if err2.Error() == "EOF" {
-				break
-			}
-
-			return nil, err2
+func open(ctx context.Context, backend string, config *config.Config) (URLStorage, error) {
+	switch backend {
+	case BackendMemory:
+		return NewMemoryURLStorage(), nil
+	case BackendFile:
+		if config.FileStoragePath == "" {
+			return nil, errors.New("FILE_STORAGE_PATH is not set")
 		}
-		if event.UserID > lastGeneratedUserID {
-			lastGeneratedUserID = event.UserID
+		return NewFileURLStorage(config.FileStoragePath)
+	case BackendPostgres:
+		if config.DatabaseDSN == "" || strings.HasPrefix(config.DatabaseDSN, database.SQLiteScheme) {
+			return nil, errors.New("DATABASE_DSN is not a postgres DSN")
 		}
-		urls[event.UserID][event.ShortURL] = event.OriginalURL
+		return newDBURLStorage(ctx, config.DatabaseDSN)
+	case BackendSQLite:
+		if config.DatabaseDSN == "" {
+			return nil, errors.New("DATABASE_DSN is not set")
+		}
+		return newSQLiteURLStorage(ctx, config.DatabaseDSN)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+func newDBURLStorage(ctx context.Context, dsn string) (*DBURLStorage, error) {
+	db, err := database.New(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
+	}
+	if err = db.RunMigrations(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot migrate database: %w", err)
 	}
+	return &DBURLStorage{db: db}, nil
+}
 
-	return &FileURLStorage{
-		filepath:         config.FileStoragePath,
-		MemoryURLStorage: &MemoryURLStorage{urls: urls, lastGeneratedUserID: lastGeneratedUserID},
-	}, nil
+func newSQLiteURLStorage(ctx context.Context, dsn string) (*SQLiteURLStorage, error) {
+	if !strings.HasPrefix(dsn, database.SQLiteScheme) {
+		dsn = database.SQLiteScheme + dsn
+	}
+	db, err := database.NewSQLite(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open sqlite database: %w", err)
+	}
+	if err = db.RunMigrations(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot migrate sqlite database: %w", err)
+	}
+	return &SQLiteURLStorage{db: db}, nil
 }
@@ -2,20 +2,29 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
 
 	"github.com/vook88/go-url-shortener/internal/config"
 	"github.com/vook88/go-url-shortener/internal/contextkeys"
+	"github.com/vook88/go-url-shortener/internal/database"
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/models"
 )
 
 func TestMemoryURLStorage(t *testing.T) {
 	ctx := context.WithValue(context.Background(), contextkeys.UserIDKey, 1)
 	// Создаем инстанс MemoryURLStorage
-	storage := &MemoryURLStorage{urls: make(map[int]map[string]string)}
+	storage := NewMemoryURLStorage()
 
 	// Тестируем добавление URL
-	err := storage.AddURL(ctx, 5, "test1", "http://example.com/test1")
+	err := storage.AddURL(ctx, 5, database.InsertURL{ShortURL: "test1", OriginalURL: "http://example.com/test1"})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -51,13 +60,13 @@ func TestFileURLStorage(t *testing.T) {
 	defer os.Remove(tmpfile)
 
 	// Создаем инстанс FileURLStorage для тестирования
-	storage, err := New(ctx, &c)
+	storage, err := New(ctx, &c, zerolog.Nop())
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
 
 	// Тестируем добавление URL
-	err = storage.AddURL(ctx, 5, "test2", "http://example.com/test2")
+	err = storage.AddURL(ctx, 5, database.InsertURL{ShortURL: "test2", OriginalURL: "http://example.com/test2"})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -72,3 +81,380 @@ func TestFileURLStorage(t *testing.T) {
 		t.Errorf("Expected URL 'http://example.com/test2', got '%s'", url)
 	}
 }
+
+func TestMemoryURLStorageDuplicates(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryURLStorage()
+
+	if err := storage.AddURL(ctx, 1, database.InsertURL{ShortURL: "dup1", OriginalURL: "http://example.com/dup"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Тот же URL у того же пользователя — дубликат
+	ok, key, err := storage.HasValue(ctx, 1, "http://example.com/dup")
+	if err != nil || !ok || key != "dup1" {
+		t.Errorf("Expected duplicate 'dup1', got %v %q %v", ok, key, err)
+	}
+	if err = storage.AddURL(ctx, 1, database.InsertURL{ShortURL: "dup2", OriginalURL: "http://example.com/dup"}); err == nil || err.Error() != "dup1" {
+		t.Errorf("Expected duplicate error 'dup1', got %v", err)
+	}
+
+	// У другого пользователя тот же URL допустим
+	if err = storage.AddURL(ctx, 2, database.InsertURL{ShortURL: "dup3", OriginalURL: "http://example.com/dup"}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// Пакет с дубликатом не добавляется целиком
+	err = storage.BatchAddURL(ctx, 3, []database.InsertURL{
+		{ShortURL: "b1", OriginalURL: "http://example.com/b"},
+		{ShortURL: "b2", OriginalURL: "http://example.com/b"},
+	})
+	if err == nil {
+		t.Errorf("Expected duplicate error, got nil")
+	}
+	if _, ok, _ = storage.GetURL(ctx, "b1"); ok {
+		t.Errorf("Expected batch to be rejected, but 'b1' is present")
+	}
+}
+
+func TestMemoryURLStorageConcurrent(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryURLStorage()
+
+	const workers = 16
+	const perWorker = 100
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID, err := storage.GenerateUserID(ctx)
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+				return
+			}
+			var toDelete []models.DeleteURL
+			for i := 0; i < perWorker; i++ {
+				id := fmt.Sprintf("w%d-%d", w, i)
+				if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: id, OriginalURL: "http://example.com/" + id}); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				if _, ok, _ := storage.GetURL(ctx, id); !ok {
+					t.Errorf("Expected URL %q to be present", id)
+				}
+				if _, err = storage.GetUserURLs(ctx, userID); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				if i%2 == 0 {
+					toDelete = append(toDelete, models.DeleteURL{UserID: userID, ShortURL: id})
+				}
+			}
+			if _, err = storage.BatchDeleteURLs(ctx, toDelete); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < perWorker; i++ {
+			id := fmt.Sprintf("w%d-%d", w, i)
+			_, ok, _ := storage.GetURL(ctx, id)
+			if ok == (i%2 == 0) {
+				t.Errorf("Unexpected presence %v of URL %q", ok, id)
+			}
+		}
+	}
+	if storage.lastGeneratedUserID != workers {
+		t.Errorf("Expected %d generated users, got %d", workers, storage.lastGeneratedUserID)
+	}
+}
+
+func TestFileURLStorageRestart(t *testing.T) {
+	ctx := context.Background()
+	tmpfile := t.TempDir() + "/urls.json"
+
+	storage, err := NewFileURLStorage(tmpfile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	userID, _ := storage.GenerateUserID(ctx)
+	emptyUserID, _ := storage.GenerateUserID(ctx)
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "single", OriginalURL: "http://example.com/single"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = storage.BatchAddURL(ctx, userID, []database.InsertURL{
+		{ShortURL: "batch1", OriginalURL: "http://example.com/batch1"},
+		{ShortURL: "batch2", OriginalURL: "http://example.com/batch2"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err = storage.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: "batch2"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.DeleteURL(ctx, userID, "single"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.AddClicks(ctx, []models.Click{{ShortURL: "batch1", ClickedAt: time.Now().UTC()}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.EnqueueDeletes(ctx, []models.DeleteTask{{JobID: "job", UserID: userID, ShortURL: "batch1", NextAttemptAt: time.Now().UTC()}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	apiKey, err := storage.AddAPIKey(ctx, models.APIKey{UserID: userID, Hash: "hash", CreatedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err = storage.RevokeAPIKey(ctx, userID, apiKey.ID, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.RegisterUser(ctx, models.Account{UserID: userID, Username: "user", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err = storage.MergeUsers(ctx, emptyUserID, userID, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	org, err := storage.CreateOrg(ctx, models.Organization{Name: "Acme", CreatedAt: time.Now()}, userID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID, UserID: emptyUserID, Role: models.RoleViewer}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "org", OriginalURL: "http://example.com/batch1", OrgID: org.ID}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.UpdateURL(ctx, "org", "http://example.com/org", emptyUserID, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	maxLinks := 5
+	if err = storage.SetUserQuota(ctx, userID, models.UserQuota{MaxLinks: &maxLinks}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	deletedAt := *storage.records["batch2"].deletedAt
+	storage.Close()
+
+	// Перезапускаем хранилище и проверяем, что состояние совпадает
+	restored, err := NewFileURLStorage(tmpfile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+
+	if url, ok, err := restored.GetURL(ctx, "batch1"); err != nil || !ok || url != "http://example.com/batch1" {
+		t.Errorf("Expected 'batch1' to survive restart, got %q %v %v", url, ok, err)
+	}
+	if _, _, err = restored.GetURL(ctx, "batch2"); !errors.Is(err, errors2.ErrURLDeleted) {
+		t.Errorf("Expected ErrURLDeleted for 'batch2', got %v", err)
+	}
+	if got := restored.records["batch2"].deletedAt; got == nil || !got.Equal(deletedAt) {
+		t.Errorf("Expected deleted_at %v, got %v", deletedAt, got)
+	}
+	if _, ok, err := restored.GetURL(ctx, "single"); err != nil || ok {
+		t.Errorf("Expected 'single' to be removed, got %v %v", ok, err)
+	}
+	if len(restored.clicks["batch1"]) != 1 {
+		t.Errorf("Expected 1 click on 'batch1', got %d", len(restored.clicks["batch1"]))
+	}
+	if tasks, err := restored.DueDeletes(ctx, time.Now(), 10); err != nil || len(tasks) != 1 || tasks[0].ShortURL != "batch1" {
+		t.Errorf("Expected queued deletion of 'batch1' to survive restart, got %v %v", tasks, err)
+	}
+	if key, ok, err := restored.GetAPIKeyByHash(ctx, "hash"); err != nil || !ok || key.UserID != userID || key.RevokedAt == nil {
+		t.Errorf("Expected revoked API key to survive restart, got %+v %v %v", key, ok, err)
+	}
+	if account, ok, err := restored.GetAccount(ctx, "user"); err != nil || !ok || account.UserID != userID {
+		t.Errorf("Expected account to survive restart, got %+v %v %v", account, ok, err)
+	}
+	if role, ok, err := restored.GetOrgRole(ctx, org.ID, emptyUserID); err != nil || !ok || role != models.RoleViewer {
+		t.Errorf("Expected org member to survive restart, got %q %v %v", role, ok, err)
+	}
+	if urls, err := restored.GetOrgURLs(ctx, org.ID); err != nil || len(urls) != 1 || urls[0].ShortURL != "org" || urls[0].OriginalURL != "http://example.com/org" {
+		t.Errorf("Expected updated org URL to survive restart, got %v %v", urls, err)
+	}
+	if history, err := restored.GetURLHistory(ctx, "org"); err != nil || len(history) != 1 || history[0].PreviousURL != "http://example.com/batch1" {
+		t.Errorf("Expected URL history to survive restart, got %v %v", history, err)
+	}
+	if quota, err := restored.GetUserQuota(ctx, userID); err != nil || quota.MaxLinks == nil || *quota.MaxLinks != 5 || quota.MaxBatchSize != nil {
+		t.Errorf("Expected user quota to survive restart, got %+v %v", quota, err)
+	}
+	if next, _ := restored.GenerateUserID(ctx); next != emptyUserID+1 {
+		t.Errorf("Expected next user ID %d, got %d", emptyUserID+1, next)
+	}
+}
+
+func TestFileURLStorageCompact(t *testing.T) {
+	ctx := context.Background()
+	tmpfile := t.TempDir() + "/urls.json"
+
+	storage, err := NewFileURLStorage(tmpfile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	userID, _ := storage.GenerateUserID(ctx)
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "before", OriginalURL: "http://example.com/before"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err = storage.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: "before"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	org, err := storage.CreateOrg(ctx, models.Organization{Name: "Acme", CreatedAt: time.Now()}, userID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "org", OriginalURL: "http://example.com/before", OrgID: org.ID}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.UpdateURL(ctx, "org", "http://example.com/org", userID, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	maxBatchSize := 10
+	if err = storage.SetUserQuota(ctx, userID, models.UserQuota{MaxBatchSize: &maxBatchSize}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.Compact(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, _ := os.Stat(tmpfile); info.Size() != 0 {
+		t.Errorf("Expected log to be truncated, got %d bytes", info.Size())
+	}
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "after", OriginalURL: "http://example.com/after"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.Close()
+
+	restored, err := NewFileURLStorage(tmpfile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+
+	if _, _, err = restored.GetURL(ctx, "before"); !errors.Is(err, errors2.ErrURLDeleted) {
+		t.Errorf("Expected ErrURLDeleted for 'before', got %v", err)
+	}
+	if _, ok, _ := restored.GetURL(ctx, "after"); !ok {
+		t.Errorf("Expected 'after' to be replayed from the log tail")
+	}
+	if owner, _, err := restored.GetURLOwner(ctx, "org"); err != nil || owner.OrgID != org.ID {
+		t.Errorf("Expected org URL to be restored from the snapshot, got %+v %v", owner, err)
+	}
+	if role, _, err := restored.GetOrgRole(ctx, org.ID, userID); err != nil || role != models.RoleOwner {
+		t.Errorf("Expected org owner to be restored from the snapshot, got %q %v", role, err)
+	}
+	if history, err := restored.GetURLHistory(ctx, "org"); err != nil || len(history) != 1 || history[0].PreviousURL != "http://example.com/before" {
+		t.Errorf("Expected URL history to be restored from the snapshot, got %v %v", history, err)
+	}
+	if quota, err := restored.GetUserQuota(ctx, userID); err != nil || quota.MaxBatchSize == nil || *quota.MaxBatchSize != 10 {
+		t.Errorf("Expected user quota to be restored from the snapshot, got %+v %v", quota, err)
+	}
+	if restored.pending != 1 {
+		t.Errorf("Expected 1 pending log record, got %d", restored.pending)
+	}
+}
+
+func TestFileURLStorageCompactCrash(t *testing.T) {
+	ctx := context.Background()
+	tmpfile := t.TempDir() + "/urls.json"
+
+	storage, err := NewFileURLStorage(tmpfile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	userID, _ := storage.GenerateUserID(ctx)
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "first", OriginalURL: "http://example.com/first"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Снимок записан, но журнал не очищен — как при падении посреди Compact
+	if err = writeSnapshot(snapshotPath(tmpfile), storage.dump(storage.lastSeq)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "second", OriginalURL: "http://example.com/second"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage.Close()
+
+	restored, err := NewFileURLStorage(tmpfile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer restored.Close()
+
+	urls, _ := restored.GetUserURLs(ctx, userID)
+	if len(urls) != 2 {
+		t.Errorf("Expected 2 URLs after restart, got %d", len(urls))
+	}
+	if restored.pending != 1 {
+		t.Errorf("Expected only records after the snapshot to be replayed, got %d", restored.pending)
+	}
+}
+
+func TestSQLiteURLStorage(t *testing.T) {
+	ctx := context.Background()
+	c := config.Config{DatabaseDSN: database.SQLiteScheme + t.TempDir() + "/shortener.db"}
+
+	storage, err := New(ctx, &c, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := storage.(*SQLiteURLStorage); !ok {
+		t.Fatalf("Expected SQLiteURLStorage, got %T", storage)
+	}
+
+	userID, err := storage.GenerateUserID(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "sqlite1", OriginalURL: "http://example.com/sqlite"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var dupErr *errors2.DuplicateURLError
+	err = storage.AddURL(ctx, userID, database.InsertURL{ShortURL: "sqlite2", OriginalURL: "http://example.com/sqlite"})
+	if !errors.As(err, &dupErr) || err.Error() != "sqlite1" {
+		t.Errorf("Expected duplicate error 'sqlite1', got %v", err)
+	}
+
+	if _, err = storage.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: "sqlite1"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, _, err = storage.GetURL(ctx, "sqlite1"); !errors.Is(err, errors2.ErrURLDeleted) {
+		t.Errorf("Expected ErrURLDeleted, got %v", err)
+	}
+}
+
+func TestNewBackendSelection(t *testing.T) {
+	ctx := context.Background()
+	unavailableDSN := "postgres://postgres@127.0.0.1:1/shortener?connect_timeout=1"
+
+	t.Run("strict", func(t *testing.T) {
+		c := config.Config{DatabaseDSN: unavailableDSN, FileStoragePath: t.TempDir() + "/urls.json"}
+		if _, err := New(ctx, &c, zerolog.Nop()); err == nil {
+			t.Errorf("Expected error for unavailable postgres, got nil")
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		c := config.Config{
+			DatabaseDSN:     unavailableDSN,
+			StorageBackend:  BackendPostgres,
+			StorageFallback: []string{BackendFile, BackendMemory},
+		}
+		storage, err := New(ctx, &c, zerolog.Nop())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, ok := storage.(*MemoryURLStorage); !ok {
+			t.Errorf("Expected fallback to MemoryURLStorage, got %T", storage)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		c := config.Config{StorageBackend: "redis"}
+		if _, err := New(ctx, &c, zerolog.Nop()); err == nil {
+			t.Errorf("Expected error for unknown backend, got nil")
+		}
+	})
+}
@@ -0,0 +1,1158 @@
+// Package storagetest содержит общий набор проверок, которому должна
+// соответствовать любая реализация storage.URLStorage.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vook88/go-url-shortener/internal/database"
+	errors2 "github.com/vook88/go-url-shortener/internal/errors"
+	"github.com/vook88/go-url-shortener/internal/id"
+	"github.com/vook88/go-url-shortener/internal/models"
+	"github.com/vook88/go-url-shortener/internal/storage"
+)
+
+// Factory создаёт хранилище для одного подтеста. Хранилище может быть общим
+// между подтестами (например, одна база Postgres): проверки используют
+// случайные идентификаторы и не рассчитывают на пустое хранилище.
+type Factory func(t *testing.T) storage.URLStorage
+
+// Run прогоняет все проверки для хранилища, создаваемого newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.URLStorage)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"DuplicateURL", testDuplicateURL},
+		{"ShortURLTaken", testShortURLTaken},
+		{"URLDeleted", testURLDeleted},
+		{"DeleteForeignURL", testDeleteForeignURL},
+		{"URLExpired", testURLExpired},
+		{"GenerateUserIDMonotonic", testGenerateUserIDMonotonic},
+		{"UserURLs", testUserURLs},
+		{"BatchAddURL", testBatchAddURL},
+		{"BatchAddURLAtomic", testBatchAddURLAtomic},
+		{"AddClicks", testAddClicks},
+		{"ClickStats", testClickStats},
+		{"URLOwner", testURLOwner},
+		{"DeleteQueue", testDeleteQueue},
+		{"ConcurrentEnqueueDeletes", testConcurrentEnqueueDeletes},
+		{"TrashRestorePurge", testTrashRestorePurge},
+		{"ConcurrentRestorePurge", testConcurrentRestorePurge},
+		{"APIKeys", testAPIKeys},
+		{"ConcurrentAPIKeys", testConcurrentAPIKeys},
+		{"Accounts", testAccounts},
+		{"Orgs", testOrgs},
+		{"ConcurrentCreateOrg", testConcurrentCreateOrg},
+		{"ConcurrentLastOwner", testConcurrentLastOwner},
+		{"URLHistory", testURLHistory},
+		{"Quotas", testQuotas},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+// uniqueID возвращает короткий идентификатор, не пересекающийся с другими запусками.
+func uniqueID(t *testing.T) string {
+	t.Helper()
+	shortID, err := id.New()
+	if err != nil {
+		t.Fatalf("Cannot generate ID: %v", err)
+	}
+	return shortID
+}
+
+func newUser(t *testing.T, s storage.URLStorage) int {
+	t.Helper()
+	userID, err := s.GenerateUserID(context.Background())
+	if err != nil {
+		t.Fatalf("GenerateUserID: expected no error, got %v", err)
+	}
+	return userID
+}
+
+func testAddAndGet(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	shortID := uniqueID(t)
+	longURL := "http://example.com/" + shortID
+
+	if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: longURL}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	url, ok, err := s.GetURL(ctx, shortID)
+	if err != nil || !ok || url != longURL {
+		t.Errorf("GetURL: expected %q, got %q %v %v", longURL, url, ok, err)
+	}
+
+	url, ok, err = s.GetURL(ctx, uniqueID(t))
+	if err != nil || ok || url != "" {
+		t.Errorf("GetURL of unknown ID: expected not found, got %q %v %v", url, ok, err)
+	}
+}
+
+func testDuplicateURL(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	firstUser := newUser(t, s)
+	secondUser := newUser(t, s)
+	longURL := "http://example.com/" + uniqueID(t)
+
+	// Ссылка другого пользователя не должна влиять на поиск дубликата
+	secondID := uniqueID(t)
+	if err := s.AddURL(ctx, secondUser, database.InsertURL{ShortURL: secondID, OriginalURL: longURL}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	firstID := uniqueID(t)
+	if err := s.AddURL(ctx, firstUser, database.InsertURL{ShortURL: firstID, OriginalURL: longURL}); err != nil {
+		t.Fatalf("AddURL of the same URL by another user: expected no error, got %v", err)
+	}
+
+	err := s.AddURL(ctx, firstUser, database.InsertURL{ShortURL: uniqueID(t), OriginalURL: longURL})
+	var dupErr *errors2.DuplicateURLError
+	if !errors.As(err, &dupErr) {
+		t.Fatalf("AddURL of duplicate: expected DuplicateURLError, got %v", err)
+	}
+	if dupErr.Error() != firstID {
+		t.Errorf("DuplicateURLError: expected existing ID %q, got %q", firstID, dupErr.Error())
+	}
+}
+
+func testShortURLTaken(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	firstUser := newUser(t, s)
+	secondUser := newUser(t, s)
+	shortID := uniqueID(t)
+
+	if err := s.AddURL(ctx, firstUser, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/first"}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	err := s.AddURL(ctx, secondUser, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/second"})
+	if !errors.Is(err, errors2.ErrShortURLTaken) {
+		t.Errorf("AddURL with taken ID: expected ErrShortURLTaken, got %v", err)
+	}
+	err = s.BatchAddURL(ctx, secondUser, []database.InsertURL{{ShortURL: shortID, OriginalURL: "http://example.com/second"}})
+	if !errors.Is(err, errors2.ErrShortURLTaken) {
+		t.Errorf("BatchAddURL with taken ID: expected ErrShortURLTaken, got %v", err)
+	}
+	if url, _, _ := s.GetURL(ctx, shortID); url != "http://example.com/first" {
+		t.Errorf("GetURL: expected the first URL to be kept, got %q", url)
+	}
+}
+
+func testURLDeleted(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	deletedID := uniqueID(t)
+	keptID := uniqueID(t)
+
+	for _, shortID := range []string{deletedID, keptID} {
+		if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID}); err != nil {
+			t.Fatalf("AddURL: expected no error, got %v", err)
+		}
+	}
+	deleted, err := s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: deletedID}, {UserID: userID, ShortURL: uniqueID(t)}})
+	if err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ShortURL != deletedID || deleted[0].UserID != userID {
+		t.Errorf("BatchDeleteURLs: expected only %q deleted, got %v", deletedID, deleted)
+	}
+
+	if _, ok, err := s.GetURL(ctx, deletedID); !errors.Is(err, errors2.ErrURLDeleted) || ok {
+		t.Errorf("GetURL of deleted URL: expected ErrURLDeleted, got %v %v", ok, err)
+	}
+	if _, ok, err := s.GetURL(ctx, keptID); err != nil || !ok {
+		t.Errorf("GetURL of kept URL: expected found, got %v %v", ok, err)
+	}
+	if _, err := s.BatchDeleteURLs(ctx, nil); err != nil {
+		t.Errorf("BatchDeleteURLs of empty list: expected no error, got %v", err)
+	}
+}
+
+func testURLExpired(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	past := time.Now().Add(-time.Minute).UTC()
+	future := time.Now().Add(time.Hour).UTC()
+	expiredID := uniqueID(t)
+	liveID := uniqueID(t)
+
+	err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: expiredID, OriginalURL: "http://example.com/" + expiredID, ExpiresAt: &past})
+	if err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	err = s.BatchAddURL(ctx, userID, []database.InsertURL{{ShortURL: liveID, OriginalURL: "http://example.com/" + liveID, ExpiresAt: &future}})
+	if err != nil {
+		t.Fatalf("BatchAddURL: expected no error, got %v", err)
+	}
+
+	if _, ok, err := s.GetURL(ctx, expiredID); !errors.Is(err, errors2.ErrURLExpired) || ok {
+		t.Errorf("GetURL of expired URL: expected ErrURLExpired, got %v %v", ok, err)
+	}
+	if _, ok, err := s.GetURL(ctx, liveID); err != nil || !ok {
+		t.Errorf("GetURL of live URL: expected found, got %v %v", ok, err)
+	}
+
+	n, err := s.DeleteExpiredURLs(ctx)
+	if err != nil || n < 1 {
+		t.Fatalf("DeleteExpiredURLs: expected at least 1 deleted URL, got %d %v", n, err)
+	}
+	if _, _, err = s.GetURL(ctx, expiredID); !errors.Is(err, errors2.ErrURLDeleted) {
+		t.Errorf("GetURL of swept URL: expected ErrURLDeleted, got %v", err)
+	}
+	if _, ok, err := s.GetURL(ctx, liveID); err != nil || !ok {
+		t.Errorf("GetURL of live URL after sweep: expected found, got %v %v", ok, err)
+	}
+}
+
+func testGenerateUserIDMonotonic(t *testing.T, s storage.URLStorage) {
+	prev := newUser(t, s)
+	if prev <= 0 {
+		t.Fatalf("GenerateUserID: expected positive ID, got %d", prev)
+	}
+	for i := 0; i < 10; i++ {
+		next := newUser(t, s)
+		if next <= prev {
+			t.Fatalf("GenerateUserID: expected ID greater than %d, got %d", prev, next)
+		}
+		prev = next
+	}
+}
+
+func testUserURLs(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	otherID := newUser(t, s)
+
+	want := map[string]string{}
+	for i := 0; i < 3; i++ {
+		shortID := uniqueID(t)
+		want[shortID] = "http://example.com/" + shortID
+		if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: want[shortID]}); err != nil {
+			t.Fatalf("AddURL: expected no error, got %v", err)
+		}
+	}
+	otherShortID := uniqueID(t)
+	if err := s.AddURL(ctx, otherID, database.InsertURL{ShortURL: otherShortID, OriginalURL: "http://example.com/" + otherShortID}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+
+	urls, err := s.GetUserURLs(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserURLs: expected no error, got %v", err)
+	}
+	if len(urls) != len(want) {
+		t.Fatalf("GetUserURLs: expected %d URLs, got %d", len(want), len(urls))
+	}
+	for _, url := range urls {
+		if want[url.ShortURL] != url.OriginalURL {
+			t.Errorf("GetUserURLs: unexpected URL %q -> %q", url.ShortURL, url.OriginalURL)
+		}
+	}
+
+	urls, err = s.GetUserURLs(ctx, newUser(t, s))
+	if err != nil || len(urls) != 0 {
+		t.Errorf("GetUserURLs of new user: expected no URLs, got %v %v", urls, err)
+	}
+}
+
+func testBatchAddURL(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+
+	batch := make([]database.InsertURL, 0, 3)
+	for i := 0; i < 3; i++ {
+		shortID := uniqueID(t)
+		batch = append(batch, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID})
+	}
+	if err := s.BatchAddURL(ctx, userID, batch); err != nil {
+		t.Fatalf("BatchAddURL: expected no error, got %v", err)
+	}
+	for _, url := range batch {
+		got, ok, err := s.GetURL(ctx, url.ShortURL)
+		if err != nil || !ok || got != url.OriginalURL {
+			t.Errorf("GetURL: expected %q, got %q %v %v", url.OriginalURL, got, ok, err)
+		}
+	}
+}
+
+func testBatchAddURLAtomic(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+
+	existingID := uniqueID(t)
+	existingURL := "http://example.com/" + existingID
+	if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: existingID, OriginalURL: existingURL}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+
+	freshID := uniqueID(t)
+	batch := []database.InsertURL{
+		{ShortURL: freshID, OriginalURL: "http://example.com/" + freshID},
+		{ShortURL: uniqueID(t), OriginalURL: existingURL},
+	}
+	if err := s.BatchAddURL(ctx, userID, batch); err == nil {
+		t.Fatalf("BatchAddURL with duplicate: expected error, got nil")
+	}
+	if _, ok, err := s.GetURL(ctx, freshID); ok || err != nil {
+		t.Errorf("BatchAddURL with duplicate: expected no URLs to be added, got %v %v", ok, err)
+	}
+}
+
+func testAddClicks(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	shortID := uniqueID(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	clicks := []models.Click{
+		{ShortURL: shortID, ClickedAt: now.Add(-2 * time.Hour), Referrer: "https://ref.example.com", UserAgent: "test", IPHash: "abc"},
+		{ShortURL: shortID, ClickedAt: now.Add(-time.Hour)},
+		{ShortURL: uniqueID(t), ClickedAt: now.Add(-time.Hour)},
+	}
+	if err := s.AddClicks(ctx, clicks); err != nil {
+		t.Fatalf("AddClicks: expected no error, got %v", err)
+	}
+	if err := s.AddClicks(ctx, nil); err != nil {
+		t.Errorf("AddClicks of empty list: expected no error, got %v", err)
+	}
+
+	got, err := s.GetClicks(ctx, shortID, now.Add(-3*time.Hour), now)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("GetClicks: expected 2 clicks, got %d %v", len(got), err)
+	}
+	if !got[0].ClickedAt.Equal(clicks[0].ClickedAt) || got[0].Referrer != clicks[0].Referrer ||
+		got[0].UserAgent != clicks[0].UserAgent || got[0].IPHash != clicks[0].IPHash {
+		t.Errorf("GetClicks: expected %+v, got %+v", clicks[0], got[0])
+	}
+
+	got, err = s.GetClicks(ctx, shortID, now.Add(-90*time.Minute), now)
+	if err != nil || len(got) != 1 {
+		t.Errorf("GetClicks in range: expected 1 click, got %d %v", len(got), err)
+	}
+}
+
+func testClickStats(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	shortID := uniqueID(t)
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	clicks := []models.Click{
+		{ShortURL: shortID, ClickedAt: day.Add(23*time.Hour + 10*time.Minute), Referrer: "https://a.example.com", UserAgent: "x", IPHash: "h1"},
+		{ShortURL: shortID, ClickedAt: day.Add(23*time.Hour + 40*time.Minute), Referrer: "https://b.example.com", UserAgent: "x", IPHash: "h1"},
+		{ShortURL: shortID, ClickedAt: time.Date(2024, 3, 11, 3, 20, 0, 0, time.FixedZone("UTC+3", 3*60*60)), Referrer: "https://b.example.com", IPHash: "h2"},
+		{ShortURL: shortID, ClickedAt: day.Add(25 * time.Hour), UserAgent: "y"},
+		{ShortURL: shortID, ClickedAt: day.Add(29 * time.Hour), Referrer: "https://c.example.com"},
+		{ShortURL: uniqueID(t), ClickedAt: day.Add(23 * time.Hour), Referrer: "https://c.example.com"},
+	}
+	if err := s.AddClicks(ctx, clicks); err != nil {
+		t.Fatalf("AddClicks: expected no error, got %v", err)
+	}
+
+	from, to := day.Add(22*time.Hour), day.Add(26*time.Hour)
+	got, err := s.GetClickStats(ctx, shortID, from, to, 10)
+	if err != nil {
+		t.Fatalf("GetClickStats: expected no error, got %v", err)
+	}
+	if got.TotalClicks != 4 || got.UniqueVisitors != 2 {
+		t.Errorf("GetClickStats: expected 4 clicks from 2 visitors, got %d from %d", got.TotalClicks, got.UniqueVisitors)
+	}
+	wantDays := []models.TimeBucket{{Start: day, Clicks: 2}, {Start: day.Add(24 * time.Hour), Clicks: 2}}
+	if !equalBuckets(got.ClicksPerDay, wantDays) {
+		t.Errorf("GetClickStats per day: expected %v, got %v", wantDays, got.ClicksPerDay)
+	}
+	wantHours := []models.TimeBucket{{Start: day.Add(23 * time.Hour), Clicks: 2}, {Start: day.Add(24 * time.Hour), Clicks: 1}, {Start: day.Add(25 * time.Hour), Clicks: 1}}
+	if !equalBuckets(got.ClicksPerHour, wantHours) {
+		t.Errorf("GetClickStats per hour: expected %v, got %v", wantHours, got.ClicksPerHour)
+	}
+	wantReferrers := []models.TopItem{{Value: "https://b.example.com", Clicks: 2}, {Value: "https://a.example.com", Clicks: 1}}
+	if !reflect.DeepEqual(got.TopReferrers, wantReferrers) {
+		t.Errorf("GetClickStats referrers: expected %v, got %v", wantReferrers, got.TopReferrers)
+	}
+	wantUserAgents := []models.TopItem{{Value: "x", Clicks: 2}, {Value: "y", Clicks: 1}}
+	if !reflect.DeepEqual(got.TopUserAgents, wantUserAgents) {
+		t.Errorf("GetClickStats user agents: expected %v, got %v", wantUserAgents, got.TopUserAgents)
+	}
+
+	got, err = s.GetClickStats(ctx, shortID, from, to, 1)
+	if err != nil || len(got.TopReferrers) != 1 || got.TopReferrers[0] != wantReferrers[0] {
+		t.Errorf("GetClickStats with top 1: expected %v, got %v %v", wantReferrers[:1], got.TopReferrers, err)
+	}
+
+	got, err = s.GetClickStats(ctx, uniqueID(t), from, to, 10)
+	if err != nil || got.TotalClicks != 0 || len(got.ClicksPerDay) != 0 || len(got.TopReferrers) != 0 {
+		t.Errorf("GetClickStats of URL without clicks: expected empty stats, got %+v %v", got, err)
+	}
+}
+
+func equalBuckets(got, want []models.TimeBucket) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !got[i].Start.Equal(want[i].Start) || got[i].Clicks != want[i].Clicks {
+			return false
+		}
+	}
+	return true
+}
+
+func testDeleteForeignURL(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	ownerID := newUser(t, s)
+	otherID := newUser(t, s)
+	shortID := uniqueID(t)
+
+	if err := s.AddURL(ctx, ownerID, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	deleted, err := s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: otherID, ShortURL: shortID}})
+	if err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("BatchDeleteURLs of foreign URL: expected nothing deleted, got %v", deleted)
+	}
+	if _, ok, err := s.GetURL(ctx, shortID); err != nil || !ok {
+		t.Errorf("GetURL after foreign delete: expected found, got %v %v", ok, err)
+	}
+}
+
+func testURLOwner(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	shortID := uniqueID(t)
+
+	if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	if _, err := s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: shortID}}); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	owner, ok, err := s.GetURLOwner(ctx, shortID)
+	if err != nil || !ok || owner != (models.URLOwner{UserID: userID}) {
+		t.Errorf("GetURLOwner: expected user %d, got %+v %v %v", userID, owner, ok, err)
+	}
+	if _, ok, err = s.GetURLOwner(ctx, uniqueID(t)); err != nil || ok {
+		t.Errorf("GetURLOwner of unknown ID: expected not found, got %v %v", ok, err)
+	}
+}
+
+func testDeleteQueue(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	jobID := uniqueID(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tasks := []models.DeleteTask{
+		{JobID: jobID, RequestedBy: userID, CreatedAt: now, UserID: userID, ShortURL: "retried", NextAttemptAt: now},
+		{JobID: jobID, UserID: userID, ShortURL: "dead", NextAttemptAt: now},
+		{JobID: jobID, UserID: userID, ShortURL: "finished", NextAttemptAt: now},
+		{JobID: jobID, UserID: userID, ShortURL: "later", NextAttemptAt: now.Add(time.Hour)},
+	}
+	pendingBefore, err := s.PendingDeletes(ctx)
+	if err != nil {
+		t.Fatalf("PendingDeletes: expected no error, got %v", err)
+	}
+	if err := s.EnqueueDeletes(ctx, tasks); err != nil {
+		t.Fatalf("EnqueueDeletes: expected no error, got %v", err)
+	}
+
+	// jobTasks оставляет задачи этого теста: очередь может быть общей с другими подтестами
+	jobTasks := func(tasks []models.DeleteTask, err error) map[string]models.DeleteTask {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		byURL := make(map[string]models.DeleteTask)
+		for _, task := range tasks {
+			if task.JobID == jobID {
+				byURL[task.ShortURL] = task
+			}
+		}
+		return byURL
+	}
+
+	due := jobTasks(s.DueDeletes(ctx, now, 1000))
+	if len(due) != 3 {
+		t.Fatalf("DueDeletes: expected 3 due tasks, got %v", due)
+	}
+	if due["retried"].ID == 0 || due["retried"].ID >= due["dead"].ID || due["dead"].ID >= due["finished"].ID {
+		t.Errorf("DueDeletes: expected increasing IDs in enqueue order, got %v", due)
+	}
+	if got := due["retried"]; got.RequestedBy != userID || !got.CreatedAt.Equal(now) {
+		t.Errorf("DueDeletes: expected requester %d and creation time %v, got %+v", userID, now, got)
+	}
+
+	retried := due["retried"]
+	retried.Attempts = 1
+	retried.LastError = "boom"
+	retried.NextAttemptAt = now.Add(time.Minute)
+	dead := due["dead"]
+	dead.Attempts = 8
+	dead.DeadAt = &now
+	if err := s.UpdateDeleteTasks(ctx, []models.DeleteTask{retried, dead}); err != nil {
+		t.Fatalf("UpdateDeleteTasks: expected no error, got %v", err)
+	}
+	if err := s.FinishDeletes(ctx, []int64{due["finished"].ID}); err != nil {
+		t.Fatalf("FinishDeletes: expected no error, got %v", err)
+	}
+
+	if due = jobTasks(s.DueDeletes(ctx, now, 1000)); len(due) != 0 {
+		t.Errorf("DueDeletes: expected no due tasks, got %v", due)
+	}
+	due = jobTasks(s.DueDeletes(ctx, now.Add(time.Minute), 1000))
+	if got, ok := due["retried"]; len(due) != 1 || !ok || got.Attempts != 1 || got.LastError != "boom" {
+		t.Errorf("DueDeletes after backoff: expected only retried task, got %v", due)
+	}
+	deadTasks := jobTasks(s.DeadDeletes(ctx))
+	if got, ok := deadTasks["dead"]; len(deadTasks) != 1 || !ok || got.DeadAt == nil || got.Attempts != 8 {
+		t.Errorf("DeadDeletes: expected only dead task, got %v", deadTasks)
+	}
+	if due = jobTasks(s.DueDeletes(ctx, now.Add(2*time.Hour), 1000)); len(due) != 2 {
+		t.Errorf("DueDeletes: expected retried and later tasks, got %v", due)
+	}
+	listed := jobTasks(s.ListDeletes(ctx))
+	if _, ok := listed["later"]; len(listed) != 2 || !ok {
+		t.Errorf("ListDeletes: expected retried and later tasks, got %v", listed)
+	}
+	if pending, err := s.PendingDeletes(ctx); err != nil || pending-pendingBefore != 2 {
+		t.Errorf("PendingDeletes: expected retried and later tasks to be pending, got %d more %v", pending-pendingBefore, err)
+	}
+}
+
+func testConcurrentEnqueueDeletes(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	jobID := uniqueID(t)
+	const workers = 8
+	const perWorker = 10
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				shortID, err := id.New()
+				if err != nil {
+					t.Errorf("Cannot generate ID: %v", err)
+					return
+				}
+				task := models.DeleteTask{JobID: jobID, UserID: userID, ShortURL: shortID, NextAttemptAt: time.Now().UTC().Add(time.Hour)}
+				if err = s.EnqueueDeletes(ctx, []models.DeleteTask{task}); err != nil {
+					t.Errorf("EnqueueDeletes: expected no error, got %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tasks, err := s.ListDeletes(ctx)
+	if err != nil {
+		t.Fatalf("ListDeletes: expected no error, got %v", err)
+	}
+	ids := make(map[int64]struct{})
+	for _, task := range tasks {
+		if task.JobID == jobID {
+			ids[task.ID] = struct{}{}
+		}
+	}
+	if len(ids) != workers*perWorker {
+		t.Errorf("EnqueueDeletes: expected %d tasks with distinct IDs, got %d", workers*perWorker, len(ids))
+	}
+}
+
+func testTrashRestorePurge(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	otherID := newUser(t, s)
+	deletedID := uniqueID(t)
+	keptID := uniqueID(t)
+
+	for _, shortID := range []string{deletedID, keptID} {
+		if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID}); err != nil {
+			t.Fatalf("AddURL: expected no error, got %v", err)
+		}
+	}
+	if err := s.AddClicks(ctx, []models.Click{{ShortURL: deletedID, ClickedAt: time.Now().UTC()}}); err != nil {
+		t.Fatalf("AddClicks: expected no error, got %v", err)
+	}
+	before := time.Now().Add(-time.Second)
+	if _, err := s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: deletedID}}); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+
+	urls, err := s.GetUserURLs(ctx, userID)
+	if err != nil || len(urls) != 1 || urls[0].ShortURL != keptID {
+		t.Errorf("GetUserURLs: expected only %q, got %v %v", keptID, urls, err)
+	}
+	trash, err := s.GetDeletedURLs(ctx, userID)
+	if err != nil || len(trash) != 1 || trash[0].ShortURL != deletedID || trash[0].DeletedAt.Before(before) {
+		t.Errorf("GetDeletedURLs: expected %q deleted after %v, got %v %v", deletedID, before, trash, err)
+	}
+
+	deletion := []models.DeleteURL{{UserID: userID, ShortURL: deletedID}}
+	if restored, err := s.RestoreURLs(ctx, deletion, time.Now().Add(time.Hour)); err != nil || len(restored) != 0 {
+		t.Errorf("RestoreURLs outside retention: expected nothing restored, got %v %v", restored, err)
+	}
+	if restored, err := s.RestoreURLs(ctx, []models.DeleteURL{{UserID: otherID, ShortURL: deletedID}}, before); err != nil || len(restored) != 0 {
+		t.Errorf("RestoreURLs of foreign URL: expected nothing restored, got %v %v", restored, err)
+	}
+	if restored, err := s.RestoreURLs(ctx, deletion, before); err != nil || len(restored) != 1 {
+		t.Errorf("RestoreURLs: expected %q restored, got %v %v", deletedID, restored, err)
+	}
+	if _, ok, err := s.GetURL(ctx, deletedID); err != nil || !ok {
+		t.Errorf("GetURL of restored URL: expected found, got %v %v", ok, err)
+	}
+
+	if _, err = s.BatchDeleteURLs(ctx, deletion); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	if n, err := s.PurgeDeletedURLs(ctx, before); err != nil || n != 0 {
+		t.Errorf("PurgeDeletedURLs before deletion: expected nothing purged, got %d %v", n, err)
+	}
+	if n, err := s.PurgeDeletedURLs(ctx, time.Now().Add(time.Second)); err != nil || n < 1 {
+		t.Errorf("PurgeDeletedURLs: expected %q purged, got %d %v", deletedID, n, err)
+	}
+	if _, ok, err := s.GetURLOwner(ctx, deletedID); err != nil || ok {
+		t.Errorf("GetURLOwner of purged URL: expected not found, got %v %v", ok, err)
+	}
+	if clicks, err := s.GetClicks(ctx, deletedID, before.Add(-time.Hour), time.Now().Add(time.Hour)); err != nil || len(clicks) != 0 {
+		t.Errorf("GetClicks of purged URL: expected no clicks, got %d %v", len(clicks), err)
+	}
+	if _, ok, err := s.GetURL(ctx, keptID); err != nil || !ok {
+		t.Errorf("GetURL of kept URL: expected found, got %v %v", ok, err)
+	}
+}
+
+func testConcurrentRestorePurge(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	const links = 32
+	userID := newUser(t, s)
+
+	deletions := make([]models.DeleteURL, links)
+	for i := range deletions {
+		shortID := uniqueID(t)
+		if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID}); err != nil {
+			t.Fatalf("AddURL: expected no error, got %v", err)
+		}
+		deletions[i] = models.DeleteURL{UserID: userID, ShortURL: shortID}
+	}
+	before := time.Now().Add(-time.Hour)
+	if _, err := s.BatchDeleteURLs(ctx, deletions); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+
+	restored := make([][]models.DeleteURL, links)
+	var wg sync.WaitGroup
+	for i := range deletions {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if restored[i], err = s.RestoreURLs(ctx, deletions[i:i+1], before); err != nil {
+				t.Errorf("RestoreURLs: expected no error, got %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := s.PurgeDeletedURLs(ctx, time.Now().Add(time.Hour)); err != nil {
+				t.Errorf("PurgeDeletedURLs: expected no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, deletion := range deletions {
+		_, ok, err := s.GetURL(ctx, deletion.ShortURL)
+		if err != nil {
+			t.Fatalf("GetURL: expected no error, got %v", err)
+		}
+		if ok != (len(restored[i]) == 1) {
+			t.Errorf("GetURL(%q): restored %v, but found %v", deletion.ShortURL, restored[i], ok)
+		}
+	}
+}
+
+func testAPIKeys(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	otherID := newUser(t, s)
+	hash := uniqueID(t) + uniqueID(t)
+
+	key, err := s.AddAPIKey(ctx, models.APIKey{UserID: userID, Name: "ci", Prefix: "sk_test", Hash: hash, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("AddAPIKey: expected no error, got %v", err)
+	}
+	if key.ID == 0 {
+		t.Errorf("AddAPIKey: expected ID to be assigned")
+	}
+	if _, err = s.AddAPIKey(ctx, models.APIKey{UserID: otherID, Hash: hash, CreatedAt: time.Now().UTC()}); err == nil {
+		t.Errorf("AddAPIKey with duplicate hash: expected error")
+	}
+
+	found, ok, err := s.GetAPIKeyByHash(ctx, hash)
+	if err != nil || !ok || found.ID != key.ID || found.UserID != userID || found.Name != "ci" {
+		t.Errorf("GetAPIKeyByHash: expected %+v, got %+v %v %v", key, found, ok, err)
+	}
+	if _, ok, err = s.GetAPIKeyByHash(ctx, uniqueID(t)); err != nil || ok {
+		t.Errorf("GetAPIKeyByHash of unknown hash: expected not found, got %v %v", ok, err)
+	}
+
+	if ok, err = s.RevokeAPIKey(ctx, otherID, key.ID, time.Now()); err != nil || ok {
+		t.Errorf("RevokeAPIKey by other user: expected nothing revoked, got %v %v", ok, err)
+	}
+	if ok, err = s.RevokeAPIKey(ctx, userID, key.ID, time.Now()); err != nil || !ok {
+		t.Errorf("RevokeAPIKey: expected revoked, got %v %v", ok, err)
+	}
+	if ok, err = s.RevokeAPIKey(ctx, userID, key.ID, time.Now()); err != nil || ok {
+		t.Errorf("RevokeAPIKey twice: expected nothing revoked, got %v %v", ok, err)
+	}
+
+	keys, err := s.GetAPIKeys(ctx, userID)
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Errorf("GetAPIKeys: expected 1 revoked key, got %+v %v", keys, err)
+	}
+	if keys, err = s.GetAPIKeys(ctx, otherID); err != nil || len(keys) != 0 {
+		t.Errorf("GetAPIKeys of other user: expected none, got %+v %v", keys, err)
+	}
+}
+
+func testConcurrentAPIKeys(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	const workers = 16
+
+	keys := make([]models.APIKey, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID, err := s.GenerateUserID(ctx)
+			if err != nil {
+				t.Errorf("GenerateUserID: expected no error, got %v", err)
+				return
+			}
+			hash, err := id.New()
+			if err != nil {
+				t.Errorf("Cannot generate ID: %v", err)
+				return
+			}
+			keys[w], err = s.AddAPIKey(ctx, models.APIKey{UserID: userID, Hash: hash, CreatedAt: time.Now().UTC()})
+			if err != nil {
+				t.Errorf("AddAPIKey: expected no error, got %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	ids := make(map[int64]struct{})
+	for _, key := range keys {
+		if key.Hash == "" {
+			continue
+		}
+		ids[key.ID] = struct{}{}
+		found, ok, err := s.GetAPIKeyByHash(ctx, key.Hash)
+		if err != nil || !ok || found.ID != key.ID || found.UserID != key.UserID {
+			t.Errorf("GetAPIKeyByHash: expected key %d of user %d, got %+v %v %v", key.ID, key.UserID, found, ok, err)
+		}
+	}
+	if len(ids) != workers {
+		t.Errorf("AddAPIKey: expected %d distinct IDs, got %d", workers, len(ids))
+	}
+}
+
+func testAccounts(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	anonymousID := newUser(t, s)
+	name := strings.ToLower(uniqueID(t))
+	account := models.Account{UserID: userID, Username: name, Email: name + "@example.com", PasswordHash: "hash", RegisteredAt: time.Now().UTC()}
+
+	if err := s.RegisterUser(ctx, account); err != nil {
+		t.Fatalf("RegisterUser: expected no error, got %v", err)
+	}
+	if err := s.RegisterUser(ctx, models.Account{UserID: anonymousID, Username: name, PasswordHash: "hash", RegisteredAt: time.Now().UTC()}); !errors.Is(err, errors2.ErrAccountExists) {
+		t.Errorf("RegisterUser with taken username: expected ErrAccountExists, got %v", err)
+	}
+	if err := s.RegisterUser(ctx, models.Account{UserID: userID, Username: name + "2", PasswordHash: "hash", RegisteredAt: time.Now().UTC()}); !errors.Is(err, errors2.ErrAlreadyRegistered) {
+		t.Errorf("RegisterUser twice: expected ErrAlreadyRegistered, got %v", err)
+	}
+	for _, login := range []string{account.Username, account.Email} {
+		if got, ok, err := s.GetAccount(ctx, login); err != nil || !ok || got.UserID != userID || got.PasswordHash != "hash" {
+			t.Errorf("GetAccount(%q): expected user %d, got %+v %v %v", login, userID, got, ok, err)
+		}
+	}
+	if _, ok, err := s.GetAccountByUserID(ctx, anonymousID); err != nil || ok {
+		t.Errorf("GetAccountByUserID of anonymous user: expected not found, got %v %v", ok, err)
+	}
+
+	shared, own := uniqueID(t), uniqueID(t)
+	if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: uniqueID(t), OriginalURL: "http://example.com/" + shared}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	err := s.BatchAddURL(ctx, anonymousID, []database.InsertURL{
+		{ShortURL: shared, OriginalURL: "http://example.com/" + shared},
+		{ShortURL: own, OriginalURL: "http://example.com/" + own},
+	})
+	if err != nil {
+		t.Fatalf("BatchAddURL: expected no error, got %v", err)
+	}
+	anonymousKey, err := s.AddAPIKey(ctx, models.APIKey{UserID: anonymousID, Hash: uniqueID(t) + uniqueID(t), CreatedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatalf("AddAPIKey: expected no error, got %v", err)
+	}
+	mergedAt := time.Now().UTC().Truncate(time.Second)
+	merged, err := s.MergeUsers(ctx, anonymousID, userID, mergedAt)
+	if err != nil || merged != 1 {
+		t.Errorf("MergeUsers: expected 1 merged URL, got %d %v", merged, err)
+	}
+	key, ok, err := s.GetAPIKeyByHash(ctx, anonymousKey.Hash)
+	if err != nil || !ok || key.UserID != anonymousID || key.RevokedAt == nil || !key.RevokedAt.Equal(mergedAt) {
+		t.Errorf("GetAPIKeyByHash after merge: expected key of user %d revoked at %v, got %+v %v %v", anonymousID, mergedAt, key, ok, err)
+	}
+	if keys, err := s.GetAPIKeys(ctx, userID); err != nil || len(keys) != 0 {
+		t.Errorf("GetAPIKeys after merge: expected no keys of the account, got %v %v", keys, err)
+	}
+	if owner, _, err := s.GetURLOwner(ctx, own); err != nil || owner.UserID != userID {
+		t.Errorf("GetURLOwner after merge: expected %d, got %d %v", userID, owner.UserID, err)
+	}
+	if owner, _, err := s.GetURLOwner(ctx, shared); err != nil || owner.UserID != anonymousID {
+		t.Errorf("GetURLOwner of duplicate URL after merge: expected %d, got %d %v", anonymousID, owner.UserID, err)
+	}
+}
+
+func testOrgs(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	ownerID, memberID := newUser(t, s), newUser(t, s)
+
+	org, err := s.CreateOrg(ctx, models.Organization{Name: "Acme", CreatedAt: time.Now().UTC()}, ownerID)
+	if err != nil || org.ID == 0 {
+		t.Fatalf("CreateOrg: expected ID, got %+v %v", org, err)
+	}
+	if role, ok, err := s.GetOrgRole(ctx, org.ID, ownerID); err != nil || !ok || role != models.RoleOwner {
+		t.Errorf("GetOrgRole of creator: expected owner, got %q %v %v", role, ok, err)
+	}
+	if err = s.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID, UserID: memberID, Role: models.RoleViewer}); err != nil {
+		t.Fatalf("SetOrgMember: expected no error, got %v", err)
+	}
+	if err = s.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID, UserID: memberID, Role: models.RoleEditor}); err != nil {
+		t.Fatalf("SetOrgMember: expected no error on role change, got %v", err)
+	}
+	members, err := s.GetOrgMembers(ctx, org.ID)
+	if err != nil || len(members) != 2 || members[1] != (models.OrgMember{OrgID: org.ID, UserID: memberID, Role: models.RoleEditor}) {
+		t.Errorf("GetOrgMembers: expected owner and editor, got %+v %v", members, err)
+	}
+	orgs, err := s.GetUserOrgs(ctx, memberID)
+	if err != nil || len(orgs) != 1 || orgs[0].ID != org.ID || orgs[0].Name != "Acme" || orgs[0].Role != models.RoleEditor {
+		t.Errorf("GetUserOrgs: expected Acme as editor, got %+v %v", orgs, err)
+	}
+
+	// Один и тот же адрес может быть и личной ссылкой, и ссылкой организации
+	personalID, orgURLID := uniqueID(t), uniqueID(t)
+	longURL := "http://example.com/" + orgURLID
+	if err = s.AddURL(ctx, memberID, database.InsertURL{ShortURL: personalID, OriginalURL: longURL}); err != nil {
+		t.Fatalf("AddURL: expected no error, got %v", err)
+	}
+	if err = s.AddURL(ctx, memberID, database.InsertURL{ShortURL: orgURLID, OriginalURL: longURL, OrgID: org.ID}); err != nil {
+		t.Fatalf("AddURL to org: expected no error, got %v", err)
+	}
+	var duplicateErr *errors2.DuplicateURLError
+	err = s.AddURL(ctx, ownerID, database.InsertURL{ShortURL: uniqueID(t), OriginalURL: longURL, OrgID: org.ID})
+	if !errors.As(err, &duplicateErr) || duplicateErr.Error() != orgURLID {
+		t.Errorf("AddURL of org duplicate: expected DuplicateURLError with %s, got %v", orgURLID, err)
+	}
+	if owner, ok, err := s.GetURLOwner(ctx, orgURLID); err != nil || !ok || owner != (models.URLOwner{UserID: memberID, OrgID: org.ID}) {
+		t.Errorf("GetURLOwner of org URL: expected member in org, got %+v %v %v", owner, ok, err)
+	}
+	if urls, err := s.GetUserURLs(ctx, memberID); err != nil || len(urls) != 1 || urls[0].ShortURL != personalID {
+		t.Errorf("GetUserURLs: expected only personal URL, got %+v %v", urls, err)
+	}
+	if urls, err := s.GetOrgURLs(ctx, org.ID); err != nil || len(urls) != 1 || urls[0].ShortURL != orgURLID {
+		t.Errorf("GetOrgURLs: expected org URL, got %+v %v", urls, err)
+	}
+
+	if _, err = s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: memberID, ShortURL: orgURLID}}); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	if urls, err := s.GetDeletedOrgURLs(ctx, org.ID); err != nil || len(urls) != 1 || urls[0].ShortURL != orgURLID {
+		t.Errorf("GetDeletedOrgURLs: expected org URL, got %+v %v", urls, err)
+	}
+	if urls, err := s.GetDeletedURLs(ctx, memberID); err != nil || len(urls) != 0 {
+		t.Errorf("GetDeletedURLs: expected no personal deleted URLs, got %+v %v", urls, err)
+	}
+
+	if removed, err := s.RemoveOrgMember(ctx, org.ID, memberID); err != nil || !removed {
+		t.Errorf("RemoveOrgMember: expected removed, got %v %v", removed, err)
+	}
+	if removed, err := s.RemoveOrgMember(ctx, org.ID, memberID); err != nil || removed {
+		t.Errorf("RemoveOrgMember twice: expected not removed, got %v %v", removed, err)
+	}
+	if _, ok, err := s.GetOrgRole(ctx, org.ID, memberID); err != nil || ok {
+		t.Errorf("GetOrgRole of removed member: expected not found, got %v %v", ok, err)
+	}
+
+	if err = s.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID, UserID: ownerID, Role: models.RoleEditor}); !errors.Is(err, errors2.ErrLastOwner) {
+		t.Errorf("SetOrgMember of last owner: expected ErrLastOwner, got %v", err)
+	}
+	if removed, err := s.RemoveOrgMember(ctx, org.ID, ownerID); !errors.Is(err, errors2.ErrLastOwner) || removed {
+		t.Errorf("RemoveOrgMember of last owner: expected ErrLastOwner, got %v %v", removed, err)
+	}
+	if role, ok, err := s.GetOrgRole(ctx, org.ID, ownerID); err != nil || !ok || role != models.RoleOwner {
+		t.Errorf("GetOrgRole of last owner: expected owner, got %q %v %v", role, ok, err)
+	}
+	if err = s.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID + 1000, UserID: memberID, Role: models.RoleViewer}); !errors.Is(err, errors2.ErrOrgNotFound) {
+		t.Errorf("SetOrgMember of unknown org: expected ErrOrgNotFound, got %v", err)
+	}
+}
+
+func testConcurrentLastOwner(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	const rounds = 16
+
+	for i := 0; i < rounds; i++ {
+		firstID, secondID := newUser(t, s), newUser(t, s)
+		org, err := s.CreateOrg(ctx, models.Organization{Name: "Acme", CreatedAt: time.Now().UTC()}, firstID)
+		if err != nil {
+			t.Fatalf("CreateOrg: expected no error, got %v", err)
+		}
+		if err = s.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID, UserID: secondID, Role: models.RoleOwner}); err != nil {
+			t.Fatalf("SetOrgMember: expected no error, got %v", err)
+		}
+
+		// Один владелец понижается, другой исключается: пройти может только одно изменение
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs[0] = s.SetOrgMember(ctx, models.OrgMember{OrgID: org.ID, UserID: firstID, Role: models.RoleViewer})
+		}()
+		go func() {
+			defer wg.Done()
+			_, errs[1] = s.RemoveOrgMember(ctx, org.ID, secondID)
+		}()
+		wg.Wait()
+
+		failed := 0
+		for _, err := range errs {
+			if errors.Is(err, errors2.ErrLastOwner) {
+				failed++
+			} else if err != nil {
+				t.Fatalf("expected no error or ErrLastOwner, got %v", err)
+			}
+		}
+		if failed != 1 {
+			t.Errorf("expected exactly one change to fail with ErrLastOwner, got %v", errs)
+		}
+		members, err := s.GetOrgMembers(ctx, org.ID)
+		if err != nil {
+			t.Fatalf("GetOrgMembers: expected no error, got %v", err)
+		}
+		owners := 0
+		for _, member := range members {
+			if member.Role == models.RoleOwner {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("GetOrgMembers: expected one owner, got %+v", members)
+		}
+	}
+}
+
+func testConcurrentCreateOrg(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	const workers = 16
+
+	orgs := make([]models.Organization, workers)
+	owners := make([]int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID, err := s.GenerateUserID(ctx)
+			if err != nil {
+				t.Errorf("GenerateUserID: expected no error, got %v", err)
+				return
+			}
+			owners[w] = userID
+			if orgs[w], err = s.CreateOrg(ctx, models.Organization{Name: "Acme", CreatedAt: time.Now().UTC()}, userID); err != nil {
+				t.Errorf("CreateOrg: expected no error, got %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	ids := make(map[int64]struct{})
+	for w, org := range orgs {
+		ids[org.ID] = struct{}{}
+		members, err := s.GetOrgMembers(ctx, org.ID)
+		if err != nil || len(members) != 1 || members[0].UserID != owners[w] {
+			t.Errorf("GetOrgMembers(%d): expected only owner %d, got %v %v", org.ID, owners[w], members, err)
+		}
+	}
+	if len(ids) != workers {
+		t.Errorf("CreateOrg: expected %d distinct IDs, got %d", workers, len(ids))
+	}
+}
+
+func testURLHistory(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	editorID := newUser(t, s)
+	shortID := uniqueID(t)
+	otherID := uniqueID(t)
+	oldURL := "http://example.com/" + shortID
+	newURL := oldURL + "/fixed"
+
+	for _, id := range []string{shortID, otherID} {
+		if err := s.AddURL(ctx, userID, database.InsertURL{ShortURL: id, OriginalURL: "http://example.com/" + id}); err != nil {
+			t.Fatalf("AddURL: expected no error, got %v", err)
+		}
+	}
+
+	changedAt := time.Now().UTC().Truncate(time.Second)
+	if err := s.UpdateURL(ctx, shortID, newURL, editorID, changedAt); err != nil {
+		t.Fatalf("UpdateURL: expected no error, got %v", err)
+	}
+	if url, ok, err := s.GetURL(ctx, shortID); err != nil || !ok || url != newURL {
+		t.Errorf("GetURL: expected %q, got %q %v %v", newURL, url, ok, err)
+	}
+	if err := s.UpdateURL(ctx, shortID, newURL, userID, changedAt); err != nil {
+		t.Errorf("UpdateURL to the same URL: expected no error, got %v", err)
+	}
+	history, err := s.GetURLHistory(ctx, shortID)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("GetURLHistory: expected 1 change, got %v %v", history, err)
+	}
+	if history[0].PreviousURL != oldURL || history[0].ChangedBy != editorID || !history[0].ChangedAt.Equal(changedAt) {
+		t.Errorf("GetURLHistory: expected %q changed by %d at %v, got %+v", oldURL, editorID, changedAt, history[0])
+	}
+
+	// Старый адрес освободился, а занятый другой ссылкой адрес использовать нельзя
+	if err = s.AddURL(ctx, userID, database.InsertURL{ShortURL: uniqueID(t), OriginalURL: oldURL}); err != nil {
+		t.Errorf("AddURL with previous URL: expected no error, got %v", err)
+	}
+	var dupErr *errors2.DuplicateURLError
+	if err = s.UpdateURL(ctx, shortID, "http://example.com/"+otherID, userID, changedAt); !errors.As(err, &dupErr) || dupErr.Error() != otherID {
+		t.Errorf("UpdateURL to existing URL: expected DuplicateURLError %q, got %v", otherID, err)
+	}
+
+	if err = s.UpdateURL(ctx, uniqueID(t), newURL, userID, changedAt); !errors.Is(err, errors2.ErrURLNotFound) {
+		t.Errorf("UpdateURL of unknown URL: expected ErrURLNotFound, got %v", err)
+	}
+	if _, err = s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: otherID}}); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	if err = s.UpdateURL(ctx, otherID, newURL+"/2", userID, changedAt); !errors.Is(err, errors2.ErrURLDeleted) {
+		t.Errorf("UpdateURL of deleted URL: expected ErrURLDeleted, got %v", err)
+	}
+}
+
+func testQuotas(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	userID := newUser(t, s)
+	now := time.Now().UTC()
+
+	if quota, err := s.GetUserQuota(ctx, userID); err != nil || quota.MaxLinks != nil || quota.MaxBatchSize != nil {
+		t.Errorf("GetUserQuota without overrides: expected empty quota, got %+v %v", quota, err)
+	}
+	maxLinks, maxBatchSize := 3, 0
+	if err := s.SetUserQuota(ctx, userID, models.UserQuota{MaxLinks: &maxLinks, MaxBatchSize: &maxBatchSize}); err != nil {
+		t.Fatalf("SetUserQuota: expected no error, got %v", err)
+	}
+	maxLinks = 100
+	quota, err := s.GetUserQuota(ctx, userID)
+	if err != nil || quota.MaxLinks == nil || *quota.MaxLinks != 3 || quota.MaxBatchSize == nil || *quota.MaxBatchSize != 0 {
+		t.Errorf("GetUserQuota: expected max_links 3 and max_batch_size 0, got %+v %v", quota, err)
+	}
+	if err = s.SetUserQuota(ctx, userID, models.UserQuota{}); err != nil {
+		t.Fatalf("SetUserQuota reset: expected no error, got %v", err)
+	}
+	if quota, err = s.GetUserQuota(ctx, userID); err != nil || quota.MaxLinks != nil || quota.MaxBatchSize != nil {
+		t.Errorf("GetUserQuota after reset: expected empty quota, got %+v %v", quota, err)
+	}
+	if err = s.SetUserQuota(ctx, userID+1000000, models.UserQuota{MaxLinks: &maxLinks}); !errors.Is(err, errors2.ErrUserNotFound) {
+		t.Errorf("SetUserQuota of unknown user: expected ErrUserNotFound, got %v", err)
+	}
+
+	// Удалённые и истёкшие ссылки не занимают квоту, ссылки организаций занимают
+	org, err := s.CreateOrg(ctx, models.Organization{Name: "Quota", CreatedAt: now}, userID)
+	if err != nil {
+		t.Fatalf("CreateOrg: expected no error, got %v", err)
+	}
+	active, deleted, expiring, orgURL := uniqueID(t), uniqueID(t), uniqueID(t), uniqueID(t)
+	expiresAt := now.Add(time.Hour)
+	err = s.BatchAddURL(ctx, userID, []database.InsertURL{
+		{ShortURL: active, OriginalURL: "http://example.com/" + active},
+		{ShortURL: deleted, OriginalURL: "http://example.com/" + deleted},
+		{ShortURL: expiring, OriginalURL: "http://example.com/" + expiring, ExpiresAt: &expiresAt},
+		{ShortURL: orgURL, OriginalURL: "http://example.com/" + orgURL, OrgID: org.ID},
+	})
+	if err != nil {
+		t.Fatalf("BatchAddURL: expected no error, got %v", err)
+	}
+	if _, err = s.BatchDeleteURLs(ctx, []models.DeleteURL{{UserID: userID, ShortURL: deleted}}); err != nil {
+		t.Fatalf("BatchDeleteURLs: expected no error, got %v", err)
+	}
+	if n, err := s.CountUserURLs(ctx, userID, now); err != nil || n != 3 {
+		t.Errorf("CountUserURLs: expected 3, got %d %v", n, err)
+	}
+	if n, err := s.CountUserURLs(ctx, userID, expiresAt); err != nil || n != 2 {
+		t.Errorf("CountUserURLs after expiry: expected 2, got %d %v", n, err)
+	}
+}
+
+func testConcurrent(t *testing.T, s storage.URLStorage) {
+	ctx := context.Background()
+	const workers = 8
+	const perWorker = 20
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID, err := s.GenerateUserID(ctx)
+			if err != nil {
+				t.Errorf("GenerateUserID: expected no error, got %v", err)
+				return
+			}
+			var added []models.DeleteURL
+			for i := 0; i < perWorker; i++ {
+				shortID, err := id.New()
+				if err != nil {
+					t.Errorf("Cannot generate ID: %v", err)
+					return
+				}
+				if err = s.AddURL(ctx, userID, database.InsertURL{ShortURL: shortID, OriginalURL: "http://example.com/" + shortID}); err != nil {
+					t.Errorf("AddURL: expected no error, got %v", err)
+					continue
+				}
+				added = append(added, models.DeleteURL{UserID: userID, ShortURL: shortID})
+				if _, ok, err := s.GetURL(ctx, shortID); err != nil || !ok {
+					t.Errorf("GetURL: expected found, got %v %v", ok, err)
+				}
+			}
+			urls, err := s.GetUserURLs(ctx, userID)
+			if err != nil || len(urls) != len(added) {
+				t.Errorf("GetUserURLs: expected %d URLs, got %d %v", len(added), len(urls), err)
+			}
+			if _, err = s.BatchDeleteURLs(ctx, added[:len(added)/2]); err != nil {
+				t.Errorf("BatchDeleteURLs: expected no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}